@@ -0,0 +1,381 @@
+package main
+
+import (
+	"image"
+	"image/draw"
+	"math"
+	"runtime"
+	"sync"
+)
+
+// claheTiles is how many tiles CLAHE divides each side of the image into,
+// and claheClipLimit is how many times the average bin count a tile's
+// histogram may hold before the excess is redistributed.
+const (
+	claheTiles     = 8
+	claheClipLimit = 3.0
+)
+
+// imageAdjust is how the displayed image is derived from the decoded one.
+// It is comparable so it can key the decoded image cache.
+type imageAdjust struct {
+	autoContrast bool    // stretch the histogram to the full range
+	clip         float64 // percent of each channel clipped at either end; 0 stretches the overall min/max
+	brightness   int     // added to every channel, -100 to 100 percent
+	gamma        int     // percent, 100 is linear
+	clahe        bool    // contrast limited adaptive histogram equalisation
+
+	// window and level map 16-bit images to 8 bits for display: values from
+	// level-window/2 to level+window/2 are spread over the display range. A
+	// zero window fits each image's own range.
+	window int
+	level  int
+}
+
+// defaultAdjust leaves images untouched.
+var defaultAdjust = imageAdjust{gamma: 100}
+
+func (a imageAdjust) identity() bool {
+	return !a.autoContrast && !a.clahe && a.brightness == 0 && (a.gamma == 100 || a.gamma <= 0)
+}
+
+// setAdjust changes the image adjustment and redisplays the current image.
+func (m *appModel) setAdjust(a imageAdjust) {
+	if a == m.adjust {
+		return
+	}
+	m.adjust = a
+	m.regenerateDisplayImage()
+}
+
+// adjustImage returns a copy of src with a applied, or src itself if a
+// changes nothing. It works on RGBA pixel buffers, split into row bands
+// processed in parallel, so it takes milliseconds even on large frames.
+// 16-bit images are always converted, through a's window and level.
+func adjustImage(src image.Image, a imageAdjust) image.Image {
+	var dst *image.RGBA
+	switch {
+	case isHighDepth(src):
+		dst = windowRGBA(src, a.window, a.level)
+	case a.identity():
+		return src
+	default:
+		dst = toRGBA(src)
+	}
+	if a.identity() {
+		return dst
+	}
+	if a.clahe {
+		claheRGBA(dst)
+	}
+	applyLUT(dst, adjustLUT(dst, a))
+	return dst
+}
+
+// parallelRows calls f on bands of rows [y0, y1) covering height, one per CPU.
+func parallelRows(height int, f func(y0, y1 int)) {
+	if height <= 0 {
+		return
+	}
+	bands := min(runtime.GOMAXPROCS(0), height)
+	if bands <= 1 {
+		f(0, height)
+		return
+	}
+	var wg sync.WaitGroup
+	for i := range bands {
+		y0, y1 := height*i/bands, height*(i+1)/bands
+		wg.Go(func() { f(y0, y1) })
+	}
+	wg.Wait()
+}
+
+// toRGBA copies src into a new zero-origin RGBA image. image/draw has fast
+// paths for the RGBA, NRGBA, YCbCr and Gray images the decoders produce.
+func toRGBA(src image.Image) *image.RGBA {
+	b := src.Bounds()
+	dst := image.NewRGBA(image.Rectangle{Max: b.Size()})
+	parallelRows(b.Dy(), func(y0, y1 int) {
+		r := image.Rect(0, y0, b.Dx(), y1)
+		draw.Draw(dst, r, src, b.Min.Add(r.Min), draw.Src)
+	})
+	return dst
+}
+
+// windowRGBA converts a 16-bit image to a new zero-origin RGBA image,
+// mapping level-window/2 to black and level+window/2 to white, or the
+// image's darkest and brightest values if window is zero.
+func windowRGBA(src image.Image, window, level int) *image.RGBA {
+	var pix []uint8
+	var stride, channels int
+	switch src := src.(type) {
+	case *image.Gray16:
+		pix, stride, channels = src.Pix, src.Stride, 1
+	case *image.RGBA64:
+		pix, stride, channels = src.Pix, src.Stride, 4
+	case *image.NRGBA64:
+		pix, stride, channels = src.Pix, src.Stride, 4
+	default:
+		return toRGBA(src)
+	}
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	colours := min(channels, 3)
+	sample := func(row []uint8, x, c int) int {
+		i := (x*channels + c) * 2
+		return int(row[i])<<8 | int(row[i+1])
+	}
+
+	lo, hi := level-window/2, level+window/2
+	if window <= 0 {
+		var mu sync.Mutex
+		lo, hi = 0xffff, 0
+		parallelRows(h, func(y0, y1 int) {
+			bandLo, bandHi := 0xffff, 0
+			for y := y0; y < y1; y++ {
+				row := pix[y*stride:]
+				for x := range w {
+					for c := range colours {
+						v := sample(row, x, c)
+						bandLo, bandHi = min(bandLo, v), max(bandHi, v)
+					}
+				}
+			}
+			mu.Lock()
+			lo, hi = min(lo, bandLo), max(hi, bandHi)
+			mu.Unlock()
+		})
+	}
+	lut := make([]uint8, 0x10000)
+	for v := range lut {
+		if hi > lo {
+			lut[v] = uint8(math.Round(clamp01(float64(v-lo)/float64(hi-lo)) * 255))
+		} else {
+			lut[v] = uint8(v >> 8)
+		}
+	}
+
+	dst := image.NewRGBA(image.Rectangle{Max: b.Size()})
+	parallelRows(h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			row := pix[y*stride:]
+			out := dst.Pix[y*dst.Stride:]
+			for x := range w {
+				o := out[x*4 : x*4+4]
+				for c := range 3 {
+					o[c] = lut[sample(row, x, min(c, colours-1))]
+				}
+				o[3] = 0xff
+			}
+		}
+	})
+	return dst
+}
+
+// rowSpan returns the bytes of img's pixel rows [y0, y1).
+func rowSpan(img *image.RGBA, y0, y1 int) []uint8 {
+	return img.Pix[y0*img.Stride : y0*img.Stride+(y1-y0-1)*img.Stride+img.Rect.Dx()*4]
+}
+
+// histograms counts each colour channel's values.
+func histograms(img *image.RGBA) [3][256]int {
+	var mu sync.Mutex
+	var total [3][256]int
+	parallelRows(img.Rect.Dy(), func(y0, y1 int) {
+		var h [3][256]int
+		for y := y0; y < y1; y++ {
+			row := rowSpan(img, y, y+1)
+			for i := 0; i < len(row); i += 4 {
+				h[0][row[i]]++
+				h[1][row[i+1]]++
+				h[2][row[i+2]]++
+			}
+		}
+		mu.Lock()
+		for c := range h {
+			for v, n := range h[c] {
+				total[c][v] += n
+			}
+		}
+		mu.Unlock()
+	})
+	return total
+}
+
+// percentile returns the lowest value at or below which at least frac of
+// the histogram's counts fall.
+func percentile(h *[256]int, frac float64) int {
+	total := 0
+	for _, n := range h {
+		total += n
+	}
+	target := int(math.Ceil(frac * float64(total)))
+	sum := 0
+	for v, n := range h {
+		sum += n
+		if sum >= max(target, 1) {
+			return v
+		}
+	}
+	return 255
+}
+
+// adjustLUT builds the per-channel lookup table for the stretch, brightness
+// and gamma parts of a.
+func adjustLUT(img *image.RGBA, a imageAdjust) [3][256]uint8 {
+	lo, hi := [3]int{0, 0, 0}, [3]int{255, 255, 255}
+	if a.autoContrast {
+		h := histograms(img)
+		if a.clip > 0 {
+			for c := range h {
+				lo[c] = percentile(&h[c], a.clip/100)
+				hi[c] = percentile(&h[c], 1-a.clip/100)
+			}
+		} else {
+			// A shared range keeps the colour balance, as before.
+			minV, maxV := 255, 0
+			for c := range h {
+				minV = min(minV, percentile(&h[c], 0))
+				maxV = max(maxV, percentile(&h[c], 1))
+			}
+			lo, hi = [3]int{minV, minV, minV}, [3]int{maxV, maxV, maxV}
+		}
+	}
+	gamma := 1.0
+	if a.gamma > 0 {
+		gamma = 100 / float64(a.gamma)
+	}
+	var lut [3][256]uint8
+	for c := range lut {
+		for v := range lut[c] {
+			f := float64(v) / 255
+			// Flat channels have nothing to stretch.
+			if hi[c] > lo[c] {
+				f = float64(v-lo[c]) / float64(hi[c]-lo[c])
+			}
+			f = math.Pow(clamp01(f), gamma) + float64(a.brightness)/100
+			lut[c][v] = uint8(math.Round(clamp01(f) * 255))
+		}
+	}
+	return lut
+}
+
+func clamp01(f float64) float64 {
+	return min(max(f, 0), 1)
+}
+
+func applyLUT(img *image.RGBA, lut [3][256]uint8) {
+	parallelRows(img.Rect.Dy(), func(y0, y1 int) {
+		row := rowSpan(img, y0, y1)
+		for i := 0; i < len(row); i += 4 {
+			row[i] = lut[0][row[i]]
+			row[i+1] = lut[1][row[i+1]]
+			row[i+2] = lut[2][row[i+2]]
+		}
+	})
+}
+
+// luma is the Rec. 601 brightness of an RGB pixel.
+func luma(r, g, b uint8) uint8 {
+	return uint8((299*int(r) + 587*int(g) + 114*int(b) + 500) / 1000)
+}
+
+// claheRGBA equalises img's brightness in place with contrast limited
+// adaptive histogram equalisation, scaling each pixel's channels by the
+// change in its luma so colours are kept.
+func claheRGBA(img *image.RGBA) {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	tilesX, tilesY := min(claheTiles, w), min(claheTiles, h)
+	if tilesX == 0 || tilesY == 0 {
+		return
+	}
+	tileW, tileH := float64(w)/float64(tilesX), float64(h)/float64(tilesY)
+
+	// One equalisation mapping per tile.
+	maps := make([][256]uint8, tilesX*tilesY)
+	var wg sync.WaitGroup
+	for t := range maps {
+		wg.Go(func() {
+			tx, ty := t%tilesX, t/tilesX
+			x0, x1 := int(float64(tx)*tileW), int(float64(tx+1)*tileW)
+			y0, y1 := int(float64(ty)*tileH), int(float64(ty+1)*tileH)
+			var hist [256]int
+			for y := y0; y < y1; y++ {
+				row := rowSpan(img, y, y+1)
+				for i := x0 * 4; i < x1*4; i += 4 {
+					hist[luma(row[i], row[i+1], row[i+2])]++
+				}
+			}
+			maps[t] = claheMapping(&hist, (x1-x0)*(y1-y0))
+		})
+	}
+	wg.Wait()
+
+	// Each pixel blends the mappings of the four nearest tile centres. The
+	// horizontal tiles and weights are the same for every row.
+	tx0s, tx1s, wxs := make([]int, w), make([]int, w), make([]float64, w)
+	for x := range w {
+		fx := clampTile((float64(x)+0.5)/tileW-0.5, tilesX)
+		tx0s[x] = int(fx)
+		tx1s[x] = min(tx0s[x]+1, tilesX-1)
+		wxs[x] = fx - float64(tx0s[x])
+	}
+	parallelRows(h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			fy := clampTile((float64(y)+0.5)/tileH-0.5, tilesY)
+			ty0 := int(fy)
+			ty1 := min(ty0+1, tilesY-1)
+			wy := fy - float64(ty0)
+			row := rowSpan(img, y, y+1)
+			top, bottom := maps[ty0*tilesX:], maps[ty1*tilesX:]
+			for x := range w {
+				tx0, tx1, wx := tx0s[x], tx1s[x], wxs[x]
+				i := x * 4
+				l := luma(row[i], row[i+1], row[i+2])
+				t := float64(top[tx0][l])*(1-wx) + float64(top[tx1][l])*wx
+				b := float64(bottom[tx0][l])*(1-wx) + float64(bottom[tx1][l])*wx
+				nl := t*(1-wy) + b*wy
+				if l == 0 {
+					v := uint8(math.Round(nl))
+					row[i], row[i+1], row[i+2] = v, v, v
+					continue
+				}
+				scale := nl / float64(l)
+				for c := range 3 {
+					row[i+c] = uint8(min(float64(row[i+c])*scale+0.5, 255))
+				}
+			}
+		}
+	})
+}
+
+func clampTile(f float64, tiles int) float64 {
+	return min(max(f, 0), float64(tiles-1))
+}
+
+// claheMapping clips hist at claheClipLimit times its average, spreads the
+// excess evenly across all bins and returns the resulting equalisation.
+func claheMapping(hist *[256]int, pixels int) [256]uint8 {
+	var mapping [256]uint8
+	if pixels == 0 {
+		return mapping
+	}
+	limit := max(1, int(claheClipLimit*float64(pixels)/256))
+	excess := 0
+	for v, n := range hist {
+		if n > limit {
+			excess += n - limit
+			hist[v] = limit
+		}
+	}
+	share, extra := excess/256, excess%256
+	sum := 0
+	for v := range hist {
+		sum += hist[v] + share
+		if v < extra {
+			sum++
+		}
+		mapping[v] = uint8(min(255, sum*255/pixels))
+	}
+	return mapping
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestPercentile(t *testing.T) {
+	var h [256]int
+	h[10], h[20], h[200] = 1, 8, 1
+	tests := []struct {
+		frac float64
+		want int
+	}{
+		{0, 10},
+		{0.1, 10},
+		{0.11, 20},
+		{0.9, 20},
+		{0.95, 200},
+		{1, 200},
+	}
+	for _, tt := range tests {
+		if got := percentile(&h, tt.frac); got != tt.want {
+			t.Errorf("percentile(%v) = %d, want %d", tt.frac, got, tt.want)
+		}
+	}
+	var empty [256]int
+	if got := percentile(&empty, 0.5); got != 255 {
+		t.Errorf("percentile of an empty histogram = %d, want 255", got)
+	}
+}
+
+func TestAdjustLUT(t *testing.T) {
+	// A dim image: every channel runs from 50 to 150, bar one bright pixel.
+	img := image.NewRGBA(image.Rect(0, 0, 101, 2))
+	for x := range 101 {
+		v := uint8(50 + x)
+		img.SetRGBA(x, 0, color.RGBA{v, v, v, 255})
+		img.SetRGBA(x, 1, color.RGBA{v, v, v, 255})
+	}
+	img.SetRGBA(0, 1, color.RGBA{250, 250, 250, 255})
+
+	tests := []struct {
+		name   string
+		adjust imageAdjust
+		want   map[uint8]uint8 // input to output for the red channel
+	}{
+		{"identity", defaultAdjust, map[uint8]uint8{0: 0, 100: 100, 255: 255}},
+		{"brightness", imageAdjust{gamma: 100, brightness: 20}, map[uint8]uint8{0: 51, 100: 151, 240: 255}},
+		{"darken", imageAdjust{gamma: 100, brightness: -100}, map[uint8]uint8{0: 0, 255: 0}},
+		{"gamma", imageAdjust{gamma: 200}, map[uint8]uint8{0: 0, 64: 128, 255: 255}},
+		{"stretch min/max", imageAdjust{gamma: 100, autoContrast: true}, map[uint8]uint8{50: 0, 150: 128, 250: 255}},
+		{"clip", imageAdjust{gamma: 100, autoContrast: true, clip: 1}, map[uint8]uint8{40: 0, 51: 0, 100: 126, 150: 255}},
+	}
+	for _, tt := range tests {
+		lut := adjustLUT(img, tt.adjust)
+		for in, want := range tt.want {
+			if got := lut[0][in]; got != want {
+				t.Errorf("%s: %d maps to %d, want %d", tt.name, in, got, want)
+			}
+		}
+	}
+}
+
+func TestClaheMapping(t *testing.T) {
+	tests := []struct {
+		name   string
+		hist   func(h *[256]int)
+		pixels int
+		want   map[int]uint8
+	}{
+		{
+			name:   "flat histogram",
+			hist:   func(h *[256]int) { fill(h, 0, 256, 4) },
+			pixels: 1024,
+			want:   map[int]uint8{0: 0, 127: 127, 255: 255},
+		},
+		{
+			name:   "spike is clipped",
+			hist:   func(h *[256]int) { h[100] = 1024 },
+			pixels: 1024,
+			// Only 12 of the 1024 stay in the spike; the rest is spread out.
+			want: map[int]uint8{0: 0, 99: 99, 100: 103, 255: 255},
+		},
+		{
+			name:   "empty tile",
+			hist:   func(h *[256]int) {},
+			pixels: 0,
+			want:   map[int]uint8{0: 0, 255: 0},
+		},
+	}
+	for _, tt := range tests {
+		var h [256]int
+		tt.hist(&h)
+		mapping := claheMapping(&h, tt.pixels)
+		for in, want := range tt.want {
+			if got := mapping[in]; got != want {
+				t.Errorf("%s: %d maps to %d, want %d", tt.name, in, got, want)
+			}
+		}
+		for v := 1; v < 256; v++ {
+			if mapping[v] < mapping[v-1] {
+				t.Errorf("%s: mapping decreases at %d", tt.name, v)
+				break
+			}
+		}
+	}
+}
+
+// fill sets h[from:to] to n.
+func fill(h *[256]int, from, to, n int) {
+	for v := from; v < to; v++ {
+		h[v] = n
+	}
+}
+
+func TestAdjustImage(t *testing.T) {
+	grey := image.NewGray(image.Rect(5, 5, 7, 6))
+	grey.Pix = []byte{100, 200}
+	if got := adjustImage(grey, defaultAdjust); got != image.Image(grey) {
+		t.Errorf("the identity adjustment copied the image")
+	}
+
+	deep := image.NewGray16(image.Rect(0, 0, 3, 1))
+	for x, v := range []uint16{1000, 2000, 3000} {
+		deep.SetGray16(x, 0, color.Gray16{v})
+	}
+	tests := []struct {
+		name   string
+		src    image.Image
+		adjust imageAdjust
+		want   []uint8 // the red channel
+	}{
+		{"8-bit brightened", grey, imageAdjust{gamma: 100, brightness: 10}, []uint8{126, 226}},
+		{"16-bit fitted to its range", deep, defaultAdjust, []uint8{0, 128, 255}},
+		{"16-bit window", deep, imageAdjust{gamma: 100, window: 1000, level: 2500}, []uint8{0, 0, 255}},
+	}
+	for _, tt := range tests {
+		got, ok := adjustImage(tt.src, tt.adjust).(*image.RGBA)
+		if !ok {
+			t.Errorf("%s: adjustImage didn't give an RGBA image", tt.name)
+			continue
+		}
+		if got.Rect.Min != (image.Point{}) || got.Rect.Dx() != len(tt.want) {
+			t.Errorf("%s: bounds %v", tt.name, got.Rect)
+			continue
+		}
+		for x, want := range tt.want {
+			if c := got.RGBAAt(x, 0); c.R != want || c.G != want || c.A != 255 {
+				t.Errorf("%s: pixel %d = %v, want %d", tt.name, x, c, want)
+			}
+		}
+	}
+}
+
+func TestClaheRGBA(t *testing.T) {
+	// Low contrast texture, from 100 to 107 in every tile, gains contrast
+	// but keeps its order.
+	img := image.NewRGBA(image.Rect(0, 0, 256, 256))
+	for y := range 256 {
+		for x := range 256 {
+			v := uint8(100 + (x+y)%8)
+			img.SetRGBA(x, y, color.RGBA{v, v, v, 255})
+		}
+	}
+	claheRGBA(img)
+	// Row 128 runs from 100 to 107 from x=128.
+	lo, hi := img.RGBAAt(128, 128).R, img.RGBAAt(135, 128).R
+	if hi-lo < 21 {
+		t.Errorf("CLAHE stretched 100-107 to %d-%d", lo, hi)
+	}
+	for x := 129; x <= 135; x++ {
+		if img.RGBAAt(x, 128).R < img.RGBAAt(x-1, 128).R {
+			t.Errorf("CLAHE reversed the order of values at x=%d", x)
+		}
+	}
+}
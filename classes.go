@@ -0,0 +1,470 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/guigui-gui/guigui"
+	"github.com/guigui-gui/guigui/basicwidget"
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+// parseHexColor reads a colour written as "#rrggbb".
+func parseHexColor(s string) (color.RGBA, error) {
+	hex, ok := strings.CutPrefix(strings.TrimSpace(s), "#")
+	if !ok || len(hex) != 6 {
+		return color.RGBA{}, fmt.Errorf("invalid colour %q, expected #rrggbb", s)
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("invalid colour %q, expected #rrggbb", s)
+	}
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 255}, nil
+}
+
+// hexColor writes a colour as "#rrggbb".
+func hexColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// keyLabel is how a hotkey is shown, with digits without their "Digit".
+func keyLabel(key ebiten.Key) string {
+	return strings.TrimPrefix(key.String(), "Digit")
+}
+
+// classColor is the colour regions of class index are drawn in.
+func (m *appModel) classColor(index int) color.Color {
+	if c, ok := m.settings.ClassColors[m.labelName(index)]; ok {
+		return c
+	}
+	return RegionIndexColor(index)
+}
+
+// classKey returns class index's hotkey: the one chosen for it, or for the
+// first ten classes their digit, unless another class has taken it.
+func (m *appModel) classKey(index int) (ebiten.Key, bool) {
+	if key, ok := m.settings.ClassKeys[m.labelName(index)]; ok {
+		return key, true
+	}
+	if index < 0 || index > 9 {
+		return 0, false
+	}
+	digit := ebiten.KeyDigit0 + ebiten.Key(index)
+	for name, key := range m.settings.ClassKeys {
+		if key == digit && slices.Contains(m.labels, name) {
+			return 0, false
+		}
+	}
+	return digit, true
+}
+
+// pressedClass returns the class whose hotkey pressed reports, or -1. Without
+// class names the digits still give the first ten classes. Class hotkeys are
+// bare keys, so like keymap.pressed it only matches them with no modifiers
+// held; a hotkey "P" mustn't fire for, and swallow, shift+P.
+//
+// with is the binding of the shortcut the hotkey is held with, as when a
+// class's hotkey is held to copy just that class, or nil. Its modifiers are
+// then the ones that must be held, and its own key is no class's.
+func (m *appModel) pressedClass(pressed func(ebiten.Key) bool, with *keyBinding) int {
+	var mods keyBinding
+	if with != nil {
+		mods = keyBinding{ctrl: with.ctrl, shift: with.shift, alt: with.alt}
+	}
+	if !mods.modifiersHeld() {
+		return -1
+	}
+	for i := range max(len(m.labels), 10) {
+		if key, ok := m.classKey(i); ok && pressed(key) && (with == nil || key != with.key) {
+			return i
+		}
+	}
+	return -1
+}
+
+// checkClassName reports why name can't be the name of class index, which
+// is -1 for a new class.
+func (m *appModel) checkClassName(name string, index int) error {
+	if name == "" {
+		return fmt.Errorf("a class needs a name")
+	}
+	if i := slices.Index(m.labels, name); i >= 0 && i != index {
+		return fmt.Errorf("class %d is already called %q", i, name)
+	}
+	if m.remapping {
+		return fmt.Errorf("wait for the classes to be remapped")
+	}
+	return nil
+}
+
+// setClassNames saves a new list of class names, which doesn't renumber any
+// class, wherever the dataset keeps them.
+func (m *appModel) setClassNames(names []string) error {
+	if err := saveClassNames(m.backend, names); err != nil {
+		return err
+	}
+	m.labels = names
+	m.metadataMu.Lock()
+	for len(m.metadata.CategoryTotals) < len(names) {
+		m.metadata.CategoryTotals = append(m.metadata.CategoryTotals, 0)
+	}
+	m.metadataMu.Unlock()
+	return nil
+}
+
+// addClass adds a class after the others.
+func (m *appModel) addClass(name string) error {
+	name = strings.TrimSpace(name)
+	if err := m.checkClassName(name, -1); err != nil {
+		return err
+	}
+	// Classes already used without names are named by number, so the new
+	// class doesn't take one of them.
+	names := slices.Clone(m.labels)
+	for len(names) < m.usedClasses() {
+		names = append(names, fmt.Sprint(len(names)))
+	}
+	return m.setClassNames(append(names, name))
+}
+
+// usedClasses returns one more than the highest class number of the scanned
+// regions.
+func (m *appModel) usedClasses() int {
+	m.metadataMu.Lock()
+	defer m.metadataMu.Unlock()
+	used := 0
+	for _, s := range m.summaries {
+		for _, r := range s.regions {
+			used = max(used, r.index+1)
+		}
+	}
+	return used
+}
+
+// renameClass renames class index, keeping its colour and hotkey.
+func (m *appModel) renameClass(index int, name string) error {
+	name = strings.TrimSpace(name)
+	if index < 0 || index >= len(m.labels) || name == m.labels[index] {
+		return nil
+	}
+	if err := m.checkClassName(name, index); err != nil {
+		return err
+	}
+	old := m.labels[index]
+	names := slices.Clone(m.labels)
+	names[index] = name
+	if err := m.setClassNames(names); err != nil {
+		return err
+	}
+	colors, keys := maps.Clone(m.settings.ClassColors), maps.Clone(m.settings.ClassKeys)
+	if c, ok := colors[old]; ok {
+		delete(colors, old)
+		colors[name] = c
+	}
+	if key, ok := keys[old]; ok {
+		delete(keys, old)
+		keys[name] = key
+	}
+	m.saveClassSettings(colors, keys)
+	return nil
+}
+
+// setClassColor sets class index's colour from "#rrggbb", or back to the
+// default if text is empty.
+func (m *appModel) setClassColor(index int, text string) error {
+	if index < 0 || index >= len(m.labels) {
+		return nil
+	}
+	colors := maps.Clone(m.settings.ClassColors)
+	if colors == nil {
+		colors = map[string]color.RGBA{}
+	}
+	if text = strings.TrimSpace(text); text == "" {
+		delete(colors, m.labels[index])
+	} else {
+		c, err := parseHexColor(text)
+		if err != nil {
+			return err
+		}
+		colors[m.labels[index]] = c
+	}
+	m.saveClassSettings(colors, m.settings.ClassKeys)
+	return nil
+}
+
+// setClassKey sets class index's hotkey, a letter, digit or key name such as
+// F1, taking it from any other class, or back to the default if text is
+// empty.
+func (m *appModel) setClassKey(index int, text string) error {
+	if index < 0 || index >= len(m.labels) {
+		return nil
+	}
+	keys := maps.Clone(m.settings.ClassKeys)
+	if keys == nil {
+		keys = map[string]ebiten.Key{}
+	}
+	delete(keys, m.labels[index])
+	if text = strings.TrimSpace(text); text != "" {
+		var key ebiten.Key
+		if err := key.UnmarshalText([]byte(text)); err != nil {
+			return fmt.Errorf("unknown key %q", text)
+		}
+		maps.DeleteFunc(keys, func(_ string, k ebiten.Key) bool { return k == key })
+		keys[m.labels[index]] = key
+	}
+	m.saveClassSettings(m.settings.ClassColors, keys)
+	return nil
+}
+
+// saveClassSettings replaces the class colours and hotkeys and saves them in
+// the dataset settings.
+func (m *appModel) saveClassSettings(colors map[string]color.RGBA, keys map[string]ebiten.Key) {
+	m.settings.ClassColors, m.settings.ClassKeys = colors, keys
+	m.settings.SaveAsync()
+}
+
+// moveClass moves count classes from index from to index to, renumbering
+// every label file in the background, and returns where they end up.
+func (m *appModel) moveClass(from, count, to int) (int, error) {
+	if m.remapping {
+		return from, fmt.Errorf("wait for the classes to be remapped")
+	}
+	order := make([]int, len(m.labels))
+	for i := range order {
+		order[i] = i
+	}
+	moved := basicwidget.MoveItemsInSlice(order, from, count, to)
+	remap := classRemap{names: make([]string, len(order)), from: make([]int, len(order))}
+	for i, old := range order {
+		remap.names[i] = m.labels[old]
+		remap.from[old] = i
+	}
+	if slices.Equal(remap.names, m.labels) {
+		return from, nil
+	}
+	m.runRemap(remap, false)
+	return moved, nil
+}
+
+// classManager lists the dataset's classes for adding, renaming and
+// reordering them, and choosing their colours and hotkeys.
+type classManager struct {
+	guigui.DefaultWidget
+
+	model *appModel
+
+	list       basicwidget.List[int]
+	nameLabel  basicwidget.Text
+	nameInput  basicwidget.TextInput
+	colorLabel basicwidget.Text
+	colorInput basicwidget.TextInput
+	keyLabel   basicwidget.Text
+	keyInput   basicwidget.TextInput
+	upButton   basicwidget.Button
+	downButton basicwidget.Button
+	newInput   basicwidget.TextInput
+	addButton  basicwidget.Button
+	statusText basicwidget.Text
+
+	selected  int
+	listItems []basicwidget.ListItem[int]
+	colItems  []guigui.LinearLayoutItem
+	editItems []guigui.LinearLayoutItem
+	addItems  []guigui.LinearLayoutItem
+}
+
+func (c *classManager) SetModel(m *appModel) {
+	c.model = m
+}
+
+// editing reports whether one of the manager's inputs has the keyboard.
+func (c *classManager) editing(context *guigui.Context) bool {
+	return context.IsFocusedOrHasFocusedDescendant(&c.nameInput) || context.IsFocusedOrHasFocusedDescendant(&c.colorInput) ||
+		context.IsFocusedOrHasFocusedDescendant(&c.keyInput) || context.IsFocusedOrHasFocusedDescendant(&c.newInput)
+}
+
+// showError shows err against input, if there is one.
+func showError(input *basicwidget.TextInput, err error) {
+	input.SetError(err != nil)
+	if err != nil {
+		input.SetSupportText(err.Error())
+	} else {
+		input.SetSupportText("")
+	}
+}
+
+func (c *classManager) WriteStateKey(context *guigui.Context, w *guigui.StateKeyWriter) {
+	w.WriteInt(c.selected)
+}
+
+func (c *classManager) Build(context *guigui.Context, adder *guigui.ChildAdder) error {
+	adder.AddWidget(&c.list)
+	adder.AddWidget(&c.nameLabel)
+	adder.AddWidget(&c.nameInput)
+	adder.AddWidget(&c.colorLabel)
+	adder.AddWidget(&c.colorInput)
+	adder.AddWidget(&c.keyLabel)
+	adder.AddWidget(&c.keyInput)
+	adder.AddWidget(&c.upButton)
+	adder.AddWidget(&c.downButton)
+	adder.AddWidget(&c.newInput)
+	adder.AddWidget(&c.addButton)
+	adder.AddWidget(&c.statusText)
+
+	m := c.model
+	if m == nil {
+		return nil
+	}
+	c.selected = min(max(c.selected, 0), max(len(m.labels)-1, 0))
+	selected := c.selected < len(m.labels)
+
+	c.listItems = slices.Delete(c.listItems, 0, len(c.listItems))
+	for i, name := range m.labels {
+		item := basicwidget.ListItem[int]{
+			Text:      fmt.Sprintf("%d %s", i, name),
+			TextStyle: basicwidget.TextStyle{Color: m.classColor(i)},
+			Movable:   !m.remapping,
+			Value:     i,
+		}
+		if key, ok := m.classKey(i); ok {
+			item.KeyText = keyLabel(key)
+		}
+		c.listItems = append(c.listItems, item)
+	}
+	c.list.SetItems(c.listItems)
+	c.list.SelectItemByIndex(c.selected)
+	c.list.OnItemSelected(func(context *guigui.Context, index int) {
+		c.selected = index
+	})
+	c.list.OnItemsMoved(func(context *guigui.Context, from, count, to int) {
+		moved, err := m.moveClass(from, count, to)
+		showError(&c.nameInput, err)
+		c.selected = moved
+	})
+
+	c.nameLabel.SetValue("Name")
+	c.nameLabel.SetVerticalAlign(basicwidget.VerticalAlignMiddle)
+	c.colorLabel.SetValue("Colour")
+	c.colorLabel.SetVerticalAlign(basicwidget.VerticalAlignMiddle)
+	c.keyLabel.SetValue("Hotkey")
+	c.keyLabel.SetVerticalAlign(basicwidget.VerticalAlignMiddle)
+	c.colorInput.SetPlaceholder("#rrggbb")
+	c.keyInput.SetPlaceholder("None")
+	if selected {
+		c.nameInput.SetValue(m.labels[c.selected])
+		c.colorInput.SetValue("")
+		if col, ok := m.settings.ClassColors[m.labels[c.selected]]; ok {
+			c.colorInput.SetValue(hexColor(col))
+		}
+		c.keyInput.SetValue("")
+		if key, ok := m.classKey(c.selected); ok {
+			c.keyInput.SetValue(keyLabel(key))
+		}
+	}
+	c.nameInput.OnValueChanged(func(context *guigui.Context, text string, committed bool) {
+		if committed {
+			showError(&c.nameInput, m.renameClass(c.selected, text))
+		}
+	})
+	c.colorInput.OnValueChanged(func(context *guigui.Context, text string, committed bool) {
+		if committed {
+			showError(&c.colorInput, m.setClassColor(c.selected, text))
+		}
+	})
+	c.keyInput.OnValueChanged(func(context *guigui.Context, text string, committed bool) {
+		if committed {
+			showError(&c.keyInput, m.setClassKey(c.selected, text))
+		}
+	})
+	context.SetEnabled(&c.nameInput, selected && !m.remapping)
+	context.SetEnabled(&c.colorInput, selected)
+	context.SetEnabled(&c.keyInput, selected)
+
+	c.upButton.SetText("Move up")
+	c.upButton.OnDown(func(context *guigui.Context) {
+		moved, err := m.moveClass(c.selected, 1, c.selected-1)
+		showError(&c.nameInput, err)
+		c.selected = moved
+	})
+	c.downButton.SetText("Move down")
+	c.downButton.OnDown(func(context *guigui.Context) {
+		moved, err := m.moveClass(c.selected, 1, c.selected+2)
+		showError(&c.nameInput, err)
+		c.selected = moved
+	})
+	context.SetEnabled(&c.upButton, selected && c.selected > 0 && !m.remapping)
+	context.SetEnabled(&c.downButton, selected && c.selected < len(m.labels)-1 && !m.remapping)
+
+	c.newInput.SetPlaceholder("New class name")
+	c.newInput.OnValueChanged(func(context *guigui.Context, text string, committed bool) {
+		c.newInput.SetError(false)
+		c.newInput.SetSupportText("")
+	})
+	c.addButton.SetText("Add class")
+	c.addButton.OnDown(func(context *guigui.Context) {
+		err := m.addClass(c.newInput.Value())
+		showError(&c.newInput, err)
+		if err == nil {
+			c.newInput.SetValue("")
+			c.selected = len(m.labels) - 1
+		}
+	})
+	context.SetEnabled(&c.addButton, !m.remapping)
+
+	status := "Drag classes to reorder them, which renumbers every label file. Colours and hotkeys are saved in " + datasetSettingsFile + "."
+	if m.remapStatus != "" {
+		status = m.remapStatus
+	}
+	c.statusText.SetValue(status)
+	c.statusText.SetVerticalAlign(basicwidget.VerticalAlignMiddle)
+	return nil
+}
+
+func (c *classManager) Layout(context *guigui.Context, widgetBounds *guigui.WidgetBounds, layouter *guigui.ChildLayouter) {
+	u := basicwidget.UnitSize(context)
+
+	c.editItems = slices.Delete(c.editItems, 0, len(c.editItems))
+	c.editItems = append(c.editItems,
+		guigui.LinearLayoutItem{Widget: &c.nameLabel},
+		guigui.LinearLayoutItem{Widget: &c.nameInput, Size: guigui.FixedSize(8 * u)},
+		guigui.LinearLayoutItem{Widget: &c.colorLabel},
+		guigui.LinearLayoutItem{Widget: &c.colorInput, Size: guigui.FixedSize(4 * u)},
+		guigui.LinearLayoutItem{Widget: &c.keyLabel},
+		guigui.LinearLayoutItem{Widget: &c.keyInput, Size: guigui.FixedSize(3 * u)},
+		guigui.LinearLayoutItem{Widget: &c.upButton},
+		guigui.LinearLayoutItem{Widget: &c.downButton},
+	)
+	editRow := guigui.LinearLayout{
+		Direction: guigui.LayoutDirectionHorizontal,
+		Items:     c.editItems,
+		Gap:       u / 4,
+	}
+
+	c.addItems = slices.Delete(c.addItems, 0, len(c.addItems))
+	c.addItems = append(c.addItems,
+		guigui.LinearLayoutItem{Widget: &c.newInput, Size: guigui.FixedSize(8 * u)},
+		guigui.LinearLayoutItem{Widget: &c.addButton},
+	)
+	addRow := guigui.LinearLayout{
+		Direction: guigui.LayoutDirectionHorizontal,
+		Items:     c.addItems,
+		Gap:       u / 4,
+	}
+
+	c.colItems = slices.Delete(c.colItems, 0, len(c.colItems))
+	c.colItems = append(c.colItems,
+		guigui.LinearLayoutItem{Widget: &c.list, Size: guigui.FlexibleSize(1)},
+		guigui.LinearLayoutItem{Layout: &editRow},
+		guigui.LinearLayoutItem{Layout: &addRow},
+		guigui.LinearLayoutItem{Widget: &c.statusText},
+	)
+	(guigui.LinearLayout{
+		Direction: guigui.LayoutDirectionVertical,
+		Items:     c.colItems,
+		Gap:       u / 4,
+	}).LayoutWidgets(context, widgetBounds.Bounds(), layouter)
+}
@@ -0,0 +1,108 @@
+// Command stubmodel is a stand-in detector for trying out FastMark's -model
+// option. It speaks both protocols: by default it serves HTTP, and with
+// -stdin it reads images from its standard input. Every image gets the same
+// two predictions: a confident box in the middle and a doubtful one in the
+// top left corner.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// predict returns predictions file lines for an image.
+func predict(data []byte) (string, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	log.Printf("Predicting for a %dx%d %s", config.Width, config.Height, format)
+	return "0 0.500000 0.500000 0.250000 0.250000 0.900000\n" +
+		"1 0.150000 0.150000 0.100000 0.100000 0.350000\n", nil
+}
+
+func serveHTTP(listen string) error {
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "POST an image", http.StatusMethodNotAllowed)
+			return
+		}
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Request for %s", r.Header.Get("X-Filename"))
+		predictions, err := predict(data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		io.WriteString(w, predictions)
+	})
+	log.Printf("Listening on %s", listen)
+	return http.ListenAndServe(listen, nil)
+}
+
+// serveStdin answers "<length> <name>" headers, each followed by the image's
+// bytes, with predictions and an empty line.
+func serveStdin() error {
+	in := bufio.NewReader(os.Stdin)
+	for {
+		header, err := in.ReadString('\n')
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		length, name, _ := strings.Cut(strings.TrimSpace(header), " ")
+		n, err := strconv.Atoi(length)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid header %q", header)
+		}
+		data := make([]byte, n)
+		if _, err := io.ReadFull(in, data); err != nil {
+			return err
+		}
+		log.Printf("Request for %s", name)
+		predictions, err := predict(data)
+		if err != nil {
+			// An empty reply means no predictions.
+			log.Printf("Error reading %s: %s", name, err)
+		}
+		fmt.Printf("%s\n", predictions)
+	}
+}
+
+func main() {
+	listen := flag.String("listen", "localhost:8000", "Address to serve HTTP on")
+	stdin := flag.Bool("stdin", false, "Read images from standard input instead of serving HTTP")
+	flag.Parse()
+
+	var err error
+	if *stdin {
+		err = serveStdin()
+	} else {
+		err = serveHTTP(*listen)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"image/color"
+	"io"
+	"slices"
+	"text/tabwriter"
+
+	"github.com/AndreRenaud/fastmark/storage"
+)
+
+// defaultCompareIoU is the overlap, in percent, a prediction needs with a
+// label of its class to count as finding it.
+const defaultCompareIoU = 50
+
+// Colours of the comparison overlay: predictions that found a region, those
+// that didn't, and regions no prediction found.
+var (
+	compareFoundColor  = color.RGBA{0, 220, 0, 255}
+	compareWrongColor  = color.RGBA{255, 40, 40, 255}
+	compareMissedColor = color.RGBA{255, 160, 0, 255}
+)
+
+// detectionMatch is how an image's predictions line up with its labels.
+type detectionMatch struct {
+	found  []Suggestion // true positives
+	wrong  []Suggestion // false positives
+	missed []Region     // labels no prediction found
+}
+
+func (d detectionMatch) errors() int {
+	return len(d.wrong) + len(d.missed)
+}
+
+// compareDetections matches the predictions at or above threshold to the
+// labels, most confident first, each taking the unmatched label of its class
+// it overlaps most, if by at least iou.
+func compareDetections(labels []Region, predictions []Suggestion, iou, threshold float64) detectionMatch {
+	var d detectionMatch
+	predictions = slices.Clone(predictions)
+	slices.SortStableFunc(predictions, func(a, b Suggestion) int { return cmp.Compare(b.Confidence, a.Confidence) })
+	matched := make([]bool, len(labels))
+	for _, p := range predictions {
+		if p.Confidence < threshold {
+			continue
+		}
+		if j := bestMatch(labels, matched, p.Region, iou); j >= 0 {
+			matched[j] = true
+			d.found = append(d.found, p)
+		} else {
+			d.wrong = append(d.wrong, p)
+		}
+	}
+	for j, l := range labels {
+		if !matched[j] {
+			d.missed = append(d.missed, l)
+		}
+	}
+	return d
+}
+
+// bestMatch returns the unmatched label of r's class overlapping r most, if
+// by at least iou, or -1.
+func bestMatch(labels []Region, matched []bool, r Region, iou float64) int {
+	best, bestOverlap := -1, iou
+	for j, l := range labels {
+		if matched[j] || l.index != r.index {
+			continue
+		}
+		if o := regionOverlap(l, r); o >= bestOverlap {
+			best, bestOverlap = j, o
+		}
+	}
+	return best
+}
+
+// comparison returns the current settings for matching predictions to
+// labels.
+func (m *appModel) comparison() (iou, threshold float64) {
+	return float64(m.compareIoU) / 100, float64(m.suggestThreshold) / 100
+}
+
+// currentComparison compares the selected file's predictions and regions.
+func (m *appModel) currentComparison() detectionMatch {
+	iou, threshold := m.comparison()
+	return compareDetections(m.currentRegions.Regions, m.suggestions, iou, threshold)
+}
+
+// setSuggestThreshold changes the confidence threshold, which changes which
+// predictions count.
+func (m *appModel) setSuggestThreshold(percent int) {
+	if percent == m.suggestThreshold {
+		return
+	}
+	m.suggestThreshold = percent
+	m.rescorePredictions()
+}
+
+// setCompareIoU changes the overlap a prediction needs to find a region.
+func (m *appModel) setCompareIoU(percent int) {
+	if percent == m.compareIoU {
+		return
+	}
+	m.compareIoU = percent
+	m.rescorePredictions()
+}
+
+// rescorePredictions recomputes every file's prediction uncertainty and
+// error count after the threshold or IoU settings change.
+func (m *appModel) rescorePredictions() {
+	iou, threshold := m.comparison()
+	m.metadataMu.Lock()
+	defer m.metadataMu.Unlock()
+	for i, s := range m.summaries {
+		if s.predicted {
+			m.summaries[i].scorePredictions(iou, threshold)
+		}
+	}
+	m.viewGen++
+}
+
+// scorePredictions fills in the scores derived from the predictions.
+func (s *fileSummary) scorePredictions(iou, threshold float64) {
+	s.uncertainty = predictionUncertainty(s.predictions, threshold)
+	s.errors = compareDetections(s.regions, s.predictions, iou, threshold).errors()
+}
+
+// classEvaluation is the detection accuracy for one class.
+type classEvaluation struct {
+	labels      int
+	predictions int // at or above the threshold
+	precision   float64
+	recall      float64
+	ap50        float64
+	ap50to95    float64
+}
+
+// scoredPrediction is a prediction in a dataset-wide evaluation.
+type scoredPrediction struct {
+	image      int
+	confidence float64
+	region     Region
+}
+
+// evaluateClass computes the accuracy of the predictions of one class across
+// images, whose labels of that class are given.
+func evaluateClass(labels [][]Region, predictions []scoredPrediction, threshold float64) classEvaluation {
+	e := classEvaluation{}
+	for _, l := range labels {
+		e.labels += len(l)
+	}
+	slices.SortStableFunc(predictions, func(a, b scoredPrediction) int { return cmp.Compare(b.confidence, a.confidence) })
+
+	for step := range 10 {
+		iou := 0.5 + float64(step)*0.05
+		matched := make([][]bool, len(labels))
+		for i, l := range labels {
+			matched[i] = make([]bool, len(l))
+		}
+		tp := make([]bool, len(predictions))
+		for k, p := range predictions {
+			if j := bestMatch(labels[p.image], matched[p.image], p.region, iou); j >= 0 {
+				matched[p.image][j] = true
+				tp[k] = true
+			}
+		}
+		ap := averagePrecision(tp, e.labels)
+		e.ap50to95 += ap / 10
+		if step > 0 {
+			continue
+		}
+		e.ap50 = ap
+		found := 0
+		for k, p := range predictions {
+			if p.confidence < threshold {
+				break
+			}
+			e.predictions++
+			if tp[k] {
+				found++
+			}
+		}
+		if e.predictions > 0 {
+			e.precision = float64(found) / float64(e.predictions)
+		}
+		if e.labels > 0 {
+			e.recall = float64(found) / float64(e.labels)
+		}
+	}
+	return e
+}
+
+// averagePrecision is the area under the precision/recall curve of
+// predictions in descending confidence order, which are true positives
+// where tp is set, interpolated at 101 recall points as COCO does.
+func averagePrecision(tp []bool, labels int) float64 {
+	if labels == 0 {
+		return 0
+	}
+	precision := make([]float64, len(tp))
+	recall := make([]float64, len(tp))
+	found := 0
+	for k, t := range tp {
+		if t {
+			found++
+		}
+		precision[k] = float64(found) / float64(k+1)
+		recall[k] = float64(found) / float64(labels)
+	}
+	// Make precision monotonically decreasing from the right.
+	for k := len(precision) - 2; k >= 0; k-- {
+		precision[k] = max(precision[k], precision[k+1])
+	}
+	var sum float64
+	k := 0
+	for point := range 101 {
+		r := float64(point) / 100
+		for k < len(recall) && recall[k] < r {
+			k++
+		}
+		if k < len(recall) {
+			sum += precision[k]
+		}
+	}
+	return sum / 101
+}
+
+// writeEvaluationReport compares the predictions with the labels of every
+// image that has a label file, and writes the per-class precision and recall
+// at threshold, and mAP@0.5 and mAP@0.5:0.95, to w.
+func writeEvaluationReport(w io.Writer, backend storage.Storage, threshold float64) error {
+	settings, err := LoadDatasetSettings(backend)
+	if err != nil {
+		return err
+	}
+	// Without labels.txt, classes are reported by number.
+	names, _ := loadClassNames(backend)
+	files, err := listImages(backend)
+	if err != nil {
+		return err
+	}
+	dir := settings.predictionsDir()
+	var labels [][]Region
+	predictions := map[int][]scoredPrediction{}
+	classes := len(names)
+	for _, file := range files {
+		// Unlabelled images can't be scored.
+		if _, err := backend.Stat(labelFileName(file)); err != nil {
+			continue
+		}
+		regions, err := LoadRegionList(backend, labelFileName(file))
+		if err != nil {
+			continue
+		}
+		image := len(labels)
+		labels = append(labels, regions.Regions)
+		for _, r := range regions.Regions {
+			classes = max(classes, r.index+1)
+		}
+		suggestions, _ := LoadPredictions(backend, predictionFileName(dir, file))
+		for _, s := range suggestions {
+			classes = max(classes, s.Region.index+1)
+			predictions[s.Region.index] = append(predictions[s.Region.index], scoredPrediction{image: image, confidence: s.Confidence, region: s.Region})
+		}
+	}
+	if len(labels) == 0 {
+		return fmt.Errorf("No labelled images to evaluate")
+	}
+
+	fmt.Fprintf(w, "%d labelled images, predictions from %s/, precision and recall at confidence %.2f and IoU 0.5\n\n", len(labels), dir, threshold)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "Class\tLabels\tPredictions\tPrecision\tRecall\tmAP@0.5\tmAP@0.5:0.95\t\n")
+	var mean classEvaluation
+	evaluated := 0
+	for class := range classes {
+		perImage := make([][]Region, len(labels))
+		for i, l := range labels {
+			for _, r := range l {
+				if r.index == class {
+					perImage[i] = append(perImage[i], r)
+				}
+			}
+		}
+		e := evaluateClass(perImage, predictions[class], threshold)
+		if e.labels == 0 && len(predictions[class]) == 0 {
+			continue
+		}
+		name := fmt.Sprint(class)
+		if class < len(names) {
+			name = names[class]
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.3f\t%.3f\t%.3f\t%.3f\t\n", name, e.labels, e.predictions, e.precision, e.recall, e.ap50, e.ap50to95)
+		// Classes without labels have nothing to find, so aren't averaged.
+		if e.labels > 0 {
+			evaluated++
+			mean.labels += e.labels
+			mean.predictions += e.predictions
+			mean.precision += e.precision
+			mean.recall += e.recall
+			mean.ap50 += e.ap50
+			mean.ap50to95 += e.ap50to95
+		}
+	}
+	if evaluated > 0 {
+		n := float64(evaluated)
+		fmt.Fprintf(tw, "all\t%d\t%d\t%.3f\t%.3f\t%.3f\t%.3f\t\n", mean.labels, mean.predictions, mean.precision/n, mean.recall/n, mean.ap50/n, mean.ap50to95/n)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func box(class int, x, y float64) Region {
+	return Region{xMid: x, yMid: y, width: 0.2, height: 0.2, index: class}
+}
+
+func TestRegionOverlap(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b Region
+		want float64
+	}{
+		{"identical", box(0, 0.5, 0.5), box(0, 0.5, 0.5), 1},
+		{"disjoint", box(0, 0.2, 0.2), box(0, 0.8, 0.8), 0},
+		{"touching", box(0, 0.3, 0.5), box(0, 0.5, 0.5), 0},
+		{"half width", box(0, 0.5, 0.5), box(0, 0.6, 0.5), 1.0 / 3},
+		{"contained", Region{xMid: 0.5, yMid: 0.5, width: 0.4, height: 0.4}, box(0, 0.5, 0.5), 0.25},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := regionOverlap(tt.a, tt.b); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("regionOverlap = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompareDetections(t *testing.T) {
+	tests := []struct {
+		name                 string
+		labels               []Region
+		predictions          []Suggestion
+		found, wrong, missed int
+	}{
+		{
+			name:        "exact match",
+			labels:      []Region{box(0, 0.5, 0.5)},
+			predictions: []Suggestion{{box(0, 0.5, 0.5), 0.9}},
+			found:       1,
+		},
+		{
+			name:        "below threshold",
+			labels:      []Region{box(0, 0.5, 0.5)},
+			predictions: []Suggestion{{box(0, 0.5, 0.5), 0.1}},
+			missed:      1,
+		},
+		{
+			name:        "wrong class",
+			labels:      []Region{box(0, 0.5, 0.5)},
+			predictions: []Suggestion{{box(1, 0.5, 0.5), 0.9}},
+			wrong:       1,
+			missed:      1,
+		},
+		{
+			name:        "too little overlap",
+			labels:      []Region{box(0, 0.5, 0.5)},
+			predictions: []Suggestion{{box(0, 0.6, 0.5), 0.9}},
+			wrong:       1,
+			missed:      1,
+		},
+		{
+			name:        "duplicate prediction",
+			labels:      []Region{box(0, 0.5, 0.5)},
+			predictions: []Suggestion{{box(0, 0.52, 0.5), 0.6}, {box(0, 0.5, 0.5), 0.9}},
+			found:       1,
+			wrong:       1,
+		},
+		{
+			name:        "two objects",
+			labels:      []Region{box(0, 0.2, 0.2), box(0, 0.8, 0.8)},
+			predictions: []Suggestion{{box(0, 0.8, 0.8), 0.7}, {box(0, 0.2, 0.2), 0.8}},
+			found:       2,
+		},
+		{
+			name:   "nothing predicted",
+			labels: []Region{box(0, 0.2, 0.2), box(1, 0.8, 0.8)},
+			missed: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := compareDetections(tt.labels, tt.predictions, 0.5, 0.25)
+			if len(d.found) != tt.found || len(d.wrong) != tt.wrong || len(d.missed) != tt.missed {
+				t.Errorf("found %d, wrong %d, missed %d; want %d, %d, %d", len(d.found), len(d.wrong), len(d.missed), tt.found, tt.wrong, tt.missed)
+			}
+			if d.errors() != tt.wrong+tt.missed {
+				t.Errorf("errors = %d, want %d", d.errors(), tt.wrong+tt.missed)
+			}
+		})
+	}
+}
+
+func TestAveragePrecision(t *testing.T) {
+	tests := []struct {
+		name   string
+		tp     []bool
+		labels int
+		want   float64
+	}{
+		{"no labels", []bool{true}, 0, 0},
+		{"no predictions", nil, 2, 0},
+		{"all found", []bool{true, true}, 2, 1},
+		{"false positive first", []bool{false, true}, 1, 0.5},
+		{"half found", []bool{true, false}, 2, 51.0 / 101},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := averagePrecision(tt.tp, tt.labels); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("averagePrecision = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateClass(t *testing.T) {
+	tests := []struct {
+		name        string
+		labels      [][]Region
+		predictions []scoredPrediction
+		want        classEvaluation
+	}{
+		{
+			name:        "perfect",
+			labels:      [][]Region{{box(0, 0.5, 0.5)}, {box(0, 0.3, 0.3)}},
+			predictions: []scoredPrediction{{0, 0.9, box(0, 0.5, 0.5)}, {1, 0.8, box(0, 0.3, 0.3)}},
+			want:        classEvaluation{labels: 2, predictions: 2, precision: 1, recall: 1, ap50: 1, ap50to95: 1},
+		},
+		{
+			// An IoU of 2/3 matches at 0.5 to 0.65, four of the ten steps.
+			name:        "loose box",
+			labels:      [][]Region{{box(0, 0.5, 0.5)}},
+			predictions: []scoredPrediction{{0, 0.9, box(0, 0.54, 0.5)}},
+			want:        classEvaluation{labels: 1, predictions: 1, precision: 1, recall: 1, ap50: 1, ap50to95: 0.4},
+		},
+		{
+			name:        "below threshold",
+			labels:      [][]Region{{box(0, 0.5, 0.5)}},
+			predictions: []scoredPrediction{{0, 0.1, box(0, 0.5, 0.5)}},
+			want:        classEvaluation{labels: 1, ap50: 1, ap50to95: 1},
+		},
+		{
+			name:        "wrong image",
+			labels:      [][]Region{{box(0, 0.5, 0.5)}, nil},
+			predictions: []scoredPrediction{{1, 0.9, box(0, 0.5, 0.5)}},
+			want:        classEvaluation{labels: 1, predictions: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := evaluateClass(tt.labels, tt.predictions, 0.25)
+			if got.labels != tt.want.labels || got.predictions != tt.want.predictions {
+				t.Errorf("labels %d, predictions %d; want %d, %d", got.labels, got.predictions, tt.want.labels, tt.want.predictions)
+			}
+			for _, v := range []struct {
+				name      string
+				got, want float64
+			}{
+				{"precision", got.precision, tt.want.precision},
+				{"recall", got.recall, tt.want.recall},
+				{"ap50", got.ap50, tt.want.ap50},
+				{"ap50to95", got.ap50to95, tt.want.ap50to95},
+			} {
+				if math.Abs(v.got-v.want) > 1e-9 {
+					t.Errorf("%s = %v, want %v", v.name, v.got, v.want)
+				}
+			}
+		})
+	}
+}
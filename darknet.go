@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/AndreRenaud/fastmark/storage"
+)
+
+// darknetDataFile is the .data file used when a dataset has several.
+const darknetDataFile = "obj.data"
+
+// darknetSplits are the .data keys naming image list files, which are also
+// used as the splits' names.
+var darknetSplits = []string{"train", "valid", "test"}
+
+// loadDarknetLayout reads the dataset's Darknet .data file, of "key = value"
+// lines naming the class names file (names) and the image list files of its
+// splits. It is looked for in the dataset root and then in data/, as in the
+// Darknet directory, but paths in it, and in the lists, are relative to the
+// root either way. A dataset without one has the plain layout.
+func loadDarknetLayout(backend storage.Storage) (datasetLayout, error) {
+	var dataFiles []string
+	for _, dir := range []string{"", "data"} {
+		matches, _ := backend.Glob(dir, "*.data")
+		for _, match := range matches {
+			dataFiles = append(dataFiles, filepath.Join(dir, filepath.Base(match)))
+		}
+		if len(dataFiles) > 0 {
+			break
+		}
+	}
+	if len(dataFiles) == 0 {
+		return datasetLayout{}, nil
+	}
+	slices.Sort(dataFiles)
+	dataFile := dataFiles[0]
+	if i := slices.IndexFunc(dataFiles, func(f string) bool { return filepath.Base(f) == darknetDataFile }); i >= 0 {
+		dataFile = dataFiles[i]
+	}
+	if len(dataFiles) > 1 {
+		log.Printf("Found %d .data files; using %s", len(dataFiles), dataFile)
+	}
+
+	file, err := backend.Open(dataFile)
+	if err != nil {
+		return datasetLayout{dataFile: dataFile}, err
+	}
+	defer file.Close()
+	data, err := parseDarknetData(file)
+	if err != nil {
+		return datasetLayout{dataFile: dataFile}, err
+	}
+
+	layout := datasetLayout{dataFile: dataFile}
+	if names := data["names"]; names != "" {
+		layout.namesFile = names
+		if layout.names, err = readLines(backend, names); err != nil {
+			return layout, err
+		}
+	}
+	for _, key := range darknetSplits {
+		list := data[key]
+		if list == "" {
+			continue
+		}
+		split, err := readImageList(backend, key, list)
+		if err != nil {
+			return layout, err
+		}
+		layout.splits = append(layout.splits, split)
+	}
+	return layout, nil
+}
+
+// darknetLabelPath returns where Darknet looks for the labels of the image
+// at path, without its extension: alongside it, unless it is in an images
+// or JPEGImages directory, when it is in the matching labels directory.
+func darknetLabelPath(path string) string {
+	dirs := strings.Split(filepath.ToSlash(path), "/")
+	for i, dir := range dirs[:len(dirs)-1] {
+		if dir == "images" || dir == "JPEGImages" {
+			dirs[i] = "labels"
+		}
+	}
+	return filepath.FromSlash(strings.Join(dirs, "/"))
+}
+
+// parseDarknetData reads a .data file's "key = value" lines.
+func parseDarknetData(reader io.Reader) (map[string]string, error) {
+	data := map[string]string{}
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		if key, value, ok := strings.Cut(line, "="); ok {
+			data[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	return data, scanner.Err()
+}
+
+// readLines returns a file's non-empty lines, such as a .names file's class
+// names.
+func readLines(backend storage.Storage, filename string) ([]string, error) {
+	file, err := backend.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// readImageList reads the split held in an image list file. A missing list
+// is an empty split, which images can be added to.
+func readImageList(backend storage.Storage, name, list string) (datasetSplit, error) {
+	split := datasetSplit{name: name, list: list, members: map[string]bool{}}
+	entries, err := readLines(backend, list)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return split, err
+	}
+	for _, entry := range entries {
+		split.entries = append(split.entries, entry)
+		// syncImageLists logs entries outside the dataset.
+		file, ok := listEntryFile(backend, entry)
+		if !ok {
+			continue
+		}
+		if !split.members[file] {
+			split.files = append(split.files, file)
+			split.members[file] = true
+		}
+	}
+	return split, nil
+}
+
+// listEntryFile returns the name of the image an image list entry gives the
+// path of, relative to the dataset root or, for a local dataset, absolute.
+func listEntryFile(backend storage.Storage, entry string) (string, bool) {
+	path := filepath.Clean(entry)
+	if filepath.IsAbs(path) {
+		root, ok := localRoot(backend)
+		if !ok {
+			return "", false
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return "", false
+		}
+		path = rel
+	}
+	if path == ".." || strings.HasPrefix(path, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return "/" + filepath.ToSlash(path), true
+}
+
+// listEntry returns the image list entry for a file, absolute if the list's
+// other entries are.
+func listEntry(backend storage.Storage, file string, absolute bool) string {
+	path := imagePath(file)
+	if root, ok := localRoot(backend); ok && absolute {
+		return filepath.Join(root, path)
+	}
+	return path
+}
+
+// localRoot returns the absolute path of a local dataset.
+func localRoot(backend storage.Storage) (string, bool) {
+	local, ok := backend.(interface{ LocalPath(string) string })
+	if !ok {
+		return "", false
+	}
+	root, err := filepath.Abs(local.LocalPath(""))
+	return root, err == nil
+}
+
+// syncImageLists brings the Darknet image lists of a dataset with the given
+// layout up to date with the images alongside those listed: images that
+// have gone are removed from the lists, and new ones are added to the first
+// list. Only directories already holding listed images are looked in, so
+// images kept elsewhere stay out of the lists. It reports whether any list
+// was changed, when the layout must be loaded again.
+func syncImageLists(backend storage.Storage, layout datasetLayout) (bool, error) {
+	listed := map[string]bool{}
+	var dirs []string
+	var first *datasetSplit
+	for i := range layout.splits {
+		split := &layout.splits[i]
+		if split.list == "" {
+			continue
+		}
+		if first == nil {
+			first = split
+		}
+		for _, file := range split.files {
+			listed[file] = true
+			if dir := filepath.Dir(imagePath(file)); !slices.Contains(dirs, dir) {
+				dirs = append(dirs, dir)
+			}
+		}
+	}
+	if first == nil {
+		return false, nil
+	}
+
+	// Only look in directories that could be listed, so a failed listing
+	// doesn't empty the lists.
+	present := map[string]bool{}
+	searched := map[string]bool{}
+	var added []string
+	for _, dir := range dirs {
+		matches, err := backend.Glob(dir, "*")
+		if err != nil {
+			log.Printf("Error listing %s: %s", dir, err)
+			continue
+		}
+		searched[dir] = true
+		for _, match := range matches {
+			file := "/" + filepath.ToSlash(filepath.Join(dir, filepath.Base(match)))
+			if !isImageFile(file) {
+				continue
+			}
+			present[file] = true
+			if !listed[file] {
+				added = append(added, file)
+			}
+		}
+	}
+
+	changed := false
+	for i := range layout.splits {
+		split := &layout.splits[i]
+		if split.list == "" {
+			continue
+		}
+		var entries []string
+		for _, entry := range split.entries {
+			file, ok := listEntryFile(backend, entry)
+			if !ok {
+				log.Printf("%s: %s is outside the dataset; leaving it out", split.list, entry)
+			} else if searched[filepath.Dir(imagePath(file))] && !present[file] {
+				log.Printf("Removing %s from %s, as it no longer exists", entry, split.list)
+				continue
+			}
+			entries = append(entries, entry)
+		}
+		if split == first {
+			absolute := len(entries) > 0 && filepath.IsAbs(entries[0])
+			slices.Sort(added)
+			for _, file := range added {
+				entry := listEntry(backend, file, absolute)
+				log.Printf("Adding %s to %s", entry, split.list)
+				entries = append(entries, entry)
+			}
+		}
+		if slices.Equal(entries, split.entries) {
+			continue
+		}
+		if err := writeLines(backend, split.list, entries); err != nil {
+			return changed, err
+		}
+		changed = true
+	}
+	return changed, nil
+}
+
+// writeLines replaces a file with lines.
+func writeLines(backend storage.Storage, filename string, lines []string) error {
+	return writeFileText(backend, filename, linesText(lines))
+}
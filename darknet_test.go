@@ -0,0 +1,145 @@
+package main
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/AndreRenaud/fastmark/storage"
+)
+
+func TestParseDarknetData(t *testing.T) {
+	data, err := parseDarknetData(strings.NewReader(
+		"classes= 2\n" +
+			"train  = data/train.txt\n" +
+			"valid = data/valid.txt # held out\n" +
+			"# test = data/test.txt\n" +
+			"names = data/obj.names\n" +
+			"backup = backup/\n" +
+			"not a setting\n" +
+			"eval=coco=2017\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"classes": "2",
+		"train":   "data/train.txt",
+		"valid":   "data/valid.txt",
+		"names":   "data/obj.names",
+		"backup":  "backup/",
+		"eval":    "coco=2017",
+	}
+	if !maps.Equal(data, want) {
+		t.Errorf("parseDarknetData = %v, want %v", data, want)
+	}
+}
+
+func TestDarknetLabelPath(t *testing.T) {
+	tests := []struct {
+		path, want string
+	}{
+		{"data/obj/a", "data/obj/a"},
+		{"images/train/a", "labels/train/a"},
+		{"VOC/JPEGImages/a", "VOC/labels/a"},
+		{"data/images", "data/images"},
+		{"images/images/a", "labels/labels/a"},
+		{"my_images/a", "my_images/a"},
+	}
+	for _, tt := range tests {
+		if got := darknetLabelPath(filepath.FromSlash(tt.path)); got != filepath.FromSlash(tt.want) {
+			t.Errorf("darknetLabelPath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestListEntryFile(t *testing.T) {
+	dir := t.TempDir()
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		entry string
+		want  string
+		ok    bool
+	}{
+		{"data/obj/a.jpg", "/data/obj/a.jpg", true},
+		{"./data/../data/obj/b.jpg", "/data/obj/b.jpg", true},
+		{filepath.Join(root, "data", "c.jpg"), "/data/c.jpg", true},
+		{"../elsewhere/d.jpg", "", false},
+		{"..", "", false},
+		{filepath.Join(filepath.Dir(root), "e.jpg"), "", false},
+	}
+	backend := storage.NewStorage(dir)
+	for _, tt := range tests {
+		got, ok := listEntryFile(backend, tt.entry)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("listEntryFile(%q) = %q, %v, want %q, %v", tt.entry, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestSyncImageLists(t *testing.T) {
+	dir := t.TempDir()
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"obj.data":        "train = train.txt\nvalid = valid.txt\n",
+		"train.txt":       "data/obj/a.jpg\ndata/obj/gone.jpg\n",
+		"valid.txt":       filepath.Join(root, "data", "val", "c.jpg") + "\n../outside.jpg\n",
+		"data/obj/a.jpg":  "",
+		"data/obj/a.txt":  "",
+		"data/obj/b.jpg":  "",
+		"data/val/c.jpg":  "",
+		"data/val/d.png":  "",
+		"unlisted/e.jpg":  "",
+		"data/obj/f.webp": "",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	backend := storage.NewStorage(dir)
+	sync := func() bool {
+		t.Helper()
+		layout, err := loadDatasetLayout(backend)
+		if err != nil {
+			t.Fatal(err)
+		}
+		changed, err := syncImageLists(backend, layout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return changed
+	}
+	if !sync() {
+		t.Errorf("syncImageLists changed nothing")
+	}
+	// New images go in the first list, as written there, and only from
+	// directories already listed.
+	want := map[string]string{
+		"train.txt": "data/obj/a.jpg\ndata/obj/b.jpg\ndata/obj/f.webp\ndata/val/d.png\n",
+		"valid.txt": files["valid.txt"],
+	}
+	for name, content := range want {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != content {
+			t.Errorf("%s = %q, want %q", name, got, content)
+		}
+	}
+	if sync() {
+		t.Errorf("syncImageLists changed the lists again")
+	}
+}
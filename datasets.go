@@ -0,0 +1,405 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/AndreRenaud/fastmark/storage"
+	"gopkg.in/yaml.v3"
+)
+
+// dataYAMLFile describes an Ultralytics dataset: its class names, and which
+// directories hold each split, with their labels in the matching labels
+// directories.
+const dataYAMLFile = "data.yaml"
+
+// datasetSplit is one subset of a dataset, such as the training images.
+//
+// An Ultralytics split is a directory, dir, relative to the dataset root.
+// Its images are named as imageName names them: relative to images/ if dir
+// is under it, so their label and sidecar files go in the same directory
+// under labels/, or otherwise from the dataset root like Darknet list
+// entries, so a split in train/images has its labels in train/labels.
+//
+// A Darknet split is an image list file, list, holding files, which are
+// named from the dataset root with a leading / (see imagePath).
+type datasetSplit struct {
+	name string
+	dir  string
+
+	list    string
+	files   []string
+	entries []string // the lines of list, as written
+	members map[string]bool
+}
+
+// datasetLayout describes a dataset that doesn't keep its class names in
+// labels.txt and all its images directly in images/.
+// dataFile is the data.yaml or Darknet .data file describing it, and
+// namesFile is where names came from: data.yaml, or a Darknet .names file
+// whose class count is also in dataFile.
+type datasetLayout struct {
+	names  []string
+	splits []datasetSplit
+
+	namesFile string
+	dataFile  string
+}
+
+// loadDatasetLayout reads the dataset's data.yaml, or failing that its
+// Darknet .data file. A dataset with neither has the plain layout, given by
+// the zero datasetLayout. The layout's dataFile is set even if there is an
+// error reading it.
+func loadDatasetLayout(backend storage.Storage) (datasetLayout, error) {
+	file, err := backend.Open(dataYAMLFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return loadDarknetLayout(backend)
+	} else if err != nil {
+		return datasetLayout{dataFile: dataYAMLFile}, err
+	}
+	defer file.Close()
+	layout, err := parseDataYAML(file, dataYAMLFile)
+	layout.dataFile = dataYAMLFile
+	if layout.names != nil {
+		layout.namesFile = dataYAMLFile
+	}
+	return layout, err
+}
+
+// parseDataYAML reads the parts of an Ultralytics data.yaml FastMark uses:
+// the train, val and test directories, resolved as resolveSplitDir does,
+// and names, given either as a list or as a map from class number to name.
+// filename is where the file is in the dataset, which the splits are
+// relative to.
+func parseDataYAML(reader io.Reader, filename string) (datasetLayout, error) {
+	var layout datasetLayout
+	var data struct {
+		Path  string    `yaml:"path"`
+		Train yaml.Node `yaml:"train"`
+		Val   yaml.Node `yaml:"val"`
+		Test  yaml.Node `yaml:"test"`
+		Names yaml.Node `yaml:"names"`
+	}
+	if err := yaml.NewDecoder(reader).Decode(&data); err != nil && !errors.Is(err, io.EOF) {
+		return layout, err
+	}
+	for _, split := range []struct {
+		name string
+		node *yaml.Node
+	}{{"train", &data.Train}, {"val", &data.Val}, {"test", &data.Test}} {
+		if split.node.Kind == 0 || split.node.Tag == "!!null" {
+			continue
+		}
+		dir, ok := "", false
+		if split.node.Kind == yaml.ScalarNode {
+			dir, ok = resolveSplitDir(filepath.Dir(filename), data.Path, split.node.Value)
+		}
+		if !ok {
+			log.Printf("%s: %s split isn't a directory in the dataset; leaving it out", filename, split.name)
+			continue
+		}
+		layout.splits = append(layout.splits, datasetSplit{name: split.name, dir: dir})
+	}
+
+	switch data.Names.Kind {
+	case yaml.SequenceNode:
+		if err := data.Names.Decode(&layout.names); err != nil {
+			return layout, fmt.Errorf("invalid names: %w", err)
+		}
+	case yaml.MappingNode:
+		names := map[int]string{}
+		if err := data.Names.Decode(&names); err != nil {
+			return layout, fmt.Errorf("invalid names: %w", err)
+		}
+		for i := range len(names) {
+			name, ok := names[i]
+			if !ok {
+				return layout, fmt.Errorf("class %d has no name", i)
+			}
+			layout.names = append(layout.names, name)
+		}
+	case 0:
+	default:
+		if data.Names.Tag != "!!null" {
+			return layout, fmt.Errorf("invalid names %q", data.Names.Value)
+		}
+	}
+	return layout, nil
+}
+
+// stripYAMLComment removes a trailing # comment, ignoring any inside quotes.
+func stripYAMLComment(line string) string {
+	var quote rune
+	for i, c := range line {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// resolveSplitDir returns the directory in the dataset that a data.yaml in
+// yamlDir, with the given path: (which may be empty), names as a split.
+// Splits are relative to path:, which is relative to the yaml's directory;
+// an absolute path: is where the dataset was on the machine that wrote it,
+// so is taken to be the yaml's directory. A split starting ../ that would be
+// outside the dataset, as Roboflow exports name theirs, is looked for
+// without the ../, as Ultralytics does. Image list files and lists of
+// directories aren't supported.
+func resolveSplitDir(yamlDir, path, split string) (string, bool) {
+	if filepath.IsAbs(split) || strings.HasPrefix(split, "[") || filepath.Ext(split) == ".txt" {
+		return "", false
+	}
+	base := yamlDir
+	if path != "" && !filepath.IsAbs(path) {
+		base = filepath.Join(yamlDir, path)
+	}
+	outside := func(dir string) bool { return dir == ".." || strings.HasPrefix(dir, "../") }
+	dir := filepath.ToSlash(filepath.Join(base, split))
+	if rest, ok := strings.CutPrefix(filepath.ToSlash(split), "../"); ok && outside(dir) {
+		dir = filepath.ToSlash(filepath.Join(base, rest))
+	}
+	if outside(dir) || dir == "." {
+		return "", false
+	}
+	return dir, true
+}
+
+// split returns the name of the split file belongs to, or "".
+func (l datasetLayout) split(file string) string {
+	dir := filepath.ToSlash(filepath.Dir(imagePath(file)))
+	for _, s := range l.splits {
+		if s.list != "" {
+			if s.members[file] {
+				return s.name
+			}
+		} else if s.dir == dir {
+			return s.name
+		}
+	}
+	return ""
+}
+
+// loadClassNames returns the dataset's class names, from data.yaml or the
+// Darknet .data file if it has one, or otherwise labels.txt, one per line.
+func loadClassNames(backend storage.Storage) ([]string, error) {
+	layout, err := loadDatasetLayout(backend)
+	if err != nil {
+		return nil, fmt.Errorf("loading %s: %w", layout.dataFile, err)
+	}
+	return layoutClassNames(backend, layout)
+}
+
+// layoutClassNames returns the class names of a dataset with the given
+// layout, as loadClassNames does.
+func layoutClassNames(backend storage.Storage, layout datasetLayout) ([]string, error) {
+	if layout.names != nil {
+		return layout.names, nil
+	}
+	file, err := backend.Open("labels.txt")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var labels []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		labels = append(labels, scanner.Text())
+	}
+	return labels, scanner.Err()
+}
+
+// classNamesFiles returns the contents of the files recording the dataset's
+// classes, by file name, changed to hold names: labels.txt; or data.yaml; or
+// a Darknet .names file and the class count in its .data file.
+func classNamesFiles(backend storage.Storage, names []string) (map[string]string, error) {
+	layout, err := loadDatasetLayout(backend)
+	if err != nil {
+		return nil, fmt.Errorf("loading %s: %w", layout.dataFile, err)
+	}
+	switch {
+	case layout.namesFile == "":
+		return map[string]string{"labels.txt": linesText(names)}, nil
+	case layout.namesFile == dataYAMLFile:
+		content, err := readFileText(backend, dataYAMLFile)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]string{dataYAMLFile: replaceYAMLNames(content, names)}, nil
+	}
+	content, err := readFileText(backend, layout.dataFile)
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSuffix(content, "\n"), "\n") {
+		if key, _, ok := strings.Cut(line, "="); ok && strings.TrimSpace(key) == "classes" {
+			line = fmt.Sprintf("classes = %d", len(names))
+		}
+		lines = append(lines, line)
+	}
+	return map[string]string{
+		layout.namesFile: linesText(names),
+		layout.dataFile:  linesText(lines),
+	}, nil
+}
+
+// saveClassNames writes the dataset's class names wherever it keeps them.
+func saveClassNames(backend storage.Storage, names []string) error {
+	files, err := classNamesFiles(backend, names)
+	if err != nil {
+		return err
+	}
+	for filename, content := range files {
+		if err := writeFileText(backend, filename, content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replaceYAMLNames returns a data.yaml with its names, and class count if it
+// has one, replaced.
+func replaceYAMLNames(content string, names []string) string {
+	var b strings.Builder
+	inNames, replaced := false, false
+	for _, line := range strings.Split(strings.TrimSuffix(content, "\n"), "\n") {
+		stripped := stripYAMLComment(line)
+		if inNames {
+			// An unindented comment ends the old names.
+			if !strings.HasPrefix(line, "#") && (strings.TrimSpace(stripped) == "" || stripped[0] == ' ' || stripped[0] == '\t' || stripped[0] == '-') {
+				continue
+			}
+			inNames = false
+		}
+		key, value, _ := strings.Cut(stripped, ":")
+		switch key {
+		case "names":
+			inNames = strings.TrimSpace(value) == ""
+			b.WriteString(yamlNames(names))
+			replaced = true
+			continue
+		case "nc":
+			fmt.Fprintf(&b, "nc: %d\n", len(names))
+			continue
+		}
+		b.WriteString(line + "\n")
+	}
+	if !replaced {
+		b.WriteString(yamlNames(names))
+	}
+	return b.String()
+}
+
+// yamlNames formats class names as a data.yaml names map.
+func yamlNames(names []string) string {
+	var b strings.Builder
+	b.WriteString("names:\n")
+	for i, name := range names {
+		fmt.Fprintf(&b, "  %d: %s\n", i, strconv.Quote(name))
+	}
+	return b.String()
+}
+
+// readFileText returns a file's contents.
+func readFileText(backend storage.Storage, filename string) (string, error) {
+	file, err := backend.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	return string(data), err
+}
+
+// writeFileText replaces a file's contents.
+func writeFileText(backend storage.Storage, filename, text string) error {
+	w, err := backend.OpenWrite(filename, false)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, text); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// replaceFiles replaces files with new contents, given by file name. Every
+// new file is first written beside its original, named with suffix, and only
+// once they have all been written are they renamed over the originals, so a
+// failure while writing leaves the files as they were. If a rename fails, the
+// new files not yet in place keep their suffix and are logged, so they can be
+// put in place by hand.
+func replaceFiles(backend storage.Storage, files map[string]string, suffix string) error {
+	names := slices.Sorted(maps.Keys(files))
+	for i, filename := range names {
+		if err := writeFileText(backend, filename+suffix, files[filename]); err != nil {
+			for _, written := range names[:i+1] {
+				backend.Remove(written + suffix)
+			}
+			return fmt.Errorf("writing %s: %w", filename, err)
+		}
+	}
+	// The cache holds the old label files.
+	if cache != nil {
+		defer cache.Purge()
+	}
+	for i, filename := range names {
+		if err := backend.Rename(filename+suffix, filename); err != nil {
+			for _, left := range names[i:] {
+				log.Printf("%s is not yet in place: rename %s over it", left, left+suffix)
+			}
+			return fmt.Errorf("replacing %s: %w; %d of %d files were replaced, and the other %d are still beside theirs as %s files (see the log)",
+				filename, err, i, len(names), len(names)-i, suffix)
+		}
+	}
+	return nil
+}
+
+// linesText joins lines, ending each with a newline.
+func linesText(lines []string) string {
+	var b strings.Builder
+	for _, line := range lines {
+		b.WriteString(line + "\n")
+	}
+	return b.String()
+}
+
+// sidecarNames lists the files matching pattern in the directories that the
+// sidecar files of files, named by sidecar, go in, so a scan only opens those
+// that exist. Only base names are kept, so an image may appear to have a
+// sidecar that belongs to one of the same name in another split.
+func sidecarNames(backend storage.Storage, files []string, sidecar func(string) string, pattern string) map[string]bool {
+	var dirs []string
+	for _, file := range files {
+		if dir := filepath.Dir(sidecar(file)); !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	names := map[string]bool{}
+	for _, dir := range dirs {
+		if matches, err := backend.Glob(dir, pattern); err == nil {
+			for _, match := range matches {
+				names[filepath.Base(match)] = true
+			}
+		}
+	}
+	return names
+}
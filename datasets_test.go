@@ -0,0 +1,145 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseDataYAML(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		yaml     string
+		splits   []string // name=dir
+		names    []string
+		wantErr  bool
+	}{
+		{
+			name:   "ultralytics",
+			yaml:   "train: images/train\nval: images/val\nnames: ['car', \"person\"]\n",
+			splits: []string{"train=images/train", "val=images/val"},
+			names:  []string{"car", "person"},
+		},
+		{
+			name:   "block names list",
+			yaml:   "train: images # all of them\nnames:\n  - car\n  - person # people\nnc: 2\n",
+			splits: []string{"train=images"},
+			names:  []string{"car", "person"},
+		},
+		{
+			name:  "names map",
+			yaml:  "names:\n  0: car\n  1: 'traffic light'\n",
+			names: []string{"car", "traffic light"},
+		},
+		{
+			name:   "path after the splits",
+			yaml:   "train: images/train\nval: images/val\npath: coco8\nnames: [car]\n",
+			splits: []string{"train=coco8/images/train", "val=coco8/images/val"},
+			names:  []string{"car"},
+		},
+		{
+			name:   "absolute path",
+			yaml:   "path: /data/datasets/coco8\ntrain: images/train\n",
+			splits: []string{"train=images/train"},
+		},
+		{
+			name:   "roboflow",
+			yaml:   "train: ../train/images\nval: ../valid/images\ntest: ../test/images\nnc: 1\nnames: ['car']\n",
+			splits: []string{"train=train/images", "val=valid/images", "test=test/images"},
+			names:  []string{"car"},
+		},
+		{
+			name:     "relative to a nested yaml",
+			filename: "configs/data.yaml",
+			yaml:     "train: ../images/train\nval: ../../../elsewhere/images\n",
+			splits:   []string{"train=images/train"},
+		},
+		{
+			name: "unsupported splits left out",
+			yaml: "train: train.txt\nval: [images/a, images/b]\ntest: /abs/images\n",
+		},
+		{
+			name:   "block scalar and nested map",
+			yaml:   "download: |\n  train: elsewhere\n  names: [wrong]\nextra:\n  val: elsewhere\ntrain: images/train\nnames:\n  0: car\n",
+			splits: []string{"train=images/train"},
+			names:  []string{"car"},
+		},
+		{
+			name:  "quoted commas",
+			yaml:  "names: ['car, parked', \"person\", 3]\n",
+			names: []string{"car, parked", "person", "3"},
+		},
+		{
+			name:    "gap in names",
+			yaml:    "names:\n  0: car\n  2: person\n",
+			wantErr: true,
+		},
+		{
+			name:    "bad class number",
+			yaml:    "names:\n  zero: car\n",
+			wantErr: true,
+		},
+		{
+			name:    "names not a list",
+			yaml:    "names: car\n",
+			wantErr: true,
+		},
+		{
+			name:    "unterminated names",
+			yaml:    "names: [car, person\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := tt.filename
+			if filename == "" {
+				filename = dataYAMLFile
+			}
+			layout, err := parseDataYAML(strings.NewReader(tt.yaml), filename)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseDataYAML succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseDataYAML: %s", err)
+			}
+			var splits []string
+			for _, s := range layout.splits {
+				splits = append(splits, s.name+"="+s.dir)
+			}
+			if !slices.Equal(splits, tt.splits) {
+				t.Errorf("splits = %v, want %v", splits, tt.splits)
+			}
+			if !slices.Equal(layout.names, tt.names) {
+				t.Errorf("names = %q, want %q", layout.names, tt.names)
+			}
+		})
+	}
+}
+
+func TestLayoutSplit(t *testing.T) {
+	layout := datasetLayout{splits: []datasetSplit{
+		{name: "train", dir: "images/train"},
+		{name: "val", dir: "valid/images"},
+		{name: "all", dir: "images"},
+	}}
+	tests := []struct {
+		file, want string
+	}{
+		{"train/a.jpg", "train"},
+		{"train/clip.mp4@000003", "train"},
+		{"/valid/images/b.jpg", "val"},
+		{"c.jpg", "all"},
+		{"test/d.jpg", ""},
+		{"/train/images/e.jpg", ""},
+	}
+	for _, tt := range tests {
+		if got := layout.split(tt.file); got != tt.want {
+			t.Errorf("split(%q) = %q, want %q", tt.file, got, tt.want)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"image"
 	"image/color"
 	"log"
+	"math"
 
 	"github.com/guigui-gui/guigui"
 	"github.com/guigui-gui/guigui/basicwidget"
@@ -13,8 +14,13 @@ import (
 	"github.com/hajimehoshi/ebiten/v2/vector"
 )
 
+// maxZoomPixels is how many screen pixels one image pixel may cover at the
+// editor's highest zoom.
+const maxZoomPixels = 8
+
 // regionEditor displays the current image aspect-fit and lets the user draw,
-// delete and re-tag regions with the mouse.
+// delete and re-tag regions with the mouse. Ctrl+wheel zooms in around the
+// cursor and dragging with the middle button pans.
 type regionEditor struct {
 	guigui.DefaultWidget
 
@@ -28,6 +34,17 @@ type regionEditor struct {
 	// lastAspect is the height/width ratio of the most recent image, kept so
 	// the layout stays stable while the next image is decoding.
 	lastAspect float64
+
+	// bounds is the editor's area as of the last layout. zoom is the
+	// displayed image width as a multiple of the editor width, and pan the
+	// offset of the editor's top left corner into the zoomed image. Both are
+	// kept when moving to another image.
+	bounds   image.Rectangle
+	zoom     float64
+	pan      image.Point
+	panning  bool
+	panFrom  image.Point // cursor position when panning started
+	panStart image.Point
 }
 
 func (e *regionEditor) SetModel(m *appModel) {
@@ -42,7 +59,8 @@ func (e *regionEditor) cancelDrawing() {
 }
 
 // imageRect returns the rectangle the image is displayed in: the full width
-// of the widget, with the height following from the image's aspect ratio.
+// of the widget times the zoom, with the height following from the image's
+// aspect ratio, offset by the pan. Only the part within bounds is visible.
 func (e *regionEditor) imageRect(bounds image.Rectangle) image.Rectangle {
 	if e.model == nil || e.model.displayImage == nil {
 		return image.Rectangle{}
@@ -51,9 +69,55 @@ func (e *regionEditor) imageRect(bounds image.Rectangle) image.Rectangle {
 	if size.X <= 0 || size.Y <= 0 {
 		return image.Rectangle{}
 	}
-	w := bounds.Dx()
+	w := int(float64(bounds.Dx()) * max(e.zoom, 1))
 	h := size.Y * w / size.X
-	return image.Rectangle{Min: bounds.Min, Max: bounds.Min.Add(image.Pt(w, h))}
+	min := bounds.Min.Sub(e.pan)
+	return image.Rectangle{Min: min, Max: min.Add(image.Pt(w, h))}
+}
+
+// maxZoom lets the image be enlarged until one pixel covers maxZoomPixels
+// screen pixels.
+func (e *regionEditor) maxZoom(bounds image.Rectangle) float64 {
+	if e.model == nil || e.model.displayImage == nil || bounds.Dx() <= 0 {
+		return 1
+	}
+	return max(1, maxZoomPixels*float64(e.model.displayImage.Bounds().Dx())/float64(bounds.Dx()))
+}
+
+// zoomAt scales the zoom by factor, keeping the image point under at fixed.
+func (e *regionEditor) zoomAt(at image.Point, factor float64) {
+	bounds := e.bounds
+	before := e.imageRect(bounds)
+	if before.Empty() {
+		return
+	}
+	e.zoom = min(max(max(e.zoom, 1)*factor, 1), e.maxZoom(bounds))
+	after := e.imageRect(bounds)
+	scale := float64(after.Dx()) / float64(before.Dx())
+	p := at.Sub(before.Min)
+	e.pan = image.Pt(int(float64(p.X)*scale), int(float64(p.Y)*scale)).Sub(at.Sub(bounds.Min))
+	e.clampPan()
+	guigui.RequestRedraw(e)
+}
+
+// zoomBy zooms around the centre of the editor.
+func (e *regionEditor) zoomBy(factor float64) {
+	b := e.bounds
+	e.zoomAt(b.Min.Add(b.Size().Div(2)), factor)
+}
+
+// resetZoom fits the whole image in the editor again.
+func (e *regionEditor) resetZoom() {
+	e.zoom = 1
+	e.pan = image.Point{}
+	guigui.RequestRedraw(e)
+}
+
+// clampPan keeps the zoomed image covering the editor.
+func (e *regionEditor) clampPan() {
+	ir := e.imageRect(e.bounds)
+	e.pan.X = min(max(e.pan.X, 0), max(ir.Dx()-e.bounds.Dx(), 0))
+	e.pan.Y = min(max(e.pan.Y, 0), max(ir.Dy()-e.bounds.Dy(), 0))
 }
 
 // Measure sizes the editor to the full available width, with the height
@@ -96,8 +160,14 @@ func (e *regionEditor) Build(context *guigui.Context, adder *guigui.ChildAdder)
 	for i := range n {
 		region := m.currentRegions.Regions[i]
 		t := e.labelTexts.At(i)
-		t.SetValue(fmt.Sprintf("%s - %d", m.labelName(region.index), region.index))
-		t.SetColor(region.Color())
+		label := fmt.Sprintf("%s - %d", m.labelName(region.index), region.index)
+		if track, ok := m.currentState.track(region); ok && track.Key {
+			label += fmt.Sprintf(" (track %d)", track.ID)
+		} else if ok {
+			label += fmt.Sprintf(" (track %d, interpolated)", track.ID)
+		}
+		t.SetValue(label)
+		t.SetColor(m.classColor(region.index))
 	}
 	return nil
 }
@@ -107,16 +177,23 @@ func (e *regionEditor) Layout(context *guigui.Context, widgetBounds *guigui.Widg
 	if m == nil {
 		return
 	}
-	ir := e.imageRect(widgetBounds.Bounds())
+	e.bounds = widgetBounds.Bounds()
+	e.clampPan()
+	ir := e.imageRect(e.bounds)
 	lh := basicwidget.LineHeight(context)
 	u := basicwidget.UnitSize(context)
 	for i := range e.labelTexts.Len() {
 		if i >= len(m.currentRegions.Regions) {
 			break
 		}
-		rr := regionRect(m.currentRegions.Regions[i], ir)
+		rr := regionRect(m.displayRegion(m.currentFile(), m.currentRegions.Regions[i]), ir)
 		pos := image.Pt(rr.Min.X+rr.Dx()/2, rr.Min.Y-lh)
-		layouter.LayoutWidget(e.labelTexts.At(i), image.Rectangle{Min: pos, Max: pos.Add(image.Pt(u*8, lh))})
+		r := image.Rectangle{Min: pos, Max: pos.Add(image.Pt(u*8, lh))}
+		// Labels of regions zoomed out of view are hidden.
+		if !r.In(e.bounds) {
+			r = image.Rectangle{}
+		}
+		layouter.LayoutWidget(e.labelTexts.At(i), r)
 	}
 }
 
@@ -125,8 +202,40 @@ func (e *regionEditor) HandlePointingInput(context *guigui.Context, widgetBounds
 	if m == nil || m.displayImage == nil {
 		return guigui.HandleInputResult{}
 	}
-	ir := e.imageRect(widgetBounds.Bounds())
+	e.bounds = widgetBounds.Bounds()
+	ir := e.imageRect(e.bounds)
 	cursor := image.Pt(ebiten.CursorPosition())
+	// Only the part of a zoomed image within the editor can be clicked.
+	visible := ir.Intersect(e.bounds)
+
+	if e.panning {
+		if !ebiten.IsMouseButtonPressed(ebiten.MouseButtonMiddle) {
+			e.panning = false
+		}
+		e.pan = e.panStart.Sub(cursor.Sub(e.panFrom))
+		e.clampPan()
+		guigui.RequestRedraw(e)
+		return guigui.HandleInputByWidget(e)
+	}
+
+	if !e.drawingRect && cursor.In(visible) {
+		if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonMiddle) {
+			e.panning = true
+			e.panFrom = cursor
+			e.panStart = e.pan
+			return guigui.HandleInputByWidget(e)
+		}
+		if _, dy := ebiten.Wheel(); dy != 0 && (ebiten.IsKeyPressed(ebiten.KeyControl) || ebiten.IsKeyPressed(ebiten.KeyMeta)) {
+			e.zoomAt(cursor, math.Pow(1.25, dy))
+			return guigui.HandleInputByWidget(e)
+		}
+	}
+
+	// Regions can't be edited while a remap rewrites them.
+	if m.labelsLocked() {
+		e.drawingRect = false
+		return guigui.HandleInputResult{}
+	}
 
 	if e.drawingRect {
 		// Keep the widget repainting so the in-progress rectangle tracks the cursor.
@@ -135,7 +244,7 @@ func (e *regionEditor) HandlePointingInput(context *guigui.Context, widgetBounds
 			end := cursor.Sub(ir.Min)
 			// Create a new well formed region clamped within the image
 			newRect := image.Rect(e.drawingStart.X, e.drawingStart.Y, end.X, end.Y)
-			newRect = newRect.Intersect(image.Rect(0, 0, ir.Dx(), ir.Dy())).Canon()
+			newRect = newRect.Canon().Intersect(visible.Sub(ir.Min))
 			log.Printf("New rect: %v", newRect)
 			newRegion := Region{
 				xMid:   (float64(newRect.Dx())/2 + float64(newRect.Min.X)) / float64(ir.Dx()),
@@ -144,36 +253,31 @@ func (e *regionEditor) HandlePointingInput(context *guigui.Context, widgetBounds
 				height: float64(newRect.Dy()) / float64(ir.Dy()),
 				index:  m.drawingIndex,
 			}
-			m.currentRegions.AddRegion(newRegion)
+			m.currentRegions.AddRegion(m.labelRegion(m.currentFile(), newRegion))
+			m.regionsChanged()
 			e.drawingRect = false
 		}
 		return guigui.HandleInputByWidget(e)
 	}
 
-	if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) && cursor.In(ir) {
+	if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) && cursor.In(visible) {
 		e.drawingRect = true
 		e.drawingStart = cursor.Sub(ir.Min)
 		return guigui.HandleInputByWidget(e)
 	}
 
-	if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonRight) && cursor.In(ir) {
-		changeRegion := -1
-		// If we're pressing a number key, change the region type, otherwise delete it
-		for d := range 10 {
-			if ebiten.IsKeyPressed(ebiten.KeyDigit0 + ebiten.Key(d)) {
-				changeRegion = d
-			}
-		}
+	if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonRight) && cursor.In(visible) {
+		// If we're pressing a class's hotkey, change the region type, otherwise delete it
+		changeRegion := m.pressedClass(ebiten.IsKeyPressed, nil)
 		// Find the region that was clicked
 		click := cursor.Sub(ir.Min)
 		index := m.getClosestRegion(click, ir.Dx(), ir.Dy())
 		if index >= 0 {
 			if changeRegion >= 0 {
-				m.currentRegions.Regions[index].index = changeRegion
-				// Do this async so we don't block the UI
-				go m.currentRegions.Save()
+				m.retagRegion(index, changeRegion)
 			} else {
 				m.currentRegions.Remove(index)
+				m.regionsChanged()
 			}
 		}
 		return guigui.HandleInputByWidget(e)
@@ -182,22 +286,73 @@ func (e *regionEditor) HandlePointingInput(context *guigui.Context, widgetBounds
 	return guigui.HandleInputResult{}
 }
 
+// regionAtCursor returns the index of the selected file's region under the
+// mouse cursor, or -1.
+func (e *regionEditor) regionAtCursor() int {
+	m := e.model
+	if m == nil || m.displayImage == nil {
+		return -1
+	}
+	ir := e.imageRect(e.bounds)
+	cursor := image.Pt(ebiten.CursorPosition())
+	if !cursor.In(ir.Intersect(e.bounds)) {
+		return -1
+	}
+	return m.getClosestRegion(cursor.Sub(ir.Min), ir.Dx(), ir.Dy())
+}
+
+// suggestionAtCursor returns the shown suggestion under the mouse cursor.
+func (e *regionEditor) suggestionAtCursor() (Suggestion, bool) {
+	m := e.model
+	if m == nil || m.displayImage == nil {
+		return Suggestion{}, false
+	}
+	ir := e.imageRect(e.bounds)
+	cursor := image.Pt(ebiten.CursorPosition())
+	if !cursor.In(ir.Intersect(e.bounds)) {
+		return Suggestion{}, false
+	}
+	return m.suggestionAt(cursor.Sub(ir.Min), ir.Dx(), ir.Dy())
+}
+
 func (e *regionEditor) Draw(context *guigui.Context, widgetBounds *guigui.WidgetBounds, dst *ebiten.Image) {
 	m := e.model
 	if m == nil || m.displayImage == nil {
 		return
 	}
-	ir := e.imageRect(widgetBounds.Bounds())
-	size := m.displayImage.Bounds().Size()
-
-	op := &ebiten.DrawImageOptions{}
-	op.GeoM.Scale(float64(ir.Dx())/float64(size.X), float64(ir.Dy())/float64(size.Y))
-	op.GeoM.Translate(float64(ir.Min.X), float64(ir.Min.Y))
-	op.Filter = ebiten.FilterLinear
-	dst.DrawImage(m.displayImage, op)
+	bounds := widgetBounds.Bounds()
+	ir := e.imageRect(bounds)
+	// A zoomed image, and regions within it, must not draw outside the editor.
+	dst = dst.SubImage(bounds.Intersect(dst.Bounds())).(*ebiten.Image)
+	m.displayImage.draw(dst, ir)
 
 	for _, region := range m.currentRegions.Regions {
-		strokeRect(dst, regionRect(region, ir), region.Color())
+		strokeRect(dst, regionRect(m.displayRegion(m.currentFile(), region), ir), m.classColor(region.index))
+	}
+
+	for _, s := range m.shownSuggestions() {
+		dashRect(dst, regionRect(m.displayRegion(m.currentFile(), s.Region), ir), m.classColor(s.Region.index))
+	}
+
+	// Comparing, predictions are coloured by whether they found a region,
+	// and regions nothing found get a second outline.
+	if m.comparing {
+		d := m.currentComparison()
+		for _, s := range d.found {
+			dashRect(dst, regionRect(m.displayRegion(m.currentFile(), s.Region), ir), compareFoundColor)
+		}
+		for _, s := range d.wrong {
+			dashRect(dst, regionRect(m.displayRegion(m.currentFile(), s.Region), ir), compareWrongColor)
+		}
+		for _, region := range d.missed {
+			strokeRect(dst, regionRect(m.displayRegion(m.currentFile(), region), ir).Inset(-4), compareMissedColor)
+		}
+	}
+
+	// Tracker proposals are drawn thinner until they are accepted.
+	for _, p := range m.proposals {
+		r := regionRect(p, ir)
+		vector.StrokeRect(dst, float32(r.Min.X), float32(r.Min.Y), float32(r.Dx()), float32(r.Dy()), 1, p.Color(), false)
 	}
 
 	if e.drawingRect {
@@ -210,3 +365,23 @@ func (e *regionEditor) Draw(context *guigui.Context, widgetBounds *guigui.Widget
 func strokeRect(dst *ebiten.Image, r image.Rectangle, clr color.Color) {
 	vector.StrokeRect(dst, float32(r.Min.X), float32(r.Min.Y), float32(r.Dx()), float32(r.Dy()), 2, clr, false)
 }
+
+// dashRect outlines r with a dashed line, as used for suggestions.
+func dashRect(dst *ebiten.Image, r image.Rectangle, clr color.Color) {
+	const dash, gap = 6, 4
+	line := func(x0, y0, x1, y1 int) {
+		length := max(x1-x0, y1-y0)
+		for d := 0; d < length; d += dash + gap {
+			e := min(d+dash, length)
+			if x0 == x1 {
+				vector.StrokeLine(dst, float32(x0), float32(y0+d), float32(x0), float32(y0+e), 2, clr, false)
+			} else {
+				vector.StrokeLine(dst, float32(x0+d), float32(y0), float32(x0+e), float32(y0), 2, clr, false)
+			}
+		}
+	}
+	line(r.Min.X, r.Min.Y, r.Max.X, r.Min.Y)
+	line(r.Min.X, r.Max.Y, r.Max.X, r.Max.Y)
+	line(r.Min.X, r.Min.Y, r.Min.X, r.Max.Y)
+	line(r.Max.X, r.Min.Y, r.Max.X, r.Max.Y)
+}
@@ -1,22 +1,21 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
+	"context"
 	"errors"
 	"flag"
 	"fmt"
 	"image"
-	"image/color"
 	"log"
+	"os"
 	"path/filepath"
 	"slices"
 	"strings"
 	"sync"
+	"time"
 
 	_ "embed"
-	_ "image/jpeg"
-	_ "image/png"
 
 	"github.com/AndreRenaud/fastmark/storage"
 	"github.com/guigui-gui/guigui"
@@ -43,9 +42,10 @@ func copyToClipboard(text string) {
 //go:embed icon-128.png
 var iconData []byte
 
-// maxImageDimension caps decoded images so they stay within common GPU
-// texture size limits.
-const maxImageDimension = 8192
+// maxImageDimension caps decoded images to bound their memory use. Anything
+// up to it is shown at native resolution, split into tiles if it exceeds the
+// GPU texture limit.
+const maxImageDimension = 32768
 
 type Metadata struct {
 	Total          int
@@ -53,10 +53,42 @@ type Metadata struct {
 	Scanned        int
 	TotalRegions   int
 	CategoryTotals []int
+	StatusTotals   [statusCount]int
+	// Negatives counts images verified to contain no objects.
+	Negatives int
+	// Dated counts images whose label file's modification time has been
+	// read, which is only done once something needs it.
+	Dated int
 }
 
 func (m Metadata) Summary() string {
-	return fmt.Sprintf("Total: %d, Scanned %d (%d%%) Categorised: %d (%d%%)", m.Total, m.Scanned, m.ScannedPercent(), m.Categorised, m.Percent())
+	summary := fmt.Sprintf("Total: %d, Scanned %d (%d%%) Categorised: %d (%d%%)", m.Total, m.Scanned, m.ScannedPercent(), m.Categorised, m.Percent())
+	summary += fmt.Sprintf(" Negatives: %d (%d%% background)", m.Negatives, m.NegativePercent())
+	for s := StatusNeedsReview; s < statusCount; s++ {
+		summary += fmt.Sprintf(" %s: %d", s, m.StatusTotals[s])
+	}
+	return summary
+}
+
+// add adds (delta 1) or removes (delta -1) one file's contribution to the
+// totals, so edits can replace a file's summary without a full rescan.
+func (m *Metadata) add(s fileSummary, delta int) {
+	if !s.scanned {
+		return
+	}
+	for _, region := range s.regions {
+		if region.index >= 0 && region.index < len(m.CategoryTotals) {
+			m.CategoryTotals[region.index] += delta
+		}
+		m.TotalRegions += delta
+	}
+	if len(s.regions) > 0 {
+		m.Categorised += delta
+	} else if s.negative {
+		m.Negatives += delta
+	}
+	m.StatusTotals[s.status] += delta
+	m.Scanned += delta
 }
 
 func (m Metadata) Percent() int {
@@ -66,6 +98,15 @@ func (m Metadata) Percent() int {
 	return m.Categorised * 100 / m.Total
 }
 
+// NegativePercent is the share of labelled images that are verified
+// negatives, i.e. the dataset's background-image ratio.
+func (m Metadata) NegativePercent() int {
+	if m.Categorised+m.Negatives == 0 {
+		return 0
+	}
+	return m.Negatives * 100 / (m.Categorised + m.Negatives)
+}
+
 func (m Metadata) ScannedPercent() int {
 	if m.Total == 0 {
 		return 0
@@ -73,20 +114,54 @@ func (m Metadata) ScannedPercent() int {
 	return m.Scanned * 100 / m.Total
 }
 
+type viewMode int
+
+const (
+	viewEditor viewMode = iota
+	viewGrid
+	viewGallery
+	viewClasses
+)
+
+// fileSummary is what the metadata scan learned about one image, kept so the
+// file list can be coloured and filtered without re-reading label files.
+type fileSummary struct {
+	scanned  bool
+	regions  []Region
+	status   ImageStatus
+	negative bool
+	// modified is when the label file was last changed, or zero if there
+	// isn't one. It is only read once the filter or sort needs it, by
+	// readModifiedTimes, which sets dated.
+	modified time.Time
+	dated    bool
+	// predicted records that the image has a predictions file, and
+	// uncertainty how unsure the detector was of it. errors counts the
+	// predictions and labels that don't match up.
+	predicted   bool
+	predictions []Suggestion
+	uncertainty float64
+	errors      int
+}
+
 // decodedImage is the result of an asynchronous image decode. display is what
-// should be shown (possibly contrast-stretched); source is the original
-// decode, kept so auto-contrast can be re-applied without re-reading the file.
-// source is nil when only the contrast setting changed.
+// should be shown, with adjust applied; source is the original decode, kept
+// so adjustments can be re-applied without re-reading the file. source is
+// nil when the decode failed or was cancelled, or was a prefetch of an image
+// that was tooLarge.
 type decodedImage struct {
-	gen     uint64
-	source  image.Image
-	display image.Image
+	job      uint64
+	file     string
+	adjust   imageAdjust
+	source   image.Image
+	display  image.Image
+	tooLarge bool
 }
 
 // appModel holds all application state. It is only mutated on the main
 // goroutine (input handlers and Tick); background goroutines communicate
-// results back over the decoded/chosenDirs channels. metadata is the
-// exception: scan workers update it directly under metadataMu.
+// results back over the decoded/chosenDirs channels. metadata and summaries
+// are the exception: scan workers update them directly under metadataMu.
 type appModel struct {
 	backend storage.Storage
 
@@ -97,24 +172,132 @@ type appModel struct {
 	selectedIndex int
 	filter        string
 
-	currentImage image.Image   // decoded source of the selected file
-	displayImage *ebiten.Image // texture currently shown by the editor
-	imageGen     uint64        // bumped whenever displayImage changes
-	loadGen      uint64        // bumped on navigation; stale decodes are dropped
+	// visible holds the indices into files shown in the sidebar, in display
+	// order, and visiblePos maps a file index back to its position in
+	// visible (or -1). They are rebuilt whenever viewGen changes.
+	visible      []int
+	visiblePos   []int
+	viewGen      int
+	statusFilter ImageStatus // statusCount shows every status
+	fileFilter   fileFilter
+	sortOrder    sortOrder
+	// layout is the dataset's data.yaml, if it has one, and split the split
+	// shown, or "" for them all.
+	layout datasetLayout
+	split  string
+
+	currentImage image.Image // decoded source of the selected file
+	displayImage *tiledImage // textures currently shown by the editor
+	imageGen     uint64      // bumped whenever displayImage changes
+
+	// shownAdjust is the adjustment displayImage was made with.
+	shownAdjust imageAdjust
+
+	// images caches decoded files so navigating back and forth, or onto a
+	// prefetched neighbour, doesn't wait for a decode. decoding holds the
+	// decodes in flight, which prefetchAround cancels once they're no longer
+	// near the selection.
+	images   imageCache
+	decoding map[string]decodeJob
+	decodeID uint64
+	// tooLarge holds the files too large to prefetch.
+	tooLarge map[string]bool
 
 	currentRegions RegionList
+	currentState   ImageState
 	drawingIndex   int
-
-	// autoContrast stretches each displayed image's histogram so the
-	// darkest value maps to 0 and the brightest to 255.
-	autoContrast bool
+	// regionHistory holds the selected file's regions after each change
+	// since it was opened, newest last, for undo.
+	regionHistory [][]Region
+	keymap        keymap
+	// activeTrack is the track new keyframes are added to, or 0 to start a
+	// new one.
+	activeTrack int
+	// tracking is set while a track is being edited in the background, with
+	// the result arriving on trackResults.
+	tracking bool
+	// probing is set while videos are being probed in the background for
+	// their frames, with their backend sent on videosProbed when done.
+	probing bool
+	// carryRegions copies the previous file's regions into unlabelled files
+	// as they are opened. regionClipboard holds the last regions copied,
+	// relative to the displayed image.
+	carryRegions    bool
+	regionClipboard []Region
+
+	// follow is the regions being tracked into the selected file, and
+	// proposals the boxes the tracker found for them there, relative to the
+	// displayed image.
+	follow    *followState
+	proposals []Region
+
+	// suggestions are the detector's predictions for the selected file,
+	// shown if at least suggestThreshold percent confident.
+	suggestions      []Suggestion
+	suggestThreshold int
+	// comparing shows how the suggestions line up with the regions instead,
+	// a prediction matching a region if they overlap by compareIoU percent.
+	comparing  bool
+	compareIoU int
+
+	// model is the detector given with -model, if any. modelCancel stops the
+	// run in progress, whose results arrive on modelResults.
+	model       modelRunner
+	modelCancel context.CancelFunc
+	modelRun    int
+	modelStatus string
+	modelErrors int
+
+	// splitting is set while the dataset is being split in the background,
+	// with the result arriving on splitResults.
+	splitting   bool
+	splitStatus string
+	// splitPreviewed is the split into directories last previewed, which
+	// moves the files if it is asked for again.
+	splitPreviewed *splitSpec
+	// remapping is set while the classes are being remapped, or a remap
+	// previewed, in the background, with the result arriving on
+	// remapResults.
+	remapping   bool
+	remapDryRun bool
+	remapStatus string
+	// reorienting is set while the labels are being converted to another
+	// orientation in the background, with the result arriving on
+	// orientResults.
+	reorienting bool
+
+	// view selects what is shown next to the file list.
+	view       viewMode
+	thumbnails thumbnailCache
+	crops      thumbnailCache
+	// cropSources keeps the images crops were last cut from.
+	cropSources cropSourceCache
+	// galleryClass is the class whose regions the crop gallery shows.
+	galleryClass int
+
+	// adjust is the contrast, brightness and gamma adjustment applied to
+	// each displayed image.
+	adjust imageAdjust
+
+	settings     DatasetSettings
+	orientations orientationMap
 
 	metadataMu  sync.Mutex
 	metadata    Metadata
+	summaries   []fileSummary
 	metadataGen int
-
-	decoded    chan decodedImage
-	chosenDirs chan string
+	// datedGen is the metadataGen whose modification times have been read.
+	datedGen int
+
+	decoded       chan decodedImage
+	proposed      chan proposedRegions
+	modelResults  chan modelProgress
+	splitResults  chan splitResult
+	remapResults  chan remapResult
+	orientResults chan orientResult
+	trackResults  chan trackResult
+	videosProbed  chan storage.Storage
+	chosenDirs    chan string
 }
 
 func (m *appModel) labelName(index int) string {
@@ -151,13 +334,23 @@ func (m *appModel) startMetadataScan() {
 	m.metadataGen++
 	gen := m.metadataGen
 	m.metadata = Metadata{Total: len(m.files), CategoryTotals: make([]int, len(m.labels))}
+	m.summaries = make([]fileSummary, len(m.files))
 	m.metadataMu.Unlock()
 
 	files := slices.Clone(m.files)
 	backend := m.backend
+	predictionsDir := m.settings.predictionsDir()
+	iou, threshold := m.comparison()
 
 	go func() {
-		filesChan := make(chan string, len(files))
+		// Most images have no review state, so list the sidecars once rather
+		// than trying to open one per image.
+		hasState := sidecarNames(backend, files, stateFileName, "*.status")
+		hasPredictions := sidecarNames(backend, files, func(file string) string {
+			return predictionFileName(predictionsDir, file)
+		}, "*.txt")
+
+		filesChan := make(chan int, len(files))
 		var wg sync.WaitGroup
 		// This is mostly blocked by file I/O, especially on network drives,
 		// so run a bunch of parallel workers to compensate
@@ -166,95 +359,316 @@ func (m *appModel) startMetadataScan() {
 		for range workerCount {
 			go func() {
 				defer wg.Done()
-				for file := range filesChan {
-					ext := filepath.Ext(file)
-					labelFile := filepath.Join("labels", strings.TrimSuffix(file, ext)+".txt")
+				for i := range filesChan {
+					file := files[i]
 					// An error just means the image has no label file yet;
 					// count it as scanned but uncategorised.
-					regions, _ := LoadRegionList(backend, labelFile)
+					regions, _ := LoadRegionList(backend, labelFileName(file))
+					var state ImageState
+					if stateFile := stateFileName(file); hasState[filepath.Base(stateFile)] {
+						state, _ = LoadImageState(backend, stateFile)
+					}
+					summary := fileSummary{
+						scanned: true,
+						// Clone, as edits to the cached list happen in place.
+						regions:  slices.Clone(regions.Regions),
+						status:   effectiveStatus(state, len(regions.Regions)),
+						negative: state.Empty,
+					}
+					if predictions := predictionFileName(predictionsDir, file); hasPredictions[filepath.Base(predictions)] {
+						p, err := LoadPredictions(backend, predictions)
+						summary.predicted = err == nil
+						summary.predictions = p
+						summary.scorePredictions(iou, threshold)
+					}
 					m.metadataMu.Lock()
-					if m.metadataGen == gen {
-						for _, region := range regions.Regions {
-							if region.index >= 0 && region.index < len(m.metadata.CategoryTotals) {
-								m.metadata.CategoryTotals[region.index]++
-							}
-							m.metadata.TotalRegions++
-						}
-						if len(regions.Regions) > 0 {
-							m.metadata.Categorised++
-						}
-						m.metadata.Scanned++
+					// A summary that's already set came from an edit made
+					// since the scan started, which is newer than what we read.
+					if m.metadataGen == gen && !m.summaries[i].scanned {
+						summary.modified, summary.dated = m.summaries[i].modified, m.summaries[i].dated
+						m.metadata.add(summary, 1)
+						m.summaries[i] = summary
 					}
 					m.metadataMu.Unlock()
 				}
 			}()
 		}
-		for _, file := range files {
-			filesChan <- file
+		for i := range files {
+			filesChan <- i
 		}
 		close(filesChan)
 		wg.Wait()
 	}()
 }
 
-// autoContrastImage returns a copy of src with its histogram stretched so
-// that the darkest channel value present maps to 0 and the brightest to 255,
-// applying the same linear mapping to every channel to preserve colour.
-func autoContrastImage(src image.Image) image.Image {
-	bounds := src.Bounds()
-
-	// RGBA() reports channels in the range [0, 0xffff].
-	minV := uint32(0xffff)
-	maxV := uint32(0)
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			r, g, b, _ := src.At(x, y).RGBA()
-			for _, c := range [3]uint32{r, g, b} {
-				if c < minV {
-					minV = c
-				}
-				if c > maxV {
-					maxV = c
+// readModifiedTimes reads the modification time of every label file in the
+// background, unless it has already been done since the files were listed.
+// Only the modified filter and sort need them, so the metadata scan doesn't
+// pay for a stat per file.
+func (m *appModel) readModifiedTimes() {
+	m.metadataMu.Lock()
+	gen := m.metadataGen
+	m.metadataMu.Unlock()
+	if m.datedGen == gen {
+		return
+	}
+	m.datedGen = gen
+	files := slices.Clone(m.files)
+	backend := m.backend
+
+	go func() {
+		filesChan := make(chan int, len(files))
+		var wg sync.WaitGroup
+		// Stats are all network round trips over SFTP.
+		const workerCount = 50
+		for range workerCount {
+			wg.Go(func() {
+				for i := range filesChan {
+					var modified time.Time
+					if info, err := backend.Stat(labelFileName(files[i])); err == nil {
+						modified = info.ModTime()
+					}
+					m.metadataMu.Lock()
+					// An edit since has already dated the file.
+					if m.metadataGen == gen && !m.summaries[i].dated {
+						m.summaries[i].modified, m.summaries[i].dated = modified, true
+						m.metadata.Dated++
+					}
+					m.metadataMu.Unlock()
 				}
-			}
+			})
 		}
+		for i := range files {
+			filesChan <- i
+		}
+		close(filesChan)
+		wg.Wait()
+	}()
+}
+
+// summary returns the scanned summary for file index i, if there is one.
+func (m *appModel) summary(i int) fileSummary {
+	m.metadataMu.Lock()
+	defer m.metadataMu.Unlock()
+	if i < 0 || i >= len(m.summaries) {
+		return fileSummary{}
 	}
+	return m.summaries[i]
+}
 
-	// Flat or single-colour image: nothing to stretch.
-	if maxV <= minV {
-		return src
+// regionsChanged refreshes the selected file's summary after its regions or
+// review state were edited, keeping the metadata totals and file list current.
+func (m *appModel) regionsChanged() {
+	// Drawing a region on a verified negative means it wasn't one.
+	save := false
+	if m.currentState.Empty && len(m.currentRegions.Regions) > 0 {
+		m.currentState.Empty = false
+		save = true
 	}
+	if m.currentState.pruneTracks(m.currentRegions.Regions) {
+		save = true
+	}
+	if save {
+		m.currentState.SaveAsync()
+	}
+	if n := len(m.regionHistory); n == 0 || !slices.Equal(m.regionHistory[n-1], m.currentRegions.Regions) {
+		m.regionHistory = append(m.regionHistory, slices.Clone(m.currentRegions.Regions))
+	}
+	m.updateSummary(m.selectedIndex, m.currentRegions.Regions, m.currentState)
+}
 
-	scale := float64(0xffff) / float64(maxV-minV)
-	stretch := func(c uint32) uint8 {
-		v := float64(c-minV) * scale
-		if v > 0xffff {
-			v = 0xffff
-		}
-		return uint8(uint32(v) >> 8)
+// undoRegions puts the selected file's regions back as they were before the
+// last change.
+func (m *appModel) undoRegions() {
+	n := len(m.regionHistory)
+	if n < 2 {
+		return
 	}
+	m.regionHistory = m.regionHistory[:n-1]
+	m.currentRegions.Regions = slices.Clone(m.regionHistory[n-2])
+	log.Printf("Undid the last change to %s's regions", m.currentFile())
+	m.currentRegions.SaveAsync()
+	m.regionsChanged()
+}
 
-	dst := image.NewRGBA(bounds)
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			r, g, b, a := src.At(x, y).RGBA()
-			dst.SetRGBA(x, y, color.RGBA{stretch(r), stretch(g), stretch(b), uint8(a >> 8)})
-		}
+// updateSummary replaces file index i's summary after an edit.
+func (m *appModel) updateSummary(i int, regions []Region, state ImageState) {
+	summary := fileSummary{
+		scanned:  true,
+		regions:  slices.Clone(regions),
+		status:   effectiveStatus(state, len(regions)),
+		negative: state.Empty,
+		modified: time.Now(),
+		dated:    true,
 	}
-	return dst
+	m.metadataMu.Lock()
+	defer m.metadataMu.Unlock()
+	if i < 0 || i >= len(m.summaries) {
+		return
+	}
+	if !m.summaries[i].dated {
+		m.metadata.Dated++
+	}
+	// Edits don't change the predictions, but may fix or make errors.
+	summary.predicted = m.summaries[i].predicted
+	summary.predictions = m.summaries[i].predictions
+	if summary.predicted {
+		summary.scorePredictions(m.comparison())
+	}
+	if m.summaries[i].status != summary.status || m.summaries[i].errors != summary.errors {
+		m.viewGen++
+	}
+	m.metadata.add(m.summaries[i], -1)
+	m.metadata.add(summary, 1)
+	m.summaries[i] = summary
 }
 
-func loadImage(backend storage.Storage, filename string) (image.Image, error) {
-	f, err := backend.Open(filename)
-	if err != nil {
-		return nil, err
+// editRegion re-tags region in file index i as class index, or deletes it if
+// index is negative. It is used by views that edit files other than the one
+// open in the editor.
+func (m *appModel) editRegion(i int, region Region, index int) {
+	if i == m.selectedIndex {
+		m.editRegions(&m.currentRegions, region, index)
+		m.regionsChanged()
+		return
 	}
-	defer f.Close()
-	img, _, err := image.Decode(f)
+	regions, err := LoadRegionList(m.backend, labelFileName(m.files[i]))
 	if err != nil {
-		return nil, err
+		return
 	}
-	return capImageSize(img, maxImageDimension), nil
+	// Copy so the cached list isn't modified before it's saved.
+	regions.Regions = slices.Clone(regions.Regions)
+	m.editRegions(&regions, region, index)
+	state, _ := LoadImageState(m.backend, stateFileName(m.files[i]))
+	m.updateSummary(i, regions.Regions, state)
+}
+
+func (m *appModel) editRegions(regions *RegionList, region Region, index int) {
+	j := slices.Index(regions.Regions, region)
+	if j < 0 {
+		log.Printf("Region %#v not found in %s", region, regions.filename)
+		return
+	}
+	if index < 0 {
+		regions.Remove(j)
+		return
+	}
+	regions.Regions[j].index = index
+	regions.SaveAsync()
+}
+
+// setStatus sets the review status of the selected file, saving it in the
+// background.
+func (m *appModel) setStatus(status ImageStatus) {
+	if m.selectedIndex < 0 || m.selectedIndex >= len(m.files) {
+		return
+	}
+	m.currentState.Status = status
+	if status < StatusNeedsReview {
+		m.currentState.Comment = ""
+	}
+	log.Printf("Marking %s as %s", m.files[m.selectedIndex], effectiveStatus(m.currentState, len(m.currentRegions.Regions)))
+	m.currentState.SaveAsync()
+	m.regionsChanged()
+}
+
+// toggleEmpty marks the selected file as a verified negative, writing an
+// empty label file so training sees it as a background image, or clears the
+// mark if it is already set.
+func (m *appModel) toggleEmpty() {
+	if m.selectedIndex < 0 || m.selectedIndex >= len(m.files) {
+		return
+	}
+	if len(m.currentRegions.Regions) > 0 {
+		log.Printf("Not marking %s as empty: it has %d regions", m.files[m.selectedIndex], len(m.currentRegions.Regions))
+		return
+	}
+	m.currentState.Empty = !m.currentState.Empty
+	log.Printf("Marking %s as empty: %v", m.files[m.selectedIndex], m.currentState.Empty)
+	if m.currentState.Empty {
+		m.currentRegions.SaveAsync()
+	}
+	m.currentState.SaveAsync()
+	m.regionsChanged()
+}
+
+// isUnlabelled reports whether file index i still needs labelling, using the
+// scanned summary when there is one.
+func (m *appModel) isUnlabelled(i int) bool {
+	if s := m.summary(i); s.scanned {
+		return s.status == StatusUnlabelled
+	}
+	regions, err := LoadRegionList(m.backend, labelFileName(m.files[i]))
+	if err == nil && len(regions.Regions) > 0 {
+		return false
+	}
+	state, _ := LoadImageState(m.backend, stateFileName(m.files[i]))
+	return effectiveStatus(state, 0) == StatusUnlabelled
+}
+
+func (m *appModel) setReviewComment(comment string) {
+	if m.selectedIndex < 0 || m.selectedIndex >= len(m.files) || comment == m.currentState.Comment || m.labelsLocked() {
+		return
+	}
+	m.currentState.Comment = comment
+	m.currentState.SaveAsync()
+}
+
+// updateVisible rebuilds the list of files shown in the sidebar.
+func (m *appModel) updateVisible() {
+	m.visible = m.visible[:0]
+	m.metadataMu.Lock()
+	defer m.metadataMu.Unlock()
+	for i, file := range m.files {
+		var summary fileSummary
+		if i < len(m.summaries) {
+			summary = m.summaries[i]
+		}
+		// Unscanned files can't be matched against a status yet.
+		if m.statusFilter != statusCount && (!summary.scanned || summary.status != m.statusFilter) {
+			continue
+		}
+		if m.split != "" && m.layout.split(file) != m.split {
+			continue
+		}
+		if !m.fileFilter.matches(file, summary) {
+			continue
+		}
+		m.visible = append(m.visible, i)
+	}
+	sortFiles(m.visible, m.sortOrder, m.summaries)
+
+	m.visiblePos = slices.Grow(m.visiblePos[:0], len(m.files))[:len(m.files)]
+	for i := range m.visiblePos {
+		m.visiblePos[i] = -1
+	}
+	for pos, i := range m.visible {
+		m.visiblePos[i] = pos
+	}
+}
+
+// visibleStep returns the file index delta positions away from the selected
+// file within the visible list, or -1 if there isn't one. If the selected
+// file is filtered out, stepping forward starts from the top of the list.
+func (m *appModel) visibleStep(delta int) int {
+	pos := -1
+	if m.selectedIndex >= 0 && m.selectedIndex < len(m.visiblePos) {
+		pos = m.visiblePos[m.selectedIndex]
+	}
+	if pos < 0 && delta < 0 {
+		return -1
+	}
+	pos += delta
+	if pos < 0 || pos >= len(m.visible) {
+		return -1
+	}
+	return m.visible[pos]
+}
+
+// loadImage decodes an image, rotated by its EXIF orientation, which is also
+// returned.
+func loadImage(backend storage.Storage, filename string) (image.Image, int, error) {
+	return loadImageContext(context.Background(), backend, filename, nil)
 }
 
 // capImageSize downscales img so neither dimension exceeds maxDim.
@@ -272,61 +686,105 @@ func capImageSize(img image.Image, maxDim int) image.Image {
 	return dst
 }
 
-// loadFile starts an asynchronous decode of filename and synchronously loads
-// its regions. The decode result arrives on m.decoded and is applied in
+// loadFile shows filename's image straight away if it has been prefetched,
+// otherwise starts an asynchronous decode, and synchronously loads its
+// regions. The decode result arrives on m.decoded and is applied in
 // Root.Tick (ebiten.NewImageFromImage must run on the main goroutine).
 func (m *appModel) loadFile(filename string) {
 	m.currentImage = nil
 	m.displayImage = nil
 	m.imageGen++
-	m.loadGen++
-	gen := m.loadGen
-	backend := m.backend
-	autoContrast := m.autoContrast
-
-	go func() {
-		img, err := loadImage(backend, "images/"+filename)
-		if err != nil {
-			log.Printf("Error loading image %s: %s", filename, err)
-			return
-		}
-		display := img
-		if autoContrast {
-			display = autoContrastImage(img)
-		}
-		m.decoded <- decodedImage{gen: gen, source: img, display: display}
-	}()
-
-	ext := filepath.Ext(filename)
-	labelFile := filepath.Join("labels", strings.TrimSuffix(filename, ext)+".txt")
+	if e := m.images.get(filename, m.adjust); e != nil {
+		m.showImage(e)
+	} else {
+		m.decodeFile(filename, false)
+	}
 
 	var err error
-	m.currentRegions, err = LoadRegionList(m.backend, labelFile)
+	m.currentRegions, err = LoadRegionList(m.backend, labelFileName(filename))
 	if err != nil {
 		log.Printf("Error loading regions for %s: %s", filename, err)
 	}
+	// Copy, as edits are made in place and the cached list is shared.
+	m.currentRegions.Regions = slices.Clone(m.currentRegions.Regions)
+	m.regionHistory = [][]Region{slices.Clone(m.currentRegions.Regions)}
+	// Most images have no state sidecar, so a missing one isn't an error.
+	m.currentState, _ = LoadImageState(m.backend, stateFileName(filename))
+	m.loadSuggestions(filename)
+}
+
+// labelFileName returns the Darknet label file that holds an image's regions.
+func labelFileName(image string) string {
+	if base, ok := strings.CutPrefix(labelBase(image), "/"); ok {
+		return darknetLabelPath(base) + ".txt"
+	}
+	return filepath.Join("labels", labelBase(image)+".txt")
+}
+
+// imagePath returns where an image is in the dataset. Images are named
+// relative to images/, except those from Darknet image lists, which are
+// named from the dataset root with a leading /; their label and sidecar
+// files are where Darknet looks for them (see darknetLabelPath).
+func imagePath(image string) string {
+	if path, ok := strings.CutPrefix(image, "/"); ok {
+		return path
+	}
+	return filepath.Join("images", image)
+}
+
+// imageName is the inverse of imagePath: the name of the image at path in
+// the dataset.
+func imageName(path string) string {
+	path = filepath.ToSlash(filepath.Clean(path))
+	if name, ok := strings.CutPrefix(path, "images/"); ok {
+		return filepath.FromSlash(name)
+	}
+	return "/" + path
+}
+
+// labelBase is the name an image's label and sidecar files share: the image
+// name without its extension, or <video>-<frame> for a video frame.
+func labelBase(image string) string {
+	if video, frame, ok := parseVideoFrame(image); ok {
+		return frameBaseName(video, frame)
+	}
+	return strings.TrimSuffix(image, filepath.Ext(image))
 }
 
-// regenerateDisplayImage re-applies the auto-contrast setting to the cached
-// source image in the background.
+// regenerateDisplayImage re-applies the image adjustments to the cached
+// source image in the background. Prefetched images were made with the old
+// settings, so they are dropped.
 func (m *appModel) regenerateDisplayImage() {
+	m.cancelDecodes()
+	m.images.clear()
+	if m.selectedIndex < 0 || m.selectedIndex >= len(m.files) {
+		return
+	}
+	file := m.files[m.selectedIndex]
 	img := m.currentImage
 	if img == nil {
+		m.decodeFile(file, false)
 		return
 	}
-	gen := m.loadGen
-	autoContrast := m.autoContrast
+	adjust := m.adjust
 	go func() {
-		display := img
-		if autoContrast {
-			display = autoContrastImage(img)
-		}
-		m.decoded <- decodedImage{gen: gen, display: display}
+		display := adjustImage(img, adjust)
+		m.decoded <- decodedImage{file: file, adjust: adjust, source: img, display: display}
 	}()
 }
 
 func (m *appModel) getClosestRegion(click image.Point, imageWidth int, imageHeight int) int {
+	regions := make([]Region, len(m.currentRegions.Regions))
 	for i, region := range m.currentRegions.Regions {
+		regions[i] = m.displayRegion(m.currentFile(), region)
+	}
+	return closestRegion(regions, click, imageWidth, imageHeight)
+}
+
+// closestRegion returns the index of the displayed region that click, in a
+// displayed image of the given size, is on or just misses, or -1.
+func closestRegion(regions []Region, click image.Point, imageWidth int, imageHeight int) int {
+	for i, region := range regions {
 		w := int(float32(region.width) * float32(imageWidth))
 		h := int(float32(region.height) * float32(imageHeight))
 		x := int(float32(region.xMid)*float32(imageWidth)) - w/2
@@ -338,7 +796,7 @@ func (m *appModel) getClosestRegion(click image.Point, imageWidth int, imageHeig
 	}
 
 	// If we're close to a region, and it's small, then assume we just missed and select it
-	for i, region := range m.currentRegions.Regions {
+	for i, region := range regions {
 		w := int(float32(region.width) * float32(imageWidth))
 		h := int(float32(region.height) * float32(imageHeight))
 		x := int(float32(region.xMid)*float32(imageWidth)) - w/2
@@ -363,22 +821,46 @@ type Root struct {
 	statusText  basicwidget.Text
 	jumpLabel   basicwidget.Text
 	jumpInput   basicwidget.TextInput
+	showLabel   basicwidget.Text
+	showSelect  basicwidget.Select[ImageStatus]
+	splitSelect basicwidget.Select[string]
+	filterInput basicwidget.TextInput
+	sortSelect  basicwidget.Select[sortOrder]
+	viewSelect  basicwidget.SegmentedControl[viewMode]
 	fileList    basicwidget.List[int]
 	split       splitter
 	editorPanel basicwidget.Panel
 	pane        editorPane
+	grid        thumbnailGrid
+	gallery     cropGallery
+	classes     classManager
+	palette     classPalette
+	cheatsheet  cheatsheetOverlay
 
 	sidebarWidth   int
 	dragStartWidth int
 	contentWidth   int
 
-	builtFilesGen int
+	builtList listKey
+	listItems []basicwidget.ListItem[int]
 
 	rootItems    []guigui.LinearLayoutItem
 	jumpRowItems []guigui.LinearLayoutItem
+	showRowItems []guigui.LinearLayoutItem
+	filtRowItems []guigui.LinearLayoutItem
 	mainRowItems []guigui.LinearLayoutItem
 }
 
+// listKey identifies the inputs the sidebar was last built from. Statuses
+// only become known as the metadata scan runs, so the list is rebuilt once
+// more when it completes, and likewise when modification times are read.
+type listKey struct {
+	filesGen  int
+	viewGen   int
+	scanDone  bool
+	datesDone bool
+}
+
 // WriteStateKey exposes the state that can change outside input handlers
 // (decode results and directory changes applied in Tick, metadata updated by
 // scan workers) so the framework rebuilds when it changes.
@@ -388,20 +870,52 @@ func (r *Root) WriteStateKey(context *guigui.Context, w *guigui.StateKeyWriter)
 	w.WriteInt(m.filesGen)
 	w.WriteInt(len(m.files))
 	w.WriteUint64(m.imageGen)
+	w.WriteInt(m.viewGen)
+	w.WriteInt(int(m.view))
+	w.WriteInt(m.galleryClass)
 	w.WriteInt(m.drawingIndex)
-	w.WriteBool(m.autoContrast)
+	w.WriteBool(m.adjust.autoContrast)
+	w.WriteFloat64(m.adjust.clip)
+	w.WriteInt(m.adjust.brightness)
+	w.WriteInt(m.adjust.gamma)
+	w.WriteBool(m.adjust.clahe)
+	w.WriteInt(m.adjust.window)
+	w.WriteInt(m.adjust.level)
+	w.WriteBool(m.settings.OrientedLabels)
 	w.WriteInt(len(m.currentRegions.Regions))
+	w.WriteInt(int(m.currentState.Status))
+	w.WriteString(m.currentState.Comment)
+	w.WriteBool(m.currentState.Empty)
+	w.WriteInt(len(m.currentState.Tracks))
+	w.WriteInt(m.activeTrack)
+	w.WriteBool(m.carryRegions)
+	w.WriteBool(m.follow != nil)
+	w.WriteInt(len(m.proposals))
+	w.WriteInt(len(m.suggestions))
+	w.WriteInt(m.suggestThreshold)
+	w.WriteBool(m.comparing)
+	w.WriteInt(m.compareIoU)
+	w.WriteInt(len(m.currentState.Rejected))
+	w.WriteString(m.settings.PredictionsDir)
+	w.WriteString(m.modelStatus)
+	w.WriteString(m.splitStatus)
+	w.WriteString(m.remapStatus)
 	if m.backend != nil {
 		w.WriteString(m.backend.Describe())
 	}
 	meta := m.metadataSnapshot()
 	w.WriteInt(meta.Total)
 	w.WriteInt(meta.Scanned)
+	w.WriteBool(meta.Dated >= meta.Total)
 	w.WriteInt(meta.Categorised)
 	w.WriteInt(meta.TotalRegions)
 	for _, c := range meta.CategoryTotals {
 		w.WriteInt(c)
 	}
+	w.WriteInt(meta.Negatives)
+	for _, c := range meta.StatusTotals {
+		w.WriteInt(c)
+	}
 }
 
 func (r *Root) Build(context *guigui.Context, adder *guigui.ChildAdder) error {
@@ -409,9 +923,19 @@ func (r *Root) Build(context *guigui.Context, adder *guigui.ChildAdder) error {
 	adder.AddWidget(&r.statusText)
 	adder.AddWidget(&r.jumpLabel)
 	adder.AddWidget(&r.jumpInput)
+	adder.AddWidget(&r.showLabel)
+	adder.AddWidget(&r.showSelect)
+	if len(r.model.layout.splits) > 0 {
+		adder.AddWidget(&r.splitSelect)
+	}
+	adder.AddWidget(&r.viewSelect)
+	adder.AddWidget(&r.filterInput)
+	adder.AddWidget(&r.sortSelect)
 	adder.AddWidget(&r.fileList)
 	adder.AddWidget(&r.split)
-	adder.AddWidget(&r.editorPanel)
+	adder.AddWidget(r.mainWidget())
+	adder.AddWidget(&r.palette)
+	adder.AddWidget(&r.cheatsheet)
 
 	m := &r.model
 	context.SetButtonInputReceptive(r, true)
@@ -426,13 +950,79 @@ func (r *Root) Build(context *guigui.Context, adder *guigui.ChildAdder) error {
 		r.jumpTo()
 	})
 
-	if r.builtFilesGen != m.filesGen {
-		r.fileList.SetItemsByStrings(m.files)
-		r.builtFilesGen = m.filesGen
+	r.showLabel.SetValue("Show")
+	r.showLabel.SetVerticalAlign(basicwidget.VerticalAlignMiddle)
+	showItems := []basicwidget.SelectItem[ImageStatus]{{Text: "All", Value: statusCount}}
+	for s := range statusCount {
+		showItems = append(showItems, basicwidget.SelectItem[ImageStatus]{Text: s.String(), Value: s})
 	}
+	r.showSelect.SetItems(showItems)
+	r.showSelect.SelectItemByValue(m.statusFilter)
+	r.showSelect.OnItemSelected(func(context *guigui.Context, index int) {
+		if item, ok := r.showSelect.ItemByIndex(index); ok && item.Value != m.statusFilter {
+			m.statusFilter = item.Value
+			m.viewGen++
+		}
+	})
+
+	splitItems := []basicwidget.SelectItem[string]{{Text: "All splits", Value: ""}}
+	for _, s := range m.layout.splits {
+		splitItems = append(splitItems, basicwidget.SelectItem[string]{Text: s.name, Value: s.name})
+	}
+	r.splitSelect.SetItems(splitItems)
+	r.splitSelect.SelectItemByValue(m.split)
+	r.splitSelect.OnItemSelected(func(context *guigui.Context, index int) {
+		if item, ok := r.splitSelect.ItemByIndex(index); ok && item.Value != m.split {
+			m.split = item.Value
+			m.viewGen++
+		}
+	})
+
+	r.filterInput.SetPlaceholder("Filter, e.g. class:car regions>2 -tiny")
+	r.filterInput.OnValueChanged(func(context *guigui.Context, text string, committed bool) {
+		filter, err := parseFilter(text, m.labels)
+		r.filterInput.SetError(err != nil)
+		if err != nil {
+			r.filterInput.SetSupportText(err.Error())
+			return
+		}
+		r.filterInput.SetSupportText("")
+		m.fileFilter = filter
+		m.viewGen++
+	})
+	r.sortSelect.SetItems([]basicwidget.SelectItem[sortOrder]{
+		{Text: "Name", Value: sortName},
+		{Text: "Modified", Value: sortModified},
+		{Text: "Regions", Value: sortRegions},
+		{Text: "Class", Value: sortClass},
+		{Text: "Uncertainty", Value: sortUncertainty},
+	})
+	r.sortSelect.SelectItemByValue(m.sortOrder)
+	r.sortSelect.OnItemSelected(func(context *guigui.Context, index int) {
+		if item, ok := r.sortSelect.ItemByIndex(index); ok && item.Value != m.sortOrder {
+			m.sortOrder = item.Value
+			m.viewGen++
+		}
+	})
+
+	r.viewSelect.SetItems([]basicwidget.SegmentedControlItem[viewMode]{
+		{Text: "Editor", Value: viewEditor},
+		{Text: "Grid", Value: viewGrid},
+		{Text: "Gallery", Value: viewGallery},
+		{Text: "Classes", Value: viewClasses},
+	})
+	r.viewSelect.SelectItemByValue(m.view)
+	r.viewSelect.OnItemSelected(func(context *guigui.Context, index int) {
+		if item, ok := r.viewSelect.ItemByIndex(index); ok {
+			r.setView(item.Value)
+		}
+	})
+
+	r.buildFileList()
 	r.fileList.OnItemSelected(func(context *guigui.Context, index int) {
-		if index != m.selectedIndex {
-			r.selectFile(index)
+		item, ok := r.fileList.ItemByIndex(index)
+		if ok && item.Value != m.selectedIndex {
+			r.selectFile(item.Value)
 		}
 	})
 
@@ -453,9 +1043,74 @@ func (r *Root) Build(context *guigui.Context, adder *guigui.ChildAdder) error {
 	r.editorPanel.SetContentConstraints(basicwidget.PanelContentConstraintsFixedWidth)
 	r.pane.SetModel(m)
 
+	r.grid.SetModel(m)
+	r.grid.OnSelect(func(index int) {
+		r.selectFile(index)
+		r.setView(viewEditor)
+	})
+
+	r.gallery.SetModel(m)
+	r.gallery.OnOpen(func(index int) {
+		r.selectFile(index)
+		r.setView(viewEditor)
+	})
+
+	r.classes.SetModel(m)
+	r.palette.SetModel(m)
+	r.cheatsheet.SetModel(m)
+
 	return nil
 }
 
+// mainWidget returns the widget shown to the right of the file list.
+func (r *Root) mainWidget() guigui.Widget {
+	switch r.model.view {
+	case viewGrid:
+		return &r.grid
+	case viewGallery:
+		return &r.gallery
+	case viewClasses:
+		return &r.classes
+	default:
+		return &r.editorPanel
+	}
+}
+
+func (r *Root) setView(view viewMode) {
+	m := &r.model
+	m.view = view
+	if view == viewGrid {
+		r.grid.ensureVisible(m.selectedIndex)
+	}
+}
+
+// buildFileList refreshes the sidebar items from m.visible when the files,
+// filter or scanned statuses have changed.
+func (r *Root) buildFileList() {
+	m := &r.model
+	meta := m.metadataSnapshot()
+	key := listKey{filesGen: m.filesGen, viewGen: m.viewGen, scanDone: meta.Scanned >= meta.Total, datesDone: meta.Dated >= meta.Total}
+	if key == r.builtList {
+		return
+	}
+	r.builtList = key
+	if m.fileFilter.needsDates() || m.sortOrder == sortModified {
+		m.readModifiedTimes()
+	}
+
+	m.updateVisible()
+	r.listItems = slices.Delete(r.listItems, 0, len(r.listItems))
+	for _, i := range m.visible {
+		r.listItems = append(r.listItems, basicwidget.ListItem[int]{
+			Text:      m.files[i],
+			TextStyle: basicwidget.TextStyle{Color: m.summary(i).status.Color()},
+			Value:     i,
+		})
+	}
+	r.fileList.SetItems(r.listItems)
+	r.fileList.SelectItemByValue(m.selectedIndex)
+}
+
 func (r *Root) Layout(context *guigui.Context, widgetBounds *guigui.WidgetBounds, layouter *guigui.ChildLayouter) {
 	u := basicwidget.UnitSize(context)
 
@@ -475,11 +1130,40 @@ func (r *Root) Layout(context *guigui.Context, widgetBounds *guigui.WidgetBounds
 		Gap:       u / 4,
 	}
 
+	r.showRowItems = slices.Delete(r.showRowItems, 0, len(r.showRowItems))
+	r.showRowItems = append(r.showRowItems,
+		guigui.LinearLayoutItem{Widget: &r.showLabel},
+		guigui.LinearLayoutItem{Widget: &r.showSelect},
+	)
+	if len(r.model.layout.splits) > 0 {
+		r.showRowItems = append(r.showRowItems, guigui.LinearLayoutItem{Widget: &r.splitSelect})
+	}
+	r.showRowItems = append(r.showRowItems,
+		guigui.LinearLayoutItem{Size: guigui.FlexibleSize(1)},
+		guigui.LinearLayoutItem{Widget: &r.viewSelect},
+	)
+	showRow := guigui.LinearLayout{
+		Direction: guigui.LayoutDirectionHorizontal,
+		Items:     r.showRowItems,
+		Gap:       u / 4,
+	}
+
+	r.filtRowItems = slices.Delete(r.filtRowItems, 0, len(r.filtRowItems))
+	r.filtRowItems = append(r.filtRowItems,
+		guigui.LinearLayoutItem{Widget: &r.filterInput, Size: guigui.FlexibleSize(1)},
+		guigui.LinearLayoutItem{Widget: &r.sortSelect},
+	)
+	filterRow := guigui.LinearLayout{
+		Direction: guigui.LayoutDirectionHorizontal,
+		Items:     r.filtRowItems,
+		Gap:       u / 4,
+	}
+
 	r.mainRowItems = slices.Delete(r.mainRowItems, 0, len(r.mainRowItems))
 	r.mainRowItems = append(r.mainRowItems,
 		guigui.LinearLayoutItem{Widget: &r.fileList, Size: guigui.FixedSize(r.sidebarWidth)},
 		guigui.LinearLayoutItem{Widget: &r.split, Size: guigui.FixedSize(u / 3)},
-		guigui.LinearLayoutItem{Widget: &r.editorPanel, Size: guigui.FlexibleSize(1)},
+		guigui.LinearLayoutItem{Widget: r.mainWidget(), Size: guigui.FlexibleSize(1)},
 	)
 	mainRow := guigui.LinearLayout{
 		Direction: guigui.LayoutDirectionHorizontal,
@@ -490,10 +1174,14 @@ func (r *Root) Layout(context *guigui.Context, widgetBounds *guigui.WidgetBounds
 	r.rootItems = append(r.rootItems,
 		guigui.LinearLayoutItem{Widget: &r.statusText},
 		guigui.LinearLayoutItem{Layout: &jumpRow},
+		guigui.LinearLayoutItem{Layout: &showRow},
+		guigui.LinearLayoutItem{Layout: &filterRow},
 		guigui.LinearLayoutItem{Layout: &mainRow, Size: guigui.FlexibleSize(1)},
 	)
 
 	layouter.LayoutWidget(&r.background, widgetBounds.Bounds())
+	layouter.LayoutWidget(&r.palette, widgetBounds.Bounds())
+	layouter.LayoutWidget(&r.cheatsheet, widgetBounds.Bounds())
 	(guigui.LinearLayout{
 		Direction: guigui.LayoutDirectionVertical,
 		Items:     r.rootItems,
@@ -508,23 +1196,58 @@ func (r *Root) Tick(context *guigui.Context, widgetBounds *guigui.WidgetBounds)
 	for {
 		select {
 		case d := <-m.decoded:
-			if d.gen != m.loadGen {
-				continue // stale result from a file we've navigated away from
+			m.imageDecoded(d)
+		case p := <-m.proposed:
+			m.proposalsFound(p)
+		case p := <-m.modelResults:
+			m.modelRan(p)
+		case res := <-m.splitResults:
+			m.splitDone(res)
+			// Even a failed move may have moved some files.
+			if res.moved {
+				r.reloadFiles(m.backend)
+			}
+		case res := <-m.remapResults:
+			m.remapDone(res)
+			// Even a failed remap may have replaced some files.
+			if !res.dryRun {
+				r.reloadFiles(m.backend)
 			}
-			if d.source != nil {
-				m.currentImage = d.source
+		case res := <-m.orientResults:
+			m.reorientDone(res)
+			// Even a failed conversion may have replaced some files.
+			r.reloadFiles(m.backend)
+		case res := <-m.trackResults:
+			m.trackDone(res)
+		case backend := <-m.videosProbed:
+			m.probing = false
+			if backend == m.backend {
+				r.updateFiles(m.currentFile())
 			}
-			m.displayImage = ebiten.NewImageFromImage(d.display)
-			m.imageGen++
 		case dir := <-m.chosenDirs:
-			m.backend = storage.NewStorage(dir)
-			r.updateFiles()
+			r.reloadFiles(storage.NewStorage(dir))
 		default:
+			m.updateFollowing()
 			return nil
 		}
 	}
 }
 
+// reloadFiles reads the file list afresh from backend, dropping everything
+// cached from the old one.
+func (r *Root) reloadFiles(backend storage.Storage) {
+	m := &r.model
+	m.cancelDecodes()
+	m.stopModel()
+	m.images.clear()
+	clear(m.tooLarge)
+	m.backend = backend
+	m.thumbnails.reset(m.backend)
+	m.crops.reset(m.backend)
+	m.cropSources.reset()
+	r.updateFiles("")
+}
+
 // keyRepeating reports whether key was just pressed or is being held long
 // enough to auto-repeat, matching basicwidget's repeat timing.
 func keyRepeating(key ebiten.Key) bool {
@@ -543,57 +1266,39 @@ func keyRepeating(key ebiten.Key) bool {
 }
 
 func (r *Root) HandleButtonInput(context *guigui.Context, widgetBounds *guigui.WidgetBounds) guigui.HandleInputResult {
-	// Don't treat typing in the jump-to filter as navigation.
-	if context.IsFocusedOrHasFocusedDescendant(&r.jumpInput) {
+	// Don't treat typing in the jump-to or filter inputs or a comment as
+	// navigation.
+	if context.IsFocusedOrHasFocusedDescendant(&r.jumpInput) || context.IsFocusedOrHasFocusedDescendant(&r.filterInput) ||
+		context.IsFocusedOrHasFocusedDescendant(&r.pane.commentInput) || context.IsFocusedOrHasFocusedDescendant(&r.pane.predictionsInput) ||
+		context.IsFocusedOrHasFocusedDescendant(&r.pane.splitInput) || context.IsFocusedOrHasFocusedDescendant(&r.pane.remapInput) ||
+		r.classes.editing(context) || r.palette.IsOpen() {
 		return guigui.HandleInputResult{}
 	}
 
 	m := &r.model
 
-	if keyRepeating(ebiten.KeyDown) || keyRepeating(ebiten.KeyJ) {
-		r.selectFile(m.selectedIndex + 1)
-		return guigui.HandleInputByWidget(r)
-	}
-	if keyRepeating(ebiten.KeyUp) || keyRepeating(ebiten.KeyK) {
-		r.selectFile(m.selectedIndex - 1)
+	if r.cheatsheet.IsOpen() && inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+		r.cheatsheet.SetOpen(false)
 		return guigui.HandleInputByWidget(r)
 	}
-	if keyRepeating(ebiten.KeyLeft) || keyRepeating(ebiten.KeyH) {
-		if m.drawingIndex > 0 {
-			m.drawingIndex--
+	for _, a := range keyActions {
+		if a.navigation && m.keymap.pressed(a) && r.runAction(context, a.name) {
+			return guigui.HandleInputByWidget(r)
 		}
-		return guigui.HandleInputByWidget(r)
 	}
-	if keyRepeating(ebiten.KeyRight) || keyRepeating(ebiten.KeyL) {
-		if m.drawingIndex < len(m.labels)-1 {
-			m.drawingIndex++
-		}
+	// Class hotkeys come before the remaining shortcuts, so they can take
+	// over their keys.
+	if i := m.pressedClass(inpututil.IsKeyJustPressed, nil); i >= 0 && i < len(m.labels) {
+		m.drawingIndex = i
 		return guigui.HandleInputByWidget(r)
 	}
-	for i := range 10 {
-		if inpututil.IsKeyJustPressed(ebiten.KeyDigit0+ebiten.Key(i)) && i < len(m.labels) {
-			m.drawingIndex = i
-			return guigui.HandleInputByWidget(r)
+	for _, a := range keyActions {
+		if a.edits && m.labelsLocked() {
+			continue
 		}
-	}
-	if keyRepeating(ebiten.KeyN) {
-		direction := 1
-		if ebiten.IsKeyPressed(ebiten.KeyShiftLeft) || ebiten.IsKeyPressed(ebiten.KeyShiftRight) {
-			direction = -1
-		}
-		// Find the next image that's not labeled
-		for i := m.selectedIndex + direction; i < len(m.files) && i >= 0; i += direction {
-			filename := m.files[i]
-			ext := filepath.Ext(filename)
-			labelFile := filepath.Join("labels", strings.TrimSuffix(filename, ext)+".txt")
-			regions, err := LoadRegionList(m.backend, labelFile)
-			if err != nil || len(regions.Regions) == 0 {
-				log.Printf("Found unlabeled image %s", filename)
-				r.selectFile(i)
-				break
-			}
+		if !a.navigation && m.keymap.pressed(a) && r.runAction(context, a.name) {
+			return guigui.HandleInputByWidget(r)
 		}
-		return guigui.HandleInputByWidget(r)
 	}
 
 	return guigui.HandleInputResult{}
@@ -605,13 +1310,25 @@ func (r *Root) selectFile(i int) {
 		log.Printf("Invalid file index %d (max %d)", i, len(m.files))
 		return
 	}
+	direction := 1
+	if i < m.selectedIndex {
+		direction = -1
+	}
+	from, fromRegions := m.currentFile(), m.currentRegions.Regions
 	m.selectedIndex = i
 	r.pane.editor.cancelDrawing()
 	// Set the model index before syncing the list so the OnItemSelected
 	// callback this triggers sees an up-to-date model and doesn't recurse.
-	r.fileList.SelectItemByIndex(i)
-	r.fileList.EnsureItemVisibleByIndex(i)
+	r.fileList.SelectItemByValue(i)
+	if index := r.fileList.IndexByValue(i); index >= 0 {
+		r.fileList.EnsureItemVisibleByIndex(index)
+	}
+	if m.view == viewGrid {
+		r.grid.ensureVisible(i)
+	}
 	m.loadFile(m.files[i])
+	m.carryForward(from, fromRegions)
+	m.prefetchAround(direction)
 }
 
 // jumpTo selects the first file that contains the filter value as a
@@ -623,8 +1340,8 @@ func (r *Root) jumpTo() {
 		return
 	}
 	needle := strings.ToLower(m.filter)
-	for i, f := range m.files {
-		if strings.Contains(strings.ToLower(f), needle) {
+	for _, i := range m.visible {
+		if strings.Contains(strings.ToLower(m.files[i]), needle) {
 			r.selectFile(i)
 			return
 		}
@@ -646,43 +1363,178 @@ func (m *appModel) selectDirectory() {
 	}()
 }
 
-func (r *Root) updateFiles() {
+// loadLayout reads the dataset's layout, falling back to the plain layout if
+// it can't be read, so the images can still be labelled, if not by split.
+func (m *appModel) loadLayout() {
+	var err error
+	if m.layout, err = loadDatasetLayout(m.backend); err != nil {
+		log.Printf("Error loading %s: %s; using the plain layout", m.layout.dataFile, err)
+		m.layout = datasetLayout{}
+	}
+}
+
+// updateFiles lists the files afresh and selects selected, or the first
+// file if it isn't listed. Videos that haven't been probed are left out
+// until probeVideos has probed them.
+func (r *Root) updateFiles(selected string) {
 	m := &r.model
 	m.files = nil
 
-	match, err := m.backend.Glob("images", "*")
-	if err != nil {
+	// Everything below works from this one read of the layout, made again
+	// if keeping the image lists up to date changed them.
+	m.loadLayout()
+	if changed, err := syncImageLists(m.backend, m.layout); err != nil {
+		log.Printf("Error updating image lists: %s", err)
+	} else if changed {
+		m.loadLayout()
+	}
+	if files, unprobed, err := listLayoutImages(m.backend, m.layout, false); err != nil {
 		log.Printf("Error listing files: %s", err)
 	} else {
-		for _, f := range match {
-			ext := strings.ToLower(filepath.Ext(f))
-			if ext == ".jpg" || ext == ".jpeg" || ext == ".png" {
-				m.files = append(m.files, filepath.Base(f))
-			}
+		m.files = files
+		if len(unprobed) > 0 {
+			m.probeVideos(unprobed)
 		}
 	}
-	slices.Sort(m.files)
 
-	file, err := m.backend.Open("labels.txt")
-	if err != nil {
+	var err error
+	if m.settings, err = LoadDatasetSettings(m.backend); err != nil {
+		log.Printf("Error loading dataset settings: %s", err)
+	}
+	m.orientations.reset()
+
+	if !slices.ContainsFunc(m.layout.splits, func(s datasetSplit) bool { return s.name == m.split }) {
+		m.split = ""
+	}
+
+	if labels, err := layoutClassNames(m.backend, m.layout); err != nil {
 		log.Printf("Error opening labels file: %s", err)
 	} else {
-		defer file.Close()
-		scanner := bufio.NewScanner(file)
-		m.labels = nil
-		for scanner.Scan() {
-			m.labels = append(m.labels, scanner.Text())
-		}
+		m.labels = labels
 	}
 
 	m.filesGen++
+	// A preview of another file list doesn't show where these would go.
+	m.splitPreviewed = nil
 	m.startMetadataScan()
-	r.selectFile(0)
+	m.updateVisible()
+	r.selectFile(max(slices.Index(m.files, selected), 0))
 }
 
 func main() {
 	directory := flag.String("directory", "", "Directory to load images from")
+	exportFrames := flag.Bool("export-frames", false, "Export the labelled frames of the videos in -directory as images, then exit")
+	model := flag.String("model", "", "Detector to pre-label images with: a URL to POST images to, or a command to pipe them through (see README)")
+	report := flag.Bool("report", false, "Print the precision, recall and mAP of the predictions against the labels in -directory, then exit")
+	reportThreshold := flag.Float64("report-threshold", defaultSuggestThreshold/100.0, "Confidence the -report precision and recall are measured at")
+	split := flag.String("split", "", "Split the labelled images in -directory into train/val/test sets, e.g. \"80/10/10 stratify group=sequence seed=1\" (see README), then exit")
+	remap := flag.String("remap", "", "Rewrite the classes of -directory to this new class list, e.g. \"car=car,truck; person=pedestrian; bike\" (see README), then exit")
+	dryRun := flag.Bool("dry-run", false, "With -remap, only print what would change")
+	keymapPath := flag.String("keymap", "", "Keymap file binding keys to actions (default "+keymapFile+" in the user configuration directory)")
+	printKeymap := flag.Bool("print-keymap", false, "Print the keymap in the keymap file format, then exit")
+	syncLists := flag.Bool("sync-lists", false, "Remove images that have gone from the Darknet image lists of -directory, and add new images alongside those listed to the first list, then exit")
+	convertOrientation := flag.String("convert-orientation", "", "Convert the labels in -directory to be relative to the 'exif' oriented or 'raw' stored images, then exit")
 	flag.Parse()
+	defer removeVideoCopies()
+
+	keys, err := loadKeymap(*keymapPath)
+	if err != nil {
+		log.Fatalf("Error loading keymap: %s", err)
+	}
+	if *printKeymap {
+		if err := writeKeymap(os.Stdout, keys); err != nil {
+			log.Fatalf("Error writing keymap: %s", err)
+		}
+		return
+	}
+	if *exportFrames {
+		if *directory == "" {
+			log.Fatalf("-export-frames needs -directory")
+		}
+		if err := exportLabelledFrames(storage.NewStorage(*directory)); err != nil {
+			log.Fatalf("Error exporting frames: %s", err)
+		}
+		return
+	}
+	if *report {
+		if *directory == "" {
+			log.Fatalf("-report needs -directory")
+		}
+		if err := writeEvaluationReport(os.Stdout, storage.NewStorage(*directory), *reportThreshold); err != nil {
+			log.Fatalf("Error evaluating predictions: %s", err)
+		}
+		return
+	}
+	if *split != "" {
+		if *directory == "" {
+			log.Fatalf("-split needs -directory")
+		}
+		spec, err := parseSplitSpec(*split)
+		if err != nil {
+			log.Fatalf("Invalid -split: %s", err)
+		}
+		backend := storage.NewStorage(*directory)
+		items, err := datasetSplitItems(backend)
+		if err != nil {
+			log.Fatalf("Error reading labels: %s", err)
+		}
+		labels, _ := loadClassNames(backend)
+		assignment, err := splitDataset(backend, items, spec, labels)
+		if err != nil {
+			log.Fatalf("Error splitting dataset: %s", err)
+		}
+		fmt.Print(splitReport(items, assignment, labels))
+		return
+	}
+	if *remap != "" {
+		if *directory == "" {
+			log.Fatalf("-remap needs -directory")
+		}
+		backend := storage.NewStorage(*directory)
+		old, err := loadClassNames(backend)
+		if err != nil {
+			log.Fatalf("Error reading class names: %s", err)
+		}
+		classes, err := parseClassRemap(*remap, old)
+		if err != nil {
+			log.Fatalf("Invalid -remap: %s", err)
+		}
+		summary, err := remapClasses(backend, classes, *dryRun)
+		if err != nil {
+			log.Fatalf("Error remapping classes: %s", err)
+		}
+		fmt.Print(summary.report(classes, old))
+		if *dryRun {
+			fmt.Println("Would rewrite:")
+			for _, filename := range summary.changed {
+				fmt.Println("  " + filename)
+			}
+		}
+		return
+	}
+	if *syncLists {
+		if *directory == "" {
+			log.Fatalf("-sync-lists needs -directory")
+		}
+		backend := storage.NewStorage(*directory)
+		layout, err := loadDatasetLayout(backend)
+		if err != nil {
+			log.Fatalf("Error loading %s: %s", layout.dataFile, err)
+		}
+		if _, err := syncImageLists(backend, layout); err != nil {
+			log.Fatalf("Error updating image lists: %s", err)
+		}
+		return
+	}
+	if *convertOrientation != "" {
+		if *directory == "" || (*convertOrientation != "exif" && *convertOrientation != "raw") {
+			log.Fatalf("-convert-orientation needs -directory, and either exif or raw")
+		}
+		if err := convertLabelOrientation(storage.NewStorage(*directory), *convertOrientation == "exif"); err != nil {
+			log.Fatalf("Error converting labels: %s", err)
+		}
+		return
+	}
 
 	if err := RegionsInit(); err != nil {
 		log.Printf("Error loading regions: %s", err)
@@ -695,12 +1547,31 @@ func main() {
 	root := &Root{}
 	m := &root.model
 	m.decoded = make(chan decodedImage, 8)
+	m.proposed = make(chan proposedRegions, 1)
+	m.modelResults = make(chan modelProgress, 16)
+	m.splitResults = make(chan splitResult, 1)
+	m.remapResults = make(chan remapResult, 1)
+	m.orientResults = make(chan orientResult, 1)
+	m.trackResults = make(chan trackResult, 1)
+	m.videosProbed = make(chan storage.Storage, 1)
+	m.keymap = keys
+	if *model != "" {
+		m.model = newModelRunner(*model)
+	}
+	m.decoding = map[string]decodeJob{}
+	m.tooLarge = map[string]bool{}
 	m.chosenDirs = make(chan string, 1)
+	m.statusFilter = statusCount
+	m.adjust = defaultAdjust
+	m.suggestThreshold = defaultSuggestThreshold
+	m.compareIoU = defaultCompareIoU
 	if *directory != "" {
 		m.backend = storage.NewStorage(*directory)
 	} else {
 		m.backend = &storage.DummyStorage{}
 	}
+	m.thumbnails.start(m.backend)
+	m.crops.start(m.backend)
 
 	if icon, _, err := image.Decode(bytes.NewReader(iconData)); err == nil {
 		ebiten.SetWindowIcon([]image.Image{icon})
@@ -708,7 +1579,7 @@ func main() {
 		log.Printf("Error setting icon: %s", err)
 	}
 
-	root.updateFiles()
+	root.updateFiles("")
 
 	if err := guigui.Run(root, &guigui.RunOptions{
 		Title:      "Fast Mark Image Tagging",
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultTinySize is the fraction of the image below which a box's width or
+// height counts as tiny when the "tiny" filter is given without a size.
+const defaultTinySize = 0.01
+
+// fileFilter is a parsed sidebar filter. It is a space separated list of
+// terms which must all match; prefixing a term with '-' negates it:
+//
+//	class:car class:3   has a region of the named or numbered class
+//	status:approved     has the given review status
+//	nolabels            has no regions
+//	negative            is a verified negative
+//	regions>3           region count comparison (>, >=, <, <=, =)
+//	tiny tiny<0.005     has a box narrower or shorter than the fraction
+//	modified>2026-01-31 label file modified after (or before, <, or on, =) a
+//	                    day, in local time
+//	predicted           has a predictions file
+//	uncertain>0.5       prediction uncertainty comparison, from 0 to 1
+//	errors errors>2     has predictions that don't match its regions
+//	anything else       file name contains the text
+//
+// Terms other than file name matches need the metadata scan, so files that
+// haven't been scanned yet don't match them. Modification times are only
+// read once a filter or sort needs them, and files not yet dated don't
+// match modified terms.
+type fileFilter struct {
+	terms []filterTerm
+}
+
+type filterTerm struct {
+	negate      bool
+	needsScan   bool
+	needsDate   bool
+	matchesFile func(name string, s fileSummary) bool
+}
+
+func (f fileFilter) matches(name string, s fileSummary) bool {
+	for _, term := range f.terms {
+		if term.needsScan && !s.scanned || term.needsDate && !s.dated {
+			return false
+		}
+		if term.matchesFile(name, s) == term.negate {
+			return false
+		}
+	}
+	return true
+}
+
+// needsDates reports whether the filter matches on modification times.
+func (f fileFilter) needsDates() bool {
+	return slices.ContainsFunc(f.terms, func(t filterTerm) bool { return t.needsDate })
+}
+
+func parseFilter(expr string, labels []string) (fileFilter, error) {
+	var f fileFilter
+	for _, word := range strings.Fields(expr) {
+		term, err := parseFilterTerm(strings.ToLower(word), labels)
+		if err != nil {
+			return fileFilter{}, err
+		}
+		f.terms = append(f.terms, term)
+	}
+	return f, nil
+}
+
+func parseFilterTerm(word string, labels []string) (filterTerm, error) {
+	term := filterTerm{needsScan: true}
+	if rest, ok := strings.CutPrefix(word, "-"); ok && rest != "" {
+		term.negate = true
+		word = rest
+	}
+
+	if key, value, ok := strings.Cut(word, ":"); ok {
+		switch key {
+		case "class":
+			class, err := parseClass(value, labels)
+			if err != nil {
+				return term, err
+			}
+			term.matchesFile = func(name string, s fileSummary) bool {
+				return slices.ContainsFunc(s.regions, func(r Region) bool { return r.index == class })
+			}
+			return term, nil
+		case "status":
+			status, ok := parseImageStatus(value)
+			if !ok {
+				return term, fmt.Errorf("unknown status %q", value)
+			}
+			term.matchesFile = func(name string, s fileSummary) bool {
+				return s.status == status
+			}
+			return term, nil
+		}
+		return term, fmt.Errorf("unknown filter %q", key)
+	}
+
+	switch word {
+	case "nolabels":
+		term.matchesFile = func(name string, s fileSummary) bool {
+			return len(s.regions) == 0
+		}
+		return term, nil
+	case "negative":
+		term.matchesFile = func(name string, s fileSummary) bool {
+			return s.negative
+		}
+		return term, nil
+	case "tiny":
+		term.matchesFile = tinyMatcher(defaultTinySize)
+		return term, nil
+	case "predicted":
+		term.matchesFile = func(name string, s fileSummary) bool {
+			return s.predicted
+		}
+		return term, nil
+	case "errors":
+		term.matchesFile = func(name string, s fileSummary) bool {
+			return s.predicted && s.errors > 0
+		}
+		return term, nil
+	}
+
+	if key, op, value, ok := cutComparison(word); ok {
+		switch key {
+		case "regions":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return term, fmt.Errorf("invalid region count %q", value)
+			}
+			term.matchesFile = func(name string, s fileSummary) bool {
+				return compare(len(s.regions), op, n)
+			}
+			return term, nil
+		case "tiny":
+			size, err := strconv.ParseFloat(value, 64)
+			if err != nil || op != "<" {
+				return term, fmt.Errorf("tiny takes a size, e.g. tiny<0.01")
+			}
+			term.matchesFile = tinyMatcher(size)
+			return term, nil
+		case "uncertain":
+			u, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return term, fmt.Errorf("invalid uncertainty %q", value)
+			}
+			term.matchesFile = func(name string, s fileSummary) bool {
+				return s.predicted && compare(s.uncertainty, op, u)
+			}
+			return term, nil
+		case "errors":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return term, fmt.Errorf("invalid error count %q", value)
+			}
+			term.matchesFile = func(name string, s fileSummary) bool {
+				return s.predicted && compare(s.errors, op, n)
+			}
+			return term, nil
+		case "modified":
+			date, err := time.ParseInLocation(time.DateOnly, value, time.Local)
+			if err != nil {
+				return term, fmt.Errorf("invalid date %q, expected YYYY-MM-DD", value)
+			}
+			term.needsDate = true
+			term.matchesFile = func(name string, s fileSummary) bool {
+				if s.modified.IsZero() {
+					return false
+				}
+				y, m, d := s.modified.In(time.Local).Date()
+				day := time.Date(y, m, d, 0, 0, 0, 0, time.Local)
+				return compare(day.Compare(date), op, 0)
+			}
+			return term, nil
+		}
+		return term, fmt.Errorf("unknown filter %q", key)
+	}
+
+	term.needsScan = false
+	term.matchesFile = func(name string, s fileSummary) bool {
+		return strings.Contains(strings.ToLower(name), word)
+	}
+	return term, nil
+}
+
+// parseClass accepts a class name from labels.txt or a class number.
+func parseClass(value string, labels []string) (int, error) {
+	for i, label := range labels {
+		if strings.EqualFold(label, value) {
+			return i, nil
+		}
+	}
+	if class, err := strconv.Atoi(value); err == nil {
+		return class, nil
+	}
+	return 0, fmt.Errorf("unknown class %q", value)
+}
+
+func tinyMatcher(size float64) func(name string, s fileSummary) bool {
+	return func(name string, s fileSummary) bool {
+		return slices.ContainsFunc(s.regions, func(r Region) bool {
+			return r.width < size || r.height < size
+		})
+	}
+}
+
+// cutComparison splits "key>=value" style words.
+func cutComparison(word string) (key, op, value string, ok bool) {
+	i := strings.IndexAny(word, "<>=")
+	if i <= 0 {
+		return "", "", "", false
+	}
+	key, rest := word[:i], word[i:]
+	for _, op := range []string{">=", "<=", ">", "<", "="} {
+		if value, ok := strings.CutPrefix(rest, op); ok && value != "" {
+			return key, op, value, true
+		}
+	}
+	return "", "", "", false
+}
+
+func compare[T cmp.Ordered](a T, op string, b T) bool {
+	switch op {
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	default:
+		return a == b
+	}
+}
+
+// sortOrder is how the sidebar orders the visible files.
+type sortOrder int
+
+const (
+	sortName sortOrder = iota
+	sortModified
+	sortRegions
+	sortClass
+	sortUncertainty
+)
+
+// sortFiles orders file indices by the given order, falling back to name
+// order (which is the index order, as files is sorted) for ties.
+func sortFiles(indices []int, order sortOrder, summaries []fileSummary) {
+	summary := func(i int) fileSummary {
+		if i < len(summaries) {
+			return summaries[i]
+		}
+		return fileSummary{}
+	}
+	// lowestClass orders files by the smallest class they contain, with
+	// unlabelled files last.
+	lowestClass := func(s fileSummary) int {
+		lowest := math.MaxInt
+		for _, r := range s.regions {
+			lowest = min(lowest, r.index)
+		}
+		return lowest
+	}
+	// uncertainty puts files without predictions last.
+	uncertainty := func(s fileSummary) float64 {
+		if !s.predicted {
+			return -1
+		}
+		return s.uncertainty
+	}
+	slices.SortStableFunc(indices, func(a, b int) int {
+		sa, sb := summary(a), summary(b)
+		var c int
+		switch order {
+		case sortModified:
+			c = sb.modified.Compare(sa.modified) // newest first
+		case sortRegions:
+			c = cmp.Compare(len(sb.regions), len(sa.regions)) // most first
+		case sortClass:
+			c = cmp.Compare(lowestClass(sa), lowestClass(sb))
+		case sortUncertainty:
+			c = cmp.Compare(uncertainty(sb), uncertainty(sa)) // most first
+		}
+		if c != 0 {
+			return c
+		}
+		return cmp.Compare(a, b)
+	})
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFilter(t *testing.T) {
+	labels := []string{"Car", "person"}
+	names := []string{"a.jpg", "b.jpg", "c.jpg", "d.jpg", "cat.jpg"}
+	summaries := []fileSummary{
+		{
+			scanned:  true,
+			regions:  []Region{{xMid: 0.5, yMid: 0.5, width: 0.3, height: 0.3, index: 0}},
+			status:   StatusLabelled,
+			modified: time.Date(2026, 1, 31, 10, 0, 0, 0, time.Local),
+			dated:    true,
+		},
+		{scanned: true, status: StatusLabelled, negative: true, dated: true},
+		{
+			scanned: true,
+			regions: []Region{
+				{xMid: 0.2, yMid: 0.2, width: 0.005, height: 0.1, index: 1},
+				{xMid: 0.6, yMid: 0.6, width: 0.2, height: 0.2, index: 1},
+			},
+			status:      StatusApproved,
+			predicted:   true,
+			uncertainty: 0.7,
+			errors:      2,
+		},
+		{},
+		{scanned: true, status: StatusUnlabelled, predicted: true, uncertainty: 0.2},
+	}
+
+	tests := []struct {
+		expr string
+		want []string
+	}{
+		{"", names},
+		{"class:car", []string{"a.jpg"}},
+		{"class:1", []string{"c.jpg"}},
+		{"-class:car", []string{"b.jpg", "c.jpg", "cat.jpg"}},
+		{"status:approved", []string{"c.jpg"}},
+		{"status:unlabelled", []string{"cat.jpg"}},
+		{"nolabels", []string{"b.jpg", "cat.jpg"}},
+		{"negative", []string{"b.jpg"}},
+		{"-negative", []string{"a.jpg", "c.jpg", "cat.jpg"}},
+		{"regions>1", []string{"c.jpg"}},
+		{"regions>=1", []string{"a.jpg", "c.jpg"}},
+		{"regions=0", []string{"b.jpg", "cat.jpg"}},
+		{"tiny", []string{"c.jpg"}},
+		{"tiny<0.001", nil},
+		{"predicted", []string{"c.jpg", "cat.jpg"}},
+		{"errors", []string{"c.jpg"}},
+		{"errors>2", nil},
+		{"uncertain>0.5", []string{"c.jpg"}},
+		{"uncertain<=0.2", []string{"cat.jpg"}},
+		{"modified>2026-01-30", []string{"a.jpg"}},
+		{"modified=2026-01-31", []string{"a.jpg"}},
+		{"modified>2026-01-31", nil},
+		// Files whose times haven't been read don't match either way.
+		{"-modified>2026-01-30", []string{"b.jpg"}},
+		{"cat", []string{"cat.jpg"}},
+		{"-cat", []string{"a.jpg", "b.jpg", "c.jpg", "d.jpg"}},
+		{"JPG", names},
+		{"class:CAR status:labelled", []string{"a.jpg"}},
+		{"c -class:person", []string{"cat.jpg"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			f, err := parseFilter(tt.expr, labels)
+			if err != nil {
+				t.Fatalf("parseFilter(%q): %s", tt.expr, err)
+			}
+			var got []string
+			for i, name := range names {
+				if f.matches(name, summaries[i]) {
+					got = append(got, name)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("parseFilter(%q) matches %v, want %v", tt.expr, got, tt.want)
+			}
+			if want := strings.Contains(tt.expr, "modified"); f.needsDates() != want {
+				t.Errorf("needsDates = %v, want %v", f.needsDates(), want)
+			}
+		})
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	for _, expr := range []string{
+		"class:dog",
+		"status:done",
+		"size:3",
+		"regions>x",
+		"tiny>0.1",
+		"uncertain>high",
+		"errors>some",
+		"modified>yesterday",
+		"weight>3",
+	} {
+		t.Run(expr, func(t *testing.T) {
+			if _, err := parseFilter(expr, []string{"car"}); err == nil {
+				t.Errorf("parseFilter(%q) succeeded, want an error", expr)
+			}
+		})
+	}
+}
+
+func TestSortFiles(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2026, 1, d, 0, 0, 0, 0, time.UTC) }
+	summaries := []fileSummary{
+		{modified: day(2), regions: []Region{{index: 2}}},
+		{modified: day(3), regions: []Region{{index: 1}, {index: 3}}, predicted: true, uncertainty: 0.1},
+		{},
+		{modified: day(3), regions: []Region{{index: 1}}, predicted: true, uncertainty: 0.9},
+	}
+	tests := []struct {
+		name  string
+		order sortOrder
+		want  []int
+	}{
+		{"name", sortName, []int{0, 1, 2, 3}},
+		{"modified", sortModified, []int{1, 3, 0, 2}},
+		{"regions", sortRegions, []int{1, 0, 3, 2}},
+		{"class", sortClass, []int{1, 3, 0, 2}},
+		{"uncertainty", sortUncertainty, []int{3, 1, 0, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			indices := []int{0, 1, 2, 3}
+			sortFiles(indices, tt.order, summaries)
+			if !slices.Equal(indices, tt.want) {
+				t.Errorf("sortFiles = %v, want %v", indices, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"log"
+	"math"
+	"slices"
+)
+
+const (
+	// trackTemplateSize is the longest side of the template a tracked
+	// region is reduced to, in samples. Larger is more precise but slower.
+	trackTemplateSize = 48
+	// trackMinScore is the lowest normalized cross-correlation accepted as
+	// the object having been found; below it the object is taken as lost.
+	trackMinScore = 0.4
+	// acceptOverlap is how much a proposal must overlap a region of the same
+	// class for that region to be taken as a correction of it.
+	acceptOverlap = 0.3
+)
+
+// trackScales are the changes in size tried between frames, so objects
+// moving towards or away from the camera are followed. The first is
+// preferred unless another matches clearly better.
+var trackScales = []float64{1, 0.9, 1.1}
+
+// followState is regions being followed from one image into the next, which
+// is where their proposals will be shown.
+type followState struct {
+	from    image.Image // the displayed image the regions were on
+	regions []Region    // relative to the displayed image
+	target  string
+	started bool
+}
+
+// proposedRegions is the tracker's result for target.
+type proposedRegions struct {
+	target  string
+	regions []Region
+}
+
+// startFollowing follows the selected file's region index, or all of its
+// regions if index is negative, into the next file in the list, which it
+// returns so the caller can select it (or -1).
+func (m *appModel) startFollowing(index int) int {
+	file := m.currentFile()
+	if m.currentImage == nil || file == "" {
+		return -1
+	}
+	var regions []Region
+	for i, r := range m.currentRegions.Regions {
+		if index < 0 || i == index {
+			regions = append(regions, m.displayRegion(file, r))
+		}
+	}
+	return m.followInto(regions)
+}
+
+func (m *appModel) followInto(regions []Region) int {
+	next := m.visibleStep(1)
+	if len(regions) == 0 || next < 0 {
+		m.stopFollowing()
+		return -1
+	}
+	m.follow = &followState{from: m.currentImage, regions: regions, target: m.files[next]}
+	m.proposals = nil
+	return next
+}
+
+func (m *appModel) stopFollowing() {
+	m.follow = nil
+	m.proposals = nil
+}
+
+// updateFollowing starts the tracker once the followed-into image has been
+// decoded, and stops following if another file has been selected.
+func (m *appModel) updateFollowing() {
+	f := m.follow
+	if f == nil || f.started {
+		return
+	}
+	if m.currentFile() != f.target {
+		m.stopFollowing()
+		return
+	}
+	if m.currentImage == nil {
+		return
+	}
+	f.started = true
+	to := m.currentImage
+	go func() {
+		var proposals []Region
+		for _, r := range f.regions {
+			found, score := trackRegion(f.from, to, r)
+			if score < trackMinScore || !found.Normalize() {
+				log.Printf("Lost region %#v in %s (score %.2f)", r, f.target, score)
+				continue
+			}
+			proposals = append(proposals, found)
+		}
+		m.proposed <- proposedRegions{target: f.target, regions: proposals}
+	}()
+}
+
+// proposalsFound shows the tracker's proposals if they are still wanted.
+func (m *appModel) proposalsFound(p proposedRegions) {
+	if m.follow == nil || m.follow.target != p.target || m.currentFile() != p.target {
+		return
+	}
+	m.proposals = p.regions
+}
+
+// acceptProposals adds the proposals to the selected file, except where a
+// region of the same class has been drawn over one to correct it, and then
+// follows the result into the next file, which it returns (or -1).
+func (m *appModel) acceptProposals() int {
+	file := m.currentFile()
+	if m.follow == nil || m.follow.target != file || len(m.proposals) == 0 {
+		return -1
+	}
+	var accepted, following []Region
+	for _, p := range m.proposals {
+		j := slices.IndexFunc(m.currentRegions.Regions, func(r Region) bool {
+			return r.index == p.index && regionOverlap(m.displayRegion(file, r), p) > acceptOverlap
+		})
+		if j >= 0 {
+			following = append(following, m.displayRegion(file, m.currentRegions.Regions[j]))
+			continue
+		}
+		accepted = append(accepted, p)
+		following = append(following, p)
+	}
+	m.pasteRegions(accepted)
+	return m.followInto(following)
+}
+
+// regionOverlap is the intersection over union of two regions.
+func regionOverlap(a, b Region) float64 {
+	w := min(a.xMid+a.width/2, b.xMid+b.width/2) - max(a.xMid-a.width/2, b.xMid-b.width/2)
+	h := min(a.yMid+a.height/2, b.yMid+b.height/2) - max(a.yMid-a.height/2, b.yMid-b.height/2)
+	if w <= 0 || h <= 0 {
+		return 0
+	}
+	inter := w * h
+	return inter / (a.width*a.height + b.width*b.height - inter)
+}
+
+// trackRegion finds region r of image from in image to by normalized
+// cross-correlation of a reduced greyscale template, searched over a window
+// of three times the region's size at each of trackScales. It returns the
+// best match and its score, from -1 to 1.
+func trackRegion(from, to image.Image, r Region) (Region, float64) {
+	fb, tb := from.Bounds(), to.Bounds()
+	bw, bh := r.width*float64(fb.Dx()), r.height*float64(fb.Dy())
+	// Source pixels per sample: regions smaller than the template aren't
+	// enlarged.
+	step := max(max(bw, bh)/trackTemplateSize, 1)
+	tw, th := max(int(bw/step), 4), max(int(bh/step), 4)
+	x0 := float64(fb.Min.X) + r.xMid*float64(fb.Dx()) - bw/2
+	y0 := float64(fb.Min.Y) + r.yMid*float64(fb.Dy()) - bh/2
+	tmpl := sampleGrey(from, x0, y0, step, step, tw, th)
+	var mean float64
+	for _, v := range tmpl {
+		mean += v
+	}
+	mean /= float64(len(tmpl))
+	var norm float64
+	for i, v := range tmpl {
+		tmpl[i] = v - mean
+		norm += tmpl[i] * tmpl[i]
+	}
+	if norm == 0 {
+		// A featureless region can't be told from its surroundings.
+		return r, 0
+	}
+	norm = math.Sqrt(norm)
+
+	best, bestScore := r, -1.0
+	for i, scale := range trackScales {
+		sx := step * scale * float64(tb.Dx()) / float64(fb.Dx())
+		sy := step * scale * float64(tb.Dy()) / float64(fb.Dy())
+		ww, wh := tw*3, th*3
+		wx0 := float64(tb.Min.X) + r.xMid*float64(tb.Dx()) - float64(ww)/2*sx
+		wy0 := float64(tb.Min.Y) + r.yMid*float64(tb.Dy()) - float64(wh)/2*sy
+		window := sampleGrey(to, wx0, wy0, sx, sy, ww, wh)
+		ox, oy, score := matchTemplate(tmpl, norm, tw, th, window, ww, wh)
+		if i > 0 {
+			score -= 0.02
+		}
+		if score > bestScore {
+			bestScore = score
+			best = Region{
+				xMid:   (wx0 + (float64(ox)+float64(tw)/2)*sx - float64(tb.Min.X)) / float64(tb.Dx()),
+				yMid:   (wy0 + (float64(oy)+float64(th)/2)*sy - float64(tb.Min.Y)) / float64(tb.Dy()),
+				width:  r.width * scale,
+				height: r.height * scale,
+				index:  r.index,
+			}
+		}
+	}
+	return best, bestScore
+}
+
+// matchTemplate returns the offset in window where the zero-mean template
+// tmpl, of norm norm, correlates best, and the normalized correlation.
+func matchTemplate(tmpl []float64, norm float64, tw, th int, window []float64, ww, wh int) (int, int, float64) {
+	// Integral images give each patch's mean and variance in constant time.
+	sum := make([]float64, (ww+1)*(wh+1))
+	sq := make([]float64, (ww+1)*(wh+1))
+	for y := range wh {
+		var rowSum, rowSq float64
+		for x := range ww {
+			v := window[y*ww+x]
+			rowSum += v
+			rowSq += v * v
+			sum[(y+1)*(ww+1)+x+1] = sum[y*(ww+1)+x+1] + rowSum
+			sq[(y+1)*(ww+1)+x+1] = sq[y*(ww+1)+x+1] + rowSq
+		}
+	}
+	area := func(a []float64, x, y int) float64 {
+		return a[(y+th)*(ww+1)+x+tw] - a[y*(ww+1)+x+tw] - a[(y+th)*(ww+1)+x] + a[y*(ww+1)+x]
+	}
+	n := float64(tw * th)
+	bestX, bestY, best := 0, 0, -1.0
+	for y := 0; y+th <= wh; y++ {
+		for x := 0; x+tw <= ww; x++ {
+			s := area(sum, x, y)
+			variance := area(sq, x, y) - s*s/n
+			if variance <= 1e-9 {
+				continue
+			}
+			// The template is zero-mean, so the patch mean drops out.
+			var dot float64
+			for ty := range th {
+				row := window[(y+ty)*ww+x:][:tw]
+				t := tmpl[ty*tw:][:tw]
+				for tx, v := range t {
+					dot += v * row[tx]
+				}
+			}
+			if score := dot / (norm * math.Sqrt(variance)); score > best {
+				bestX, bestY, best = x, y, score
+			}
+		}
+	}
+	return bestX, bestY, best
+}
+
+// sampleGrey returns a w by h grid of luma samples of img, starting at x0, y0
+// and spaced sx, sy pixels apart, each averaging four points across its
+// cell. Points outside the image take the nearest edge pixel.
+func sampleGrey(img image.Image, x0, y0, sx, sy float64, w, h int) []float64 {
+	b := img.Bounds()
+	at := func(x, y float64) float64 {
+		px := min(max(int(x), b.Min.X), b.Max.X-1)
+		py := min(max(int(y), b.Min.Y), b.Max.Y-1)
+		return float64(color.Gray16Model.Convert(img.At(px, py)).(color.Gray16).Y)
+	}
+	out := make([]float64, w*h)
+	for y := range h {
+		for x := range w {
+			cx, cy := x0+float64(x)*sx, y0+float64(y)*sy
+			out[y*w+x] = (at(cx+sx/4, cy+sy/4) + at(cx+sx*3/4, cy+sy/4) +
+				at(cx+sx/4, cy+sy*3/4) + at(cx+sx*3/4, cy+sy*3/4)) / 4
+		}
+	}
+	return out
+}
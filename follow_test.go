@@ -0,0 +1,107 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestMatchTemplate(t *testing.T) {
+	// pattern is a 3x3 template with a distinct value at each point.
+	pattern := []float64{1, 5, 2, 8, 3, 9, 4, 7, 6}
+	zeroMean := func(values []float64) ([]float64, float64) {
+		var mean float64
+		for _, v := range values {
+			mean += v
+		}
+		mean /= float64(len(values))
+		tmpl := make([]float64, len(values))
+		var norm float64
+		for i, v := range values {
+			tmpl[i] = v - mean
+			norm += tmpl[i] * tmpl[i]
+		}
+		return tmpl, math.Sqrt(norm)
+	}
+	// place returns an 8x6 window of background, with values scaled by
+	// gain and offset by bias drawn at x, y.
+	place := func(background float64, values []float64, x, y int, gain, bias float64) []float64 {
+		window := make([]float64, 8*6)
+		for i := range window {
+			window[i] = background + float64(i%5)
+		}
+		for ty := range 3 {
+			for tx := range 3 {
+				window[(y+ty)*8+x+tx] = values[ty*3+tx]*gain + bias
+			}
+		}
+		return window
+	}
+
+	tests := []struct {
+		name   string
+		window []float64
+		x, y   int
+		score  float64
+	}{
+		{"exact", place(0, pattern, 4, 2, 1, 0), 4, 2, 1},
+		{"brighter and more contrast", place(0, pattern, 1, 3, 3, 50), 1, 3, 1},
+		{"top left corner", place(20, pattern, 0, 0, 1, 0), 0, 0, 1},
+		{"bottom right corner", place(20, pattern, 5, 3, 1, 0), 5, 3, 1},
+		{"flat window", make([]float64, 8*6), 0, 0, -1},
+	}
+	tmpl, norm := zeroMean(pattern)
+	for _, tt := range tests {
+		x, y, score := matchTemplate(tmpl, norm, 3, 3, tt.window, 8, 6)
+		if x != tt.x || y != tt.y {
+			t.Errorf("%s: matchTemplate found %d, %d, want %d, %d", tt.name, x, y, tt.x, tt.y)
+		}
+		if math.Abs(score-tt.score) > 1e-9 {
+			t.Errorf("%s: score %v, want %v", tt.name, score, tt.score)
+		}
+	}
+}
+
+func TestTrackRegion(t *testing.T) {
+	// scene draws a bright cross with its centre at cx, cy, arms size long.
+	scene := func(cx, cy, size int) image.Image {
+		img := image.NewGray(image.Rect(0, 0, 200, 200))
+		for i := range img.Pix {
+			img.Pix[i] = uint8(40 + i%7)
+		}
+		for d := -size; d <= size; d++ {
+			for w := -size / 4; w <= size/4; w++ {
+				img.SetGray(cx+d, cy+w, color.Gray{220})
+				img.SetGray(cx+w, cy+d, color.Gray{220})
+			}
+		}
+		return img
+	}
+	from := scene(80, 90, 20)
+	r := Region{xMid: 0.4, yMid: 0.45, width: 0.24, height: 0.24, index: 3}
+
+	tests := []struct {
+		name     string
+		to       image.Image
+		x, y     float64
+		scale    float64
+		minScore float64
+	}{
+		{"still", from, 0.4, 0.45, 1, 0.99},
+		{"moved", scene(95, 80, 20), 0.475, 0.4, 1, 0.9},
+		{"grown", scene(80, 90, 22), 0.4, 0.45, 1.1, 0.8},
+	}
+	for _, tt := range tests {
+		got, score := trackRegion(from, tt.to, r)
+		if math.Abs(got.xMid-tt.x) > 0.02 || math.Abs(got.yMid-tt.y) > 0.02 {
+			t.Errorf("%s: tracked to %v, %v, want %v, %v", tt.name, got.xMid, got.yMid, tt.x, tt.y)
+		}
+		if math.Abs(got.width-r.width*tt.scale) > 1e-9 || got.index != r.index {
+			t.Errorf("%s: tracked box %+v, want scale %v", tt.name, got, tt.scale)
+		}
+		if score < tt.minScore {
+			t.Errorf("%s: score %v, want at least %v", tt.name, score, tt.minScore)
+		}
+	}
+}
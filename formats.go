@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	_ "golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+	"golang.org/x/image/tiff/lzw"
+	_ "golang.org/x/image/webp"
+)
+
+// imageExtensions are the files in images/ that are listed. GIFs show their
+// first frame.
+var imageExtensions = []string{".jpg", ".jpeg", ".png", ".tif", ".tiff", ".webp", ".bmp", ".gif"}
+
+func isImageFile(name string) bool {
+	return slices.Contains(imageExtensions, strings.ToLower(filepath.Ext(name)))
+}
+
+func isTIFFFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".tif" || ext == ".tiff"
+}
+
+// isHighDepth reports whether img has more than 8 bits per channel, so must
+// go through a window/level mapping to be displayed usefully.
+func isHighDepth(img image.Image) bool {
+	switch img.(type) {
+	case *image.Gray16, *image.RGBA64, *image.NRGBA64:
+		return true
+	}
+	return false
+}
+
+// TIFF tags and values used by decodeMultibandTIFF.
+const (
+	tiffImageWidth      = 256
+	tiffImageLength     = 257
+	tiffBitsPerSample   = 258
+	tiffCompression     = 259
+	tiffPhotometric     = 262
+	tiffStripOffsets    = 273
+	tiffSamplesPerPixel = 277
+	tiffRowsPerStrip    = 278
+	tiffStripByteCounts = 279
+	tiffPlanarConfig    = 284
+	tiffPredictor       = 317
+	tiffTileWidth       = 322
+	tiffSampleFormat    = 339
+
+	tiffCompressionNone        = 1
+	tiffCompressionLZW         = 5
+	tiffCompressionDeflate     = 8
+	tiffCompressionDeflateOld  = 32946
+	tiffPhotometricRGB         = 2
+	tiffPredictorHorizontal    = 2
+	tiffPlanarSeparate         = 2
+	tiffSampleFormatUnsigned   = 1
+	tiffMaxMultibandDimensions = 1 << 15
+	tiffMaxSamplesPerPixel     = 1 << 10
+
+	// tiffMaxMultibandBytes bounds the memory taken by the shown bands.
+	tiffMaxMultibandBytes = imageCacheBytes
+)
+
+// decodeTIFF decodes a TIFF with x/image/tiff, falling back to
+// decodeMultibandTIFF for the multi-band imagery it doesn't support.
+func decodeTIFF(data []byte) (image.Image, error) {
+	img, err := tiff.Decode(bytes.NewReader(data))
+	if err == nil {
+		return img, nil
+	}
+	img, mbErr := decodeMultibandTIFF(data)
+	if mbErr != nil {
+		return nil, fmt.Errorf("%w (multi-band: %w)", err, mbErr)
+	}
+	return img, nil
+}
+
+// multibandHeader is what decodeMultibandTIFF needs from a TIFF's first IFD.
+type multibandHeader struct {
+	order  binary.ByteOrder
+	tags   map[uint16][]uint32
+	width  int
+	height int
+	spp    int // samples per pixel
+	bps    int // bits per sample
+	rgb    bool
+}
+
+// tag returns the first value of a tag, or def if it is missing.
+func (h multibandHeader) tag(tag uint16, def uint32) uint32 {
+	if v := h.tags[tag]; len(v) > 0 {
+		return v[0]
+	}
+	return def
+}
+
+// shown is how many of the bands are displayed, and so decoded.
+func (h multibandHeader) shown() int {
+	if h.rgb {
+		return 3
+	}
+	return 1
+}
+
+// planeBytes is the memory taken by the decoded bands.
+func (h multibandHeader) planeBytes() int {
+	return h.width * h.height * h.shown() * h.bps / 8
+}
+
+// readMultibandHeader reads and checks the header of a TIFF for
+// decodeMultibandTIFF.
+func readMultibandHeader(data []byte) (multibandHeader, error) {
+	var h multibandHeader
+	switch {
+	case len(data) < 8:
+		return h, fmt.Errorf("truncated header")
+	case bytes.HasPrefix(data, []byte("II*\x00")):
+		h.order = binary.LittleEndian
+	case bytes.HasPrefix(data, []byte("MM\x00*")):
+		h.order = binary.BigEndian
+	default:
+		return h, fmt.Errorf("not a TIFF file")
+	}
+	var err error
+	if h.tags, err = readTIFFTags(data, h.order, int64(h.order.Uint32(data[4:8]))); err != nil {
+		return h, err
+	}
+	h.width, h.height = int(h.tag(tiffImageWidth, 0)), int(h.tag(tiffImageLength, 0))
+	h.spp = int(h.tag(tiffSamplesPerPixel, 1))
+	h.bps = int(h.tag(tiffBitsPerSample, 1))
+	h.rgb = h.spp >= 3 && h.tag(tiffPhotometric, 1) == tiffPhotometricRGB
+	switch {
+	case h.width <= 0 || h.height <= 0 || h.width > tiffMaxMultibandDimensions || h.height > tiffMaxMultibandDimensions:
+		return h, fmt.Errorf("unsupported size %dx%d", h.width, h.height)
+	case h.spp < 1 || h.spp > tiffMaxSamplesPerPixel:
+		return h, fmt.Errorf("unsupported %d samples per pixel", h.spp)
+	case h.bps != 8 && h.bps != 16:
+		return h, fmt.Errorf("unsupported %d bits per sample", h.bps)
+	case h.tag(tiffSampleFormat, tiffSampleFormatUnsigned) != tiffSampleFormatUnsigned:
+		return h, fmt.Errorf("unsupported sample format")
+	case h.tags[tiffTileWidth] != nil:
+		return h, fmt.Errorf("tiled layout not supported")
+	case h.planeBytes() > tiffMaxMultibandBytes:
+		return h, fmt.Errorf("%dx%d is too large to decode", h.width, h.height)
+	}
+	return h, nil
+}
+
+// decodeMultibandTIFF decodes strip based TIFFs with any number of 8 or 16
+// bit unsigned bands, as written by multispectral and thermal cameras. The
+// first three bands are shown as RGB, or the first alone as grey unless the
+// file says it is RGB. Only the shown bands are kept, so the memory taken
+// doesn't grow with the number of bands.
+func decodeMultibandTIFF(data []byte) (image.Image, error) {
+	h, err := readMultibandHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	offsets, counts := h.tags[tiffStripOffsets], h.tags[tiffStripByteCounts]
+	if len(offsets) == 0 || len(offsets) != len(counts) {
+		return nil, fmt.Errorf("missing strip offsets")
+	}
+	rowsPerStrip := min(int(h.tag(tiffRowsPerStrip, uint32(h.height))), h.height)
+	if rowsPerStrip <= 0 {
+		return nil, fmt.Errorf("invalid rows per strip")
+	}
+	stripsPerPlane := (h.height + rowsPerStrip - 1) / rowsPerStrip
+
+	// The shown samples are gathered into planes, one per band, whatever the
+	// layout.
+	bytesPerSample := h.bps / 8
+	planar := h.tag(tiffPlanarConfig, 1) == tiffPlanarSeparate
+	planes := make([][]byte, h.shown())
+	for b := range planes {
+		planes[b] = make([]byte, h.width*h.height*bytesPerSample)
+	}
+	var row []byte
+	for strip := range offsets {
+		plane := 0
+		y0 := strip * rowsPerStrip
+		samples := h.spp
+		if planar {
+			plane, y0 = strip/stripsPerPlane, (strip%stripsPerPlane)*rowsPerStrip
+			samples = 1
+		}
+		if plane >= len(planes) || y0 >= h.height {
+			continue
+		}
+		rows := min(rowsPerStrip, h.height-y0)
+		rowBytes := h.width * samples * bytesPerSample
+		r, err := openTIFFStrip(data, int64(offsets[strip]), int64(counts[strip]), h.tag(tiffCompression, tiffCompressionNone))
+		if err != nil {
+			return nil, err
+		}
+		// Strips are read a row at a time, as a chunky strip holds every
+		// band.
+		if cap(row) < rowBytes {
+			row = make([]byte, rowBytes)
+		}
+		row = row[:rowBytes]
+		for y := range rows {
+			if _, err := io.ReadFull(r, row); err != nil {
+				r.Close()
+				return nil, fmt.Errorf("reading strip: %w", err)
+			}
+			if h.tag(tiffPredictor, 1) == tiffPredictorHorizontal {
+				undoPredictor(row, samples, bytesPerSample, h.order)
+			}
+			for x := range h.width {
+				for s := range min(samples, len(planes)-plane) {
+					src := row[(x*samples+s)*bytesPerSample:][:bytesPerSample]
+					dst := planes[plane+s][((y0+y)*h.width+x)*bytesPerSample:][:bytesPerSample]
+					if bytesPerSample == 2 {
+						// Stored big-endian, as image.Gray16 and friends are.
+						binary.BigEndian.PutUint16(dst, h.order.Uint16(src))
+					} else {
+						dst[0] = src[0]
+					}
+				}
+			}
+		}
+		r.Close()
+	}
+	return multibandImage(planes, h.width, h.height, h.bps, h.rgb), nil
+}
+
+// multibandImage assembles the displayed bands into an image.
+func multibandImage(planes [][]byte, width, height, bps int, rgb bool) image.Image {
+	r := image.Rect(0, 0, width, height)
+	bytesPerSample := bps / 8
+	if !rgb {
+		if bps == 16 {
+			return &image.Gray16{Pix: planes[0], Stride: width * 2, Rect: r}
+		}
+		return &image.Gray{Pix: planes[0], Stride: width, Rect: r}
+	}
+	pix := make([]byte, width*height*4*bytesPerSample)
+	for i := range width * height {
+		for c := range 3 {
+			copy(pix[(i*4+c)*bytesPerSample:], planes[c][i*bytesPerSample:(i+1)*bytesPerSample])
+		}
+		for b := range bytesPerSample {
+			pix[(i*4+3)*bytesPerSample+b] = 0xff
+		}
+	}
+	if bps == 16 {
+		return &image.RGBA64{Pix: pix, Stride: width * 8, Rect: r}
+	}
+	return &image.RGBA{Pix: pix, Stride: width * 4, Rect: r}
+}
+
+// readTIFFTags reads the integer tags of the IFD at offset.
+func readTIFFTags(data []byte, order binary.ByteOrder, offset int64) (map[uint16][]uint32, error) {
+	if offset < 8 || offset+2 > int64(len(data)) {
+		return nil, fmt.Errorf("invalid IFD offset %d", offset)
+	}
+	n := int64(order.Uint16(data[offset:]))
+	if offset+2+n*12 > int64(len(data)) {
+		return nil, fmt.Errorf("truncated IFD")
+	}
+	tags := map[uint16][]uint32{}
+	for i := range n {
+		entry := data[offset+2+i*12:][:12]
+		tag, typ, count := order.Uint16(entry), order.Uint16(entry[2:]), int64(order.Uint32(entry[4:]))
+		var size int64
+		switch typ {
+		case 1: // BYTE
+			size = 1
+		case 3: // SHORT
+			size = 2
+		case 4: // LONG
+			size = 4
+		default:
+			continue
+		}
+		values := entry[8:12]
+		if count*size > 4 {
+			at := int64(order.Uint32(entry[8:]))
+			if count > int64(len(data)) || at+count*size > int64(len(data)) {
+				return nil, fmt.Errorf("tag %d out of range", tag)
+			}
+			values = data[at : at+count*size]
+		}
+		v := make([]uint32, count)
+		for j := range v {
+			switch size {
+			case 1:
+				v[j] = uint32(values[j])
+			case 2:
+				v[j] = uint32(order.Uint16(values[j*2:]))
+			default:
+				v[j] = order.Uint32(values[j*4:])
+			}
+		}
+		tags[tag] = v
+	}
+	return tags, nil
+}
+
+// openTIFFStrip returns a reader of one strip's decompressed data.
+func openTIFFStrip(data []byte, offset, count int64, compression uint32) (io.ReadCloser, error) {
+	if offset < 0 || count < 0 || offset+count > int64(len(data)) {
+		return nil, fmt.Errorf("strip out of range")
+	}
+	src := bytes.NewReader(data[offset : offset+count])
+	switch compression {
+	case tiffCompressionNone:
+		return io.NopCloser(src), nil
+	case tiffCompressionLZW:
+		return lzw.NewReader(src, lzw.MSB, 8), nil
+	case tiffCompressionDeflate, tiffCompressionDeflateOld:
+		return zlib.NewReader(src)
+	}
+	return nil, fmt.Errorf("unsupported compression %d", compression)
+}
+
+// undoPredictor reverses horizontal differencing in one row.
+func undoPredictor(row []byte, samples, bytesPerSample int, order binary.ByteOrder) {
+	if bytesPerSample == 1 {
+		for i := samples; i < len(row); i++ {
+			row[i] += row[i-samples]
+		}
+		return
+	}
+	for i := samples * 2; i+1 < len(row); i += 2 {
+		order.PutUint16(row[i:], order.Uint16(row[i:])+order.Uint16(row[i-samples*2:]))
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"image"
+	"image/png"
+	"io"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+// testTag is a TIFF tag for encodeTIFF, of type BYTE, SHORT or LONG.
+type testTag struct {
+	tag    uint16
+	typ    uint16
+	values []uint32
+}
+
+// testOrder is a byte order that can also append.
+type testOrder interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+}
+
+// encodeTIFF builds a TIFF with one IFD holding tags and, if there are any
+// strips, their offsets and byte counts.
+func encodeTIFF(order testOrder, tags []testTag, strips [][]byte) []byte {
+	var buf []byte
+	if order == binary.LittleEndian {
+		buf = []byte("II*\x00\x00\x00\x00\x00")
+	} else {
+		buf = []byte("MM\x00*\x00\x00\x00\x00")
+	}
+	var offsets, counts []uint32
+	for _, strip := range strips {
+		offsets = append(offsets, uint32(len(buf)))
+		counts = append(counts, uint32(len(strip)))
+		buf = append(buf, strip...)
+	}
+	if len(strips) > 0 {
+		tags = append(tags, testTag{tiffStripOffsets, 4, offsets}, testTag{tiffStripByteCounts, 4, counts})
+	}
+	slices.SortFunc(tags, func(a, b testTag) int { return int(a.tag) - int(b.tag) })
+	if len(buf)%2 != 0 {
+		buf = append(buf, 0)
+	}
+	ifd := len(buf)
+	order.PutUint32(buf[4:], uint32(ifd))
+	extra := ifd + 2 + len(tags)*12 + 4
+	buf = order.AppendUint16(buf, uint16(len(tags)))
+	var values []byte
+	for _, t := range tags {
+		size := map[uint16]int{1: 1, 3: 2, 4: 4}[t.typ]
+		var data []byte
+		for _, v := range t.values {
+			switch size {
+			case 1:
+				data = append(data, byte(v))
+			case 2:
+				data = order.AppendUint16(data, uint16(v))
+			default:
+				data = order.AppendUint32(data, v)
+			}
+		}
+		buf = order.AppendUint16(buf, t.tag)
+		buf = order.AppendUint16(buf, t.typ)
+		buf = order.AppendUint32(buf, uint32(len(t.values)))
+		if len(data) <= 4 {
+			buf = append(buf, append(data, make([]byte, 4-len(data))...)...)
+		} else {
+			buf = order.AppendUint32(buf, uint32(extra+len(values)))
+			values = append(values, data...)
+		}
+	}
+	buf = append(buf, 0, 0, 0, 0)
+	return append(buf, values...)
+}
+
+func TestReadTIFFTags(t *testing.T) {
+	for _, order := range []testOrder{binary.LittleEndian, binary.BigEndian} {
+		data := encodeTIFF(order, []testTag{
+			{tiffImageWidth, 3, []uint32{640}},
+			{tiffImageLength, 4, []uint32{70000}},
+			{tiffBitsPerSample, 3, []uint32{16, 16, 16, 16, 16}},
+			{tiffPredictor, 1, []uint32{2}},
+		}, nil)
+		tags, err := readTIFFTags(data, order, int64(order.Uint32(data[4:])))
+		if err != nil {
+			t.Fatalf("%v: %s", order, err)
+		}
+		want := map[uint16][]uint32{
+			tiffImageWidth:    {640},
+			tiffImageLength:   {70000},
+			tiffBitsPerSample: {16, 16, 16, 16, 16},
+			tiffPredictor:     {2},
+		}
+		for tag, values := range want {
+			if !slices.Equal(tags[tag], values) {
+				t.Errorf("%v: tag %d = %v, want %v", order, tag, tags[tag], values)
+			}
+		}
+	}
+
+	valid := encodeTIFF(binary.LittleEndian, []testTag{{tiffBitsPerSample, 3, []uint32{8, 8, 8}}}, nil)
+	tests := []struct {
+		name   string
+		data   []byte
+		offset int64
+	}{
+		{"offset in header", valid, 4},
+		{"offset past end", valid, int64(len(valid))},
+		{"truncated entries", valid[:len(valid)-12], 8},
+		{"values out of range", valid[:len(valid)-6], 8},
+	}
+	for _, tt := range tests {
+		if _, err := readTIFFTags(tt.data, binary.LittleEndian, tt.offset); err == nil {
+			t.Errorf("%s: readTIFFTags succeeded, want an error", tt.name)
+		}
+	}
+}
+
+func TestOpenTIFFStrip(t *testing.T) {
+	plain := []byte("0123456789")
+	var deflated bytes.Buffer
+	zw := zlib.NewWriter(&deflated)
+	zw.Write(plain)
+	zw.Close()
+	data := append([]byte("xx"), plain...)
+	data = append(data, deflated.Bytes()...)
+
+	tests := []struct {
+		name          string
+		offset, count int64
+		compression   uint32
+		wantErr       bool
+	}{
+		{name: "uncompressed", offset: 2, count: 10, compression: tiffCompressionNone},
+		{name: "deflate", offset: 12, count: int64(deflated.Len()), compression: tiffCompressionDeflate},
+		{name: "old deflate", offset: 12, count: int64(deflated.Len()), compression: tiffCompressionDeflateOld},
+		{name: "not deflated", offset: 2, count: 10, compression: tiffCompressionDeflate, wantErr: true},
+		{name: "past the end", offset: 2, count: 100, compression: tiffCompressionNone, wantErr: true},
+		{name: "JPEG", offset: 2, count: 10, compression: 7, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := openTIFFStrip(data, tt.offset, tt.count, tt.compression)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("openTIFFStrip succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, plain) {
+				t.Errorf("strip = %q, want %q", got, plain)
+			}
+		})
+	}
+}
+
+func TestUndoPredictor(t *testing.T) {
+	tests := []struct {
+		name           string
+		row, want      []byte
+		samples, bytes int
+	}{
+		{"8-bit grey", []byte{10, 1, 2, 255}, []byte{10, 11, 13, 12}, 1, 1},
+		{"8-bit pairs", []byte{10, 20, 1, 2, 1, 2}, []byte{10, 20, 11, 22, 12, 24}, 2, 1},
+		{"16-bit", []byte{0xff, 0x00, 0x02, 0x00, 0x01, 0x00}, []byte{0xff, 0x00, 0x01, 0x01, 0x02, 0x01}, 1, 2},
+	}
+	for _, tt := range tests {
+		undoPredictor(tt.row, tt.samples, tt.bytes, binary.LittleEndian)
+		if !bytes.Equal(tt.row, tt.want) {
+			t.Errorf("%s: undoPredictor = %v, want %v", tt.name, tt.row, tt.want)
+		}
+	}
+}
+
+func TestDecodeMultibandTIFF(t *testing.T) {
+	// A 2x2 image of five 8-bit bands, sample b of pixel i being 10*i+b.
+	var chunky []byte
+	for i := range 4 {
+		for b := range 5 {
+			chunky = append(chunky, byte(10*i+b))
+		}
+	}
+	// manyBands is a 2x2 image of 500 bands, the first of pixel i being i.
+	manyBands := make([]byte, 4*500)
+	for i := range 4 {
+		manyBands[i*500] = byte(i)
+	}
+	hugeHeader := []testTag{
+		{tiffImageWidth, 3, []uint32{30000}},
+		{tiffImageLength, 3, []uint32{30000}},
+		{tiffBitsPerSample, 3, []uint32{16, 16, 16}},
+		{tiffSamplesPerPixel, 3, []uint32{3}},
+		{tiffPhotometric, 3, []uint32{tiffPhotometricRGB}},
+	}
+	header := func(spp, bps int, more ...testTag) []testTag {
+		bits := make([]uint32, spp)
+		for i := range bits {
+			bits[i] = uint32(bps)
+		}
+		return append([]testTag{
+			{tiffImageWidth, 3, []uint32{2}},
+			{tiffImageLength, 3, []uint32{2}},
+			{tiffBitsPerSample, 3, bits},
+			{tiffSamplesPerPixel, 3, []uint32{uint32(spp)}},
+		}, more...)
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    image.Image
+		wantErr bool
+	}{
+		{
+			name: "chunky bands as RGB",
+			data: encodeTIFF(binary.LittleEndian, header(5, 8, testTag{tiffPhotometric, 3, []uint32{tiffPhotometricRGB}}), [][]byte{chunky}),
+			want: &image.RGBA{Rect: image.Rect(0, 0, 2, 2), Stride: 8, Pix: []byte{
+				0, 1, 2, 255, 10, 11, 12, 255,
+				20, 21, 22, 255, 30, 31, 32, 255,
+			}},
+		},
+		{
+			name: "first band as grey in two strips",
+			data: encodeTIFF(binary.BigEndian, header(5, 8, testTag{tiffRowsPerStrip, 3, []uint32{1}}), [][]byte{chunky[:10], chunky[10:]}),
+			want: &image.Gray{Rect: image.Rect(0, 0, 2, 2), Stride: 2, Pix: []byte{0, 10, 20, 30}},
+		},
+		{
+			name: "planar 16-bit",
+			data: encodeTIFF(binary.LittleEndian, header(2, 16, testTag{tiffPlanarConfig, 3, []uint32{tiffPlanarSeparate}}), [][]byte{
+				{0x01, 0x00, 0x02, 0x00, 0x03, 0x00, 0x04, 0x01},
+				{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+			}),
+			want: &image.Gray16{Rect: image.Rect(0, 0, 2, 2), Stride: 4, Pix: []byte{0x00, 0x01, 0x00, 0x02, 0x00, 0x03, 0x01, 0x04}},
+		},
+		{
+			name: "horizontal predictor",
+			data: encodeTIFF(binary.LittleEndian, header(1, 8, testTag{tiffPredictor, 3, []uint32{tiffPredictorHorizontal}}), [][]byte{{5, 1, 7, 2}}),
+			want: &image.Gray{Rect: image.Rect(0, 0, 2, 2), Stride: 2, Pix: []byte{5, 6, 7, 9}},
+		},
+		{
+			name: "only the shown bands of many",
+			data: encodeTIFF(binary.LittleEndian, header(500, 8), [][]byte{manyBands}),
+			want: &image.Gray{Rect: image.Rect(0, 0, 2, 2), Stride: 2, Pix: []byte{0, 1, 2, 3}},
+		},
+		{name: "too large", data: encodeTIFF(binary.LittleEndian, hugeHeader, [][]byte{chunky}), wantErr: true},
+		{name: "not a TIFF", data: []byte("GIF89a\x00\x00\x00\x00"), wantErr: true},
+		{name: "no samples", data: encodeTIFF(binary.LittleEndian, header(1, 8, testTag{tiffSamplesPerPixel, 3, []uint32{0}}), [][]byte{chunky}), wantErr: true},
+		{name: "4-bit samples", data: encodeTIFF(binary.LittleEndian, header(5, 4), [][]byte{chunky}), wantErr: true},
+		{name: "float samples", data: encodeTIFF(binary.LittleEndian, header(5, 8, testTag{tiffSampleFormat, 3, []uint32{3}}), [][]byte{chunky}), wantErr: true},
+		{name: "tiled", data: encodeTIFF(binary.LittleEndian, header(5, 8, testTag{tiffTileWidth, 3, []uint32{16}}), [][]byte{chunky}), wantErr: true},
+		{name: "no strips", data: encodeTIFF(binary.LittleEndian, header(5, 8), nil), wantErr: true},
+		{name: "short strip", data: encodeTIFF(binary.LittleEndian, header(5, 8), [][]byte{chunky[:19]}), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeMultibandTIFF(tt.data)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("decodeMultibandTIFF succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decodeMultibandTIFF = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodedBytes(t *testing.T) {
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, image.NewGray(image.Rect(0, 0, 30, 20))); err != nil {
+		t.Fatal(err)
+	}
+	// A 100x50 image of 16-bit bands, 3 shown as RGB.
+	multiband := func(spp int) []byte {
+		bits := make([]uint32, spp)
+		for i := range bits {
+			bits[i] = 16
+		}
+		return encodeTIFF(binary.LittleEndian, []testTag{
+			{tiffImageWidth, 3, []uint32{100}},
+			{tiffImageLength, 3, []uint32{50}},
+			{tiffBitsPerSample, 3, bits},
+			{tiffSamplesPerPixel, 3, []uint32{uint32(spp)}},
+			{tiffPhotometric, 3, []uint32{tiffPhotometricRGB}},
+		}, [][]byte{{0}})
+	}
+	tests := []struct {
+		name     string
+		filename string
+		data     []byte
+		want     int
+	}{
+		{"PNG", "a.png", encoded.Bytes(), 30 * 20 * 8},
+		{"multi-band TIFF", "a.tif", multiband(5), 100*50*3*2 + 100*50*8},
+		{"hyperspectral TIFF", "a.tif", multiband(1000), 100*50*3*2 + 100*50*8},
+		{"unreadable", "a.tif", []byte("II*\x00junk"), 0},
+	}
+	for _, tt := range tests {
+		if got := decodedBytes(tt.data, tt.filename); got != tt.want {
+			t.Errorf("%s: decodedBytes = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"slices"
+	"sync"
+
+	"github.com/AndreRenaud/fastmark/storage"
+	"github.com/guigui-gui/guigui"
+	"github.com/guigui-gui/guigui/basicwidget"
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"golang.org/x/image/draw"
+)
+
+// galleryCrop is one region shown in the crop gallery.
+type galleryCrop struct {
+	file   int
+	region Region
+}
+
+// key identifies the crop's pixels, ignoring its class so re-tagging doesn't
+// need a new decode.
+func (c galleryCrop) key(m *appModel) string {
+	r := c.region
+	return fmt.Sprintf("%s %f %f %f %f", m.files[c.file], r.xMid, r.yMid, r.width, r.height)
+}
+
+// cropSourceCount is how many decoded images the crop gallery keeps, so the
+// regions of one file are cropped from a single decode.
+const cropSourceCount = 8
+
+// cropSourceCache keeps the images the crop gallery last decoded. The
+// thumbnail workers crop a file's regions at about the same time, so the
+// first to ask decodes the image and the others wait for it.
+type cropSourceCache struct {
+	mu      sync.Mutex
+	sources *lru.Cache[cropSourceKey, *cropSource]
+}
+
+type cropSourceKey struct {
+	backend storage.Storage
+	file    string
+}
+
+// cropSource is a decoded image, ready once ready is closed.
+type cropSource struct {
+	ready       chan struct{}
+	img         image.Image
+	orientation int
+	err         error
+}
+
+// load returns file's image, adjusted as crops are shown, and its EXIF
+// orientation, decoding it if it isn't kept.
+func (c *cropSourceCache) load(backend storage.Storage, file string) (image.Image, int, error) {
+	key := cropSourceKey{backend, file}
+	c.mu.Lock()
+	if c.sources == nil {
+		c.sources, _ = lru.New[cropSourceKey, *cropSource](cropSourceCount)
+	}
+	s, ok := c.sources.Get(key)
+	if !ok {
+		s = &cropSource{ready: make(chan struct{})}
+		c.sources.Add(key, s)
+	}
+	c.mu.Unlock()
+	if ok {
+		<-s.ready
+		return s.img, s.orientation, s.err
+	}
+
+	s.img, s.orientation, s.err = loadImage(backend, imagePath(file))
+	if s.err == nil {
+		s.img = adjustImage(s.img, defaultAdjust)
+	}
+	close(s.ready)
+	// Don't keep huge images around once those waiting have them.
+	if s.err == nil && imageBytes(s.img) > prefetchMaxBytes {
+		c.mu.Lock()
+		if kept, ok := c.sources.Peek(key); ok && kept == s {
+			c.sources.Remove(key)
+		}
+		c.mu.Unlock()
+	}
+	return s.img, s.orientation, s.err
+}
+
+// reset drops the kept images.
+func (c *cropSourceCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sources != nil {
+		c.sources.Purge()
+	}
+}
+
+// cropLoader returns the loader for the pixels of region within file.
+func (m *appModel) cropLoader(file string, region Region) thumbnailLoad {
+	oriented := m.settings.OrientedLabels
+	return func(backend storage.Storage) (image.Image, error) {
+		img, orientation, err := m.cropSources.load(backend, file)
+		if err != nil {
+			return nil, err
+		}
+		m.orientations.set(file, orientation)
+		if !oriented {
+			region = orientRegion(region, orientation)
+		}
+		rect := regionRect(region, img.Bounds())
+		if rect.Empty() {
+			return nil, fmt.Errorf("empty crop %v in %s", rect, file)
+		}
+		dst := image.NewRGBA(image.Rectangle{Max: rect.Size()})
+		draw.Draw(dst, dst.Bounds(), img, rect.Min, draw.Src)
+		return scaleToFit(dst, thumbnailSize), nil
+	}
+}
+
+// classCrops returns every scanned region of class, in file order.
+func (m *appModel) classCrops(class int) []galleryCrop {
+	m.metadataMu.Lock()
+	defer m.metadataMu.Unlock()
+	var crops []galleryCrop
+	for i, s := range m.summaries {
+		for _, region := range s.regions {
+			if region.index == class {
+				crops = append(crops, galleryCrop{file: i, region: region})
+			}
+		}
+	}
+	return crops
+}
+
+// galleryKey identifies the inputs the crop list was built from.
+type galleryKey struct {
+	class        int
+	scanned      int
+	totalRegions int
+	classTotal   int
+}
+
+// cropGallery shows every region of one class across the dataset so
+// mislabels stand out. Clicking a crop opens its image; right-clicking
+// deletes it, or re-tags it if a class's hotkey is held, as in the editor.
+type cropGallery struct {
+	guigui.DefaultWidget
+
+	model *appModel
+
+	classLabel  basicwidget.Text
+	classSelect basicwidget.Select[int]
+	countText   basicwidget.Text
+
+	crops []galleryCrop
+	built galleryKey
+	grid  gridLayout
+
+	headerItems []guigui.LinearLayoutItem
+
+	onOpen func(index int)
+}
+
+func (g *cropGallery) SetModel(m *appModel) {
+	g.model = m
+}
+
+// OnOpen registers a handler called with the file index of a clicked crop.
+func (g *cropGallery) OnOpen(f func(index int)) {
+	g.onOpen = f
+}
+
+func (g *cropGallery) updateCrops() {
+	m := g.model
+	meta := m.metadataSnapshot()
+	key := galleryKey{class: m.galleryClass, scanned: meta.Scanned, totalRegions: meta.TotalRegions}
+	if m.galleryClass >= 0 && m.galleryClass < len(meta.CategoryTotals) {
+		key.classTotal = meta.CategoryTotals[m.galleryClass]
+	}
+	if key == g.built {
+		return
+	}
+	if key.class != g.built.class {
+		g.grid.scrollY = 0
+	}
+	g.built = key
+	g.crops = m.classCrops(m.galleryClass)
+}
+
+func (g *cropGallery) WriteStateKey(context *guigui.Context, w *guigui.StateKeyWriter) {
+	w.WriteInt(g.grid.scrollY)
+	w.WriteInt(len(g.crops))
+}
+
+func (g *cropGallery) Build(context *guigui.Context, adder *guigui.ChildAdder) error {
+	adder.AddWidget(&g.classLabel)
+	adder.AddWidget(&g.classSelect)
+	adder.AddWidget(&g.countText)
+
+	m := g.model
+	if m == nil {
+		return nil
+	}
+	g.updateCrops()
+
+	g.classLabel.SetValue("Class")
+	g.classLabel.SetVerticalAlign(basicwidget.VerticalAlignMiddle)
+	items := make([]basicwidget.SelectItem[int], len(m.labels))
+	for i, label := range m.labels {
+		items[i] = basicwidget.SelectItem[int]{Text: fmt.Sprintf("%d %s", i, label), Value: i}
+	}
+	g.classSelect.SetItems(items)
+	g.classSelect.SelectItemByValue(m.galleryClass)
+	g.classSelect.OnItemSelected(func(context *guigui.Context, index int) {
+		if item, ok := g.classSelect.ItemByIndex(index); ok {
+			m.galleryClass = item.Value
+		}
+	})
+	g.countText.SetValue(fmt.Sprintf("%d regions (click to open, right-click to delete, hotkey+right-click to re-tag)", len(g.crops)))
+	g.countText.SetVerticalAlign(basicwidget.VerticalAlignMiddle)
+	return nil
+}
+
+func (g *cropGallery) header(context *guigui.Context) guigui.LinearLayout {
+	u := basicwidget.UnitSize(context)
+	g.headerItems = slices.Delete(g.headerItems, 0, len(g.headerItems))
+	g.headerItems = append(g.headerItems,
+		guigui.LinearLayoutItem{Widget: &g.classLabel},
+		guigui.LinearLayoutItem{Widget: &g.classSelect},
+		guigui.LinearLayoutItem{Widget: &g.countText, Size: guigui.FlexibleSize(1)},
+	)
+	return guigui.LinearLayout{
+		Direction: guigui.LayoutDirectionHorizontal,
+		Items:     g.headerItems,
+		Gap:       u / 4,
+	}
+}
+
+// updateGrid places the crop grid below the header row.
+func (g *cropGallery) updateGrid(context *guigui.Context, bounds image.Rectangle) {
+	u := basicwidget.UnitSize(context)
+	bounds.Min.Y += u + u/4
+	w := int(thumbnailSize * context.Scale() * 2 / 3)
+	g.grid.bounds = bounds
+	g.grid.cell = image.Pt(w, w)
+	g.grid.count = len(g.crops)
+}
+
+func (g *cropGallery) Layout(context *guigui.Context, widgetBounds *guigui.WidgetBounds, layouter *guigui.ChildLayouter) {
+	u := basicwidget.UnitSize(context)
+	b := widgetBounds.Bounds()
+	header := g.header(context)
+	header.LayoutWidgets(context, image.Rect(b.Min.X, b.Min.Y, b.Max.X, b.Min.Y+u), layouter)
+	g.updateGrid(context, b)
+}
+
+func (g *cropGallery) Tick(context *guigui.Context, widgetBounds *guigui.WidgetBounds) error {
+	m := g.model
+	if m == nil {
+		return nil
+	}
+	g.updateCrops()
+	g.updateGrid(context, widgetBounds.Bounds())
+	g.grid.clampScroll()
+
+	first, last := g.grid.visibleRange()
+	onScreen := map[string]bool{}
+	for _, crop := range g.crops[first:last] {
+		key := crop.key(m)
+		onScreen[key] = true
+		m.crops.get(key, m.cropLoader(m.files[crop.file], crop.region))
+	}
+	m.crops.retain(func(key string) bool {
+		return onScreen[key]
+	})
+	if m.crops.update() {
+		guigui.RequestRedraw(g)
+	}
+	return nil
+}
+
+func (g *cropGallery) HandlePointingInput(context *guigui.Context, widgetBounds *guigui.WidgetBounds) guigui.HandleInputResult {
+	m := g.model
+	cursor := image.Pt(ebiten.CursorPosition())
+	if m == nil || !cursor.In(g.grid.bounds) || !widgetBounds.IsHitAtCursor() {
+		return guigui.HandleInputResult{}
+	}
+	if _, dy := ebiten.Wheel(); dy != 0 {
+		g.grid.scrollBy(dy)
+		return guigui.HandleInputByWidget(g)
+	}
+	pos := g.grid.cellAt(cursor)
+	if pos < 0 {
+		return guigui.HandleInputResult{}
+	}
+	crop := g.crops[pos]
+	if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
+		if g.onOpen != nil {
+			g.onOpen(crop.file)
+		}
+		return guigui.HandleInputByWidget(g)
+	}
+	if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonRight) && !m.labelsLocked() {
+		// If we're pressing a class's hotkey, change the region type, otherwise delete it
+		changeRegion := m.pressedClass(ebiten.IsKeyPressed, nil)
+		m.editRegion(crop.file, crop.region, changeRegion)
+		g.updateCrops()
+		return guigui.HandleInputByWidget(g)
+	}
+	return guigui.HandleInputResult{}
+}
+
+func (g *cropGallery) Draw(context *guigui.Context, widgetBounds *guigui.WidgetBounds, dst *ebiten.Image) {
+	m := g.model
+	if m == nil {
+		return
+	}
+	// Crops scrolled partly under the header must not draw over it.
+	dst = dst.SubImage(g.grid.bounds).(*ebiten.Image)
+	pad := int(4 * context.Scale())
+	first, last := g.grid.visibleRange()
+	for pos := first; pos < last; pos++ {
+		crop := g.crops[pos]
+		cr := g.grid.cellRect(pos)
+		area := image.Rect(cr.Min.X+pad, cr.Min.Y+pad, cr.Max.X-pad, cr.Max.Y-pad)
+		img, ok := m.crops.get(crop.key(m), m.cropLoader(m.files[crop.file], crop.region))
+		if !ok {
+			strokeRect(dst, area, color.RGBA{0x80, 0x80, 0x80, 0xff})
+			continue
+		}
+		ir, scale := fitRect(img.Bounds().Size(), area)
+		op := &ebiten.DrawImageOptions{}
+		op.GeoM.Scale(scale, scale)
+		op.GeoM.Translate(float64(ir.Min.X), float64(ir.Min.Y))
+		op.Filter = ebiten.FilterLinear
+		dst.DrawImage(img, op)
+		strokeRect(dst, ir, m.classColor(crop.region.index))
+	}
+}
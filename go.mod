@@ -10,6 +10,7 @@ require (
 	github.com/pkg/sftp v1.13.11
 	golang.design/x/clipboard v0.8.0
 	golang.org/x/image v0.44.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
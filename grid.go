@@ -0,0 +1,250 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"slices"
+
+	"github.com/guigui-gui/guigui"
+	"github.com/guigui-gui/guigui/basicwidget"
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/vector"
+)
+
+// gridLayout positions count equally sized cells in rows that scroll
+// vertically within bounds.
+type gridLayout struct {
+	bounds  image.Rectangle
+	cell    image.Point
+	count   int
+	scrollY int
+}
+
+func (g gridLayout) columns() int {
+	if g.cell.X <= 0 {
+		return 1
+	}
+	return max(1, g.bounds.Dx()/g.cell.X)
+}
+
+// visibleRange returns the cells that are at least partly on screen.
+func (g gridLayout) visibleRange() (int, int) {
+	if g.cell.Y <= 0 {
+		return 0, 0
+	}
+	cols := g.columns()
+	first := g.scrollY / g.cell.Y * cols
+	last := ((g.scrollY+g.bounds.Dy())/g.cell.Y + 1) * cols
+	return min(first, g.count), min(last, g.count)
+}
+
+func (g gridLayout) cellRect(pos int) image.Rectangle {
+	cols := g.columns()
+	origin := g.bounds.Min.Add(image.Pt(pos%cols*g.cell.X, pos/cols*g.cell.Y-g.scrollY))
+	return image.Rectangle{Min: origin, Max: origin.Add(g.cell)}
+}
+
+// cellAt returns the cell under p, or -1.
+func (g gridLayout) cellAt(p image.Point) int {
+	first, last := g.visibleRange()
+	for pos := first; pos < last; pos++ {
+		if p.In(g.cellRect(pos)) {
+			return pos
+		}
+	}
+	return -1
+}
+
+func (g *gridLayout) clampScroll() {
+	rows := (g.count + g.columns() - 1) / g.columns()
+	g.scrollY = max(0, min(g.scrollY, rows*g.cell.Y-g.bounds.Dy()))
+}
+
+// scrollBy scrolls by wheel ticks, half a row per tick.
+func (g *gridLayout) scrollBy(wheel float64) {
+	g.scrollY -= int(wheel * float64(g.cell.Y) / 2)
+	g.clampScroll()
+}
+
+// ensureVisible centres cell pos if it isn't already fully on screen.
+func (g *gridLayout) ensureVisible(pos int) {
+	y := pos / g.columns() * g.cell.Y
+	if y < g.scrollY || y+g.cell.Y > g.scrollY+g.bounds.Dy() {
+		g.scrollY = y - (g.bounds.Dy()-g.cell.Y)/2
+	}
+	g.clampScroll()
+}
+
+// fitRect returns the largest rectangle with size's aspect ratio centred in
+// area, and the scale that maps size onto it.
+func fitRect(size image.Point, area image.Rectangle) (image.Rectangle, float64) {
+	scale := min(float64(area.Dx())/float64(size.X), float64(area.Dy())/float64(size.Y))
+	w, h := int(float64(size.X)*scale), int(float64(size.Y)*scale)
+	return image.Rect(0, 0, w, h).Add(area.Min).Add(image.Pt((area.Dx()-w)/2, (area.Dy()-h)/2)), scale
+}
+
+// thumbnailGrid shows the visible files as a scrollable grid of thumbnails
+// with their scanned regions overlaid. Only the rows on screen are laid out,
+// drawn and decoded, so it stays responsive on very large datasets.
+type thumbnailGrid struct {
+	guigui.DefaultWidget
+
+	model *appModel
+
+	names guigui.WidgetSlice[*basicwidget.Text]
+
+	grid gridLayout
+	// scrollTo is a file index to bring into view once the grid's size is
+	// known, or -1.
+	scrollTo int
+
+	onSelect func(index int)
+}
+
+func (g *thumbnailGrid) SetModel(m *appModel) {
+	g.model = m
+}
+
+// OnSelect registers a handler called with the file index of a clicked
+// thumbnail.
+func (g *thumbnailGrid) OnSelect(f func(index int)) {
+	g.onSelect = f
+}
+
+// ensureVisible scrolls the grid so that file index i is on screen.
+func (g *thumbnailGrid) ensureVisible(i int) {
+	g.scrollTo = i
+}
+
+// updateGrid refreshes the grid geometry. The cells leave room for the file
+// name under each thumbnail.
+func (g *thumbnailGrid) updateGrid(context *guigui.Context) {
+	w := int(thumbnailSize * context.Scale() * 3 / 4)
+	g.grid.cell = image.Pt(w, w*3/4+basicwidget.LineHeight(context))
+	g.grid.count = len(g.model.visible)
+}
+
+func (g *thumbnailGrid) WriteStateKey(context *guigui.Context, w *guigui.StateKeyWriter) {
+	w.WriteInt(g.grid.scrollY)
+	w.WriteInt(g.grid.bounds.Dx())
+	w.WriteInt(g.grid.bounds.Dy())
+}
+
+func (g *thumbnailGrid) Build(context *guigui.Context, adder *guigui.ChildAdder) error {
+	m := g.model
+	g.updateGrid(context)
+	first, last := g.grid.visibleRange()
+	g.names.SetLen(last - first)
+	for i := range g.names.Len() {
+		adder.AddWidget(g.names.At(i))
+	}
+	for i := range g.names.Len() {
+		file := m.visible[first+i]
+		t := g.names.At(i)
+		t.SetValue(m.files[file])
+		t.SetEllipsisString("…")
+		t.SetHorizontalAlign(basicwidget.HorizontalAlignCenter)
+		t.SetColor(m.summary(file).status.Color())
+	}
+	return nil
+}
+
+func (g *thumbnailGrid) Layout(context *guigui.Context, widgetBounds *guigui.WidgetBounds, layouter *guigui.ChildLayouter) {
+	g.grid.bounds = widgetBounds.Bounds()
+	first, _ := g.grid.visibleRange()
+	lh := basicwidget.LineHeight(context)
+	for i := range g.names.Len() {
+		cr := g.grid.cellRect(first + i)
+		layouter.LayoutWidget(g.names.At(i), image.Rect(cr.Min.X, cr.Max.Y-lh, cr.Max.X, cr.Max.Y))
+	}
+}
+
+// Tick scrolls to any pending file, queues decodes for the cells on screen
+// and drops queued decodes for cells that have scrolled away.
+func (g *thumbnailGrid) Tick(context *guigui.Context, widgetBounds *guigui.WidgetBounds) error {
+	m := g.model
+	if m == nil {
+		return nil
+	}
+	g.grid.bounds = widgetBounds.Bounds()
+	g.updateGrid(context)
+	if g.scrollTo >= 0 {
+		if pos := slices.Index(m.visible, g.scrollTo); pos >= 0 {
+			g.grid.ensureVisible(pos)
+		}
+		g.scrollTo = -1
+	}
+	g.grid.clampScroll()
+
+	first, last := g.grid.visibleRange()
+	onScreen := map[string]bool{}
+	for _, i := range m.visible[first:last] {
+		onScreen[m.files[i]] = true
+		m.thumbnails.get(m.files[i], m.thumbnailLoader(m.files[i]))
+	}
+	m.thumbnails.retain(func(key string) bool {
+		return onScreen[key]
+	})
+	if m.thumbnails.update() {
+		guigui.RequestRedraw(g)
+	}
+	return nil
+}
+
+func (g *thumbnailGrid) HandlePointingInput(context *guigui.Context, widgetBounds *guigui.WidgetBounds) guigui.HandleInputResult {
+	m := g.model
+	if m == nil || !widgetBounds.IsHitAtCursor() {
+		return guigui.HandleInputResult{}
+	}
+	if _, dy := ebiten.Wheel(); dy != 0 {
+		g.grid.scrollBy(dy)
+		return guigui.HandleInputByWidget(g)
+	}
+	if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
+		if pos := g.grid.cellAt(image.Pt(ebiten.CursorPosition())); pos >= 0 && g.onSelect != nil {
+			g.onSelect(m.visible[pos])
+		}
+		return guigui.HandleInputByWidget(g)
+	}
+	return guigui.HandleInputResult{}
+}
+
+func (g *thumbnailGrid) Draw(context *guigui.Context, widgetBounds *guigui.WidgetBounds, dst *ebiten.Image) {
+	m := g.model
+	if m == nil {
+		return
+	}
+	lh := basicwidget.LineHeight(context)
+	pad := int(4 * context.Scale())
+	first, last := g.grid.visibleRange()
+	for pos := first; pos < last; pos++ {
+		file := m.visible[pos]
+		cr := g.grid.cellRect(pos)
+		area := image.Rect(cr.Min.X+pad, cr.Min.Y+pad, cr.Max.X-pad, cr.Max.Y-lh)
+		if file == m.selectedIndex {
+			vector.FillRect(dst, float32(cr.Min.X), float32(cr.Min.Y), float32(cr.Dx()), float32(cr.Dy()), color.RGBA{0x40, 0x80, 0xff, 0x60}, false)
+		}
+
+		img, ok := m.thumbnails.get(m.files[file], m.thumbnailLoader(m.files[file]))
+		if !ok {
+			strokeRect(dst, area, color.RGBA{0x80, 0x80, 0x80, 0xff})
+			continue
+		}
+		ir, scale := fitRect(img.Bounds().Size(), area)
+		op := &ebiten.DrawImageOptions{}
+		op.GeoM.Scale(scale, scale)
+		op.GeoM.Translate(float64(ir.Min.X), float64(ir.Min.Y))
+		op.Filter = ebiten.FilterLinear
+		dst.DrawImage(img, op)
+
+		summary := m.summary(file)
+		for _, region := range summary.regions {
+			region = m.displayRegion(m.files[file], region)
+			strokeRect(dst, regionRect(region, ir), m.classColor(region.index))
+		}
+		if c := summary.status.Color(); c != nil {
+			strokeRect(dst, ir, c)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AndreRenaud/fastmark/storage"
+)
+
+// modelTimeout bounds how long a detector may take over one image, allowing
+// for it loading its model on the first.
+const modelTimeout = 2 * time.Minute
+
+// modelClient is the HTTP client used for detector URLs.
+var modelClient = &http.Client{Timeout: modelTimeout}
+
+// modelRunner runs a detector on an image file's contents. Predictions are
+// relative to the image after its EXIF orientation is applied, as detectors
+// see it.
+type modelRunner interface {
+	predict(ctx context.Context, name string, data []byte) ([]Suggestion, error)
+}
+
+// newModelRunner returns the detector described by spec: an http:// or
+// https:// URL the image is POSTed to, or otherwise a command to run.
+func newModelRunner(spec string) modelRunner {
+	if strings.HasPrefix(spec, "http://") || strings.HasPrefix(spec, "https://") {
+		return httpModel{url: spec}
+	}
+	return &commandModel{args: strings.Fields(spec)}
+}
+
+// httpModel POSTs each image's bytes to a URL, with its name in the
+// X-Filename header, and reads back predictions file lines.
+type httpModel struct {
+	url string
+}
+
+func (h httpModel) predict(ctx context.Context, name string, data []byte) ([]Suggestion, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", http.DetectContentType(data))
+	req.Header.Set("X-Filename", name)
+	resp, err := modelClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("%s: %s: %s", h.url, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return parsePredictions(resp.Body, h.url)
+}
+
+// commandModel keeps a detector process running, so its model is loaded
+// once. For each image it writes a "<length> <name>" line and then the
+// image's bytes to the process's standard input, and reads predictions file
+// lines back from its standard output up to an empty line.
+type commandModel struct {
+	args []string
+
+	mu     sync.Mutex
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+}
+
+func (c *commandModel) predict(ctx context.Context, name string, data []byte) ([]Suggestion, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if c.cmd == nil {
+		if err := c.start(); err != nil {
+			return nil, err
+		}
+	}
+	// Killing the process is the only way to interrupt a blocked read or
+	// write, and the next image starts it afresh.
+	ctx, cancel := context.WithTimeout(ctx, modelTimeout)
+	defer cancel()
+	process := c.cmd.Process
+	stopKill := context.AfterFunc(ctx, func() { process.Kill() })
+	suggestions, err := c.exchange(name, data)
+	if !stopKill() {
+		// It was killed, perhaps just after replying.
+		c.stop()
+		return nil, fmt.Errorf("%s: %w", c.args[0], ctx.Err())
+	}
+	if err != nil {
+		// The process may be stuck part way through a reply, so start afresh.
+		c.stop()
+	}
+	return suggestions, err
+}
+
+func (c *commandModel) start() error {
+	if len(c.args) == 0 {
+		return fmt.Errorf("No model command specified")
+	}
+	cmd := exec.Command(c.args[0], c.args[1:]...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	// Let the detector's own logging through.
+	cmd.Stderr = log.Writer()
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	c.cmd, c.stdin, c.stdout = cmd, stdin, bufio.NewReader(stdout)
+	return nil
+}
+
+func (c *commandModel) exchange(name string, data []byte) ([]Suggestion, error) {
+	if _, err := fmt.Fprintf(c.stdin, "%d %s\n", len(data), name); err != nil {
+		return nil, err
+	}
+	if _, err := c.stdin.Write(data); err != nil {
+		return nil, err
+	}
+	var reply strings.Builder
+	for {
+		line, err := c.stdout.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("reading from %s: %w", c.args[0], err)
+		}
+		if strings.TrimSpace(line) == "" {
+			break
+		}
+		reply.WriteString(line)
+	}
+	return parsePredictions(strings.NewReader(reply.String()), c.args[0])
+}
+
+func (c *commandModel) stop() {
+	if c.cmd == nil {
+		return
+	}
+	c.stdin.Close()
+	c.cmd.Process.Kill()
+	c.cmd.Wait()
+	c.cmd = nil
+}
+
+// imageFileBytes returns the contents of an image file to send to a
+// detector; video frames are extracted as PNGs.
+func imageFileBytes(ctx context.Context, backend storage.Storage, file string) ([]byte, error) {
+	if video, frame, ok := parseVideoFrame(file); ok {
+		return videoFramePNG(ctx, backend, video, frame)
+	}
+	f, err := backend.Open(imagePath(file))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(cancelReader{ctx: ctx, r: f})
+}
+
+// predictFile runs the detector on one image and writes its predictions to
+// the file the suggestions are read from, in label coordinates.
+func predictFile(ctx context.Context, backend storage.Storage, model modelRunner, file, dir string, orientedLabels bool) error {
+	data, err := imageFileBytes(ctx, backend, file)
+	if err != nil {
+		return err
+	}
+	suggestions, err := model.predict(ctx, file, data)
+	if err != nil {
+		return err
+	}
+	if o := exifOrientation(data); !orientedLabels && o != 1 {
+		for i := range suggestions {
+			suggestions[i].Region = orientRegion(suggestions[i].Region, inverseOrientation(o))
+		}
+	}
+	w, err := backend.OpenWrite(predictionFileName(dir, file), false)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, predictionsText(suggestions)); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// modelProgress reports each image a model run has finished to the main
+// goroutine.
+type modelProgress struct {
+	run   int
+	file  string
+	done  int
+	total int
+	err   error
+}
+
+// runModel runs the detector over files in the background, replacing any
+// run already in progress. Each image's predictions are saved, and shown as
+// suggestions if it is the selected file.
+func (m *appModel) runModel(files []string) {
+	if m.model == nil {
+		log.Printf("No model configured; start FastMark with -model")
+		return
+	}
+	m.stopModel()
+	ctx, cancel := context.WithCancel(context.Background())
+	m.modelCancel = cancel
+	m.modelRun++
+	run := m.modelRun
+	m.modelErrors = 0
+	m.modelStatus = fmt.Sprintf("Model: 0/%d", len(files))
+	backend, model, dir, oriented := m.backend, m.model, m.settings.predictionsDir(), m.settings.OrientedLabels
+	files = slices.Clone(files)
+	go func() {
+		for i, file := range files {
+			err := predictFile(ctx, backend, model, file, dir, oriented)
+			if ctx.Err() != nil {
+				return
+			}
+			m.modelResults <- modelProgress{run: run, file: file, done: i + 1, total: len(files), err: err}
+		}
+	}()
+}
+
+func (m *appModel) stopModel() {
+	if m.modelCancel != nil {
+		m.modelCancel()
+		m.modelCancel = nil
+	}
+}
+
+// modelRan applies one result of a model run.
+func (m *appModel) modelRan(p modelProgress) {
+	if p.file == m.currentFile() {
+		m.loadSuggestions(p.file)
+	}
+	if i := slices.Index(m.files, p.file); i >= 0 && p.err == nil {
+		m.updateUncertainty(i)
+	}
+	// Results of a replaced run may still be arriving.
+	if p.run != m.modelRun {
+		return
+	}
+	if p.err != nil {
+		log.Printf("Error running model on %s: %s", p.file, p.err)
+		m.modelErrors++
+	}
+	m.modelStatus = fmt.Sprintf("Model: %d/%d", p.done, p.total)
+	if m.modelErrors > 0 {
+		m.modelStatus += fmt.Sprintf(", %d failed", m.modelErrors)
+	}
+	if p.done == p.total {
+		m.modelCancel = nil
+	}
+}
@@ -0,0 +1,468 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/guigui-gui/guigui"
+	"github.com/guigui-gui/guigui/basicwidget"
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+)
+
+// keymapFile is the keymap read at startup, in the user's configuration
+// directory, unless -keymap names another.
+const keymapFile = "fastmark/keymap.conf"
+
+// keyAction is something the keyboard can do, and the keys it has unless the
+// keymap file says otherwise.
+type keyAction struct {
+	name string
+	help string
+	// repeat actions repeat while their key is held.
+	repeat bool
+	// navigation actions come before class hotkeys, which can take over the
+	// keys of the others.
+	navigation bool
+	// edits actions change the labels, so are ignored while a remap is
+	// rewriting them.
+	edits    bool
+	defaults []string
+}
+
+// keyActions are the keyboard actions, in the order they are checked and
+// listed in the cheatsheet.
+var keyActions = []keyAction{
+	{name: "next-image", help: "Next image", repeat: true, navigation: true, defaults: []string{"Down", "J"}},
+	{name: "prev-image", help: "Previous image", repeat: true, navigation: true, defaults: []string{"Up", "K"}},
+	{name: "prev-class", help: "Previous class to draw", repeat: true, navigation: true, defaults: []string{"Left", "H"}},
+	{name: "next-class", help: "Next class to draw", repeat: true, navigation: true, defaults: []string{"Right", "L"}},
+	{name: "class-palette", help: "Search for the class to draw", defaults: []string{"C"}},
+	{name: "retag-palette", help: "Search for a new class for the region under the cursor", edits: true, defaults: []string{"shift+C"}},
+	{name: "next-unlabelled", help: "Next unlabelled image", repeat: true, defaults: []string{"N"}},
+	{name: "prev-unlabelled", help: "Previous unlabelled image", repeat: true, defaults: []string{"shift+N"}},
+	{name: "most-uncertain", help: "Unlabelled image with the most uncertain predictions", defaults: []string{"M"}},
+	{name: "delete-region", help: "Delete the region under the cursor", edits: true, defaults: []string{"Delete", "Backspace"}},
+	{name: "undo", help: "Undo the last change to this image's regions", edits: true, defaults: []string{"ctrl+Z"}},
+	{name: "needs-review", help: "Mark as needing review", edits: true, defaults: []string{"R"}},
+	{name: "approve", help: "Mark as approved", edits: true, defaults: []string{"A"}},
+	{name: "reject", help: "Mark as rejected and edit the comment", edits: true, defaults: []string{"X"}},
+	{name: "clear-status", help: "Clear the review status", edits: true, defaults: []string{"U"}},
+	{name: "toggle-empty", help: "Mark as containing no objects, or undo that", edits: true, defaults: []string{"E"}},
+	{name: "toggle-grid", help: "Switch between the editor and the grid", defaults: []string{"G"}},
+	{name: "zoom-in", help: "Zoom in", repeat: true, defaults: []string{"Equal"}},
+	{name: "zoom-out", help: "Zoom out", repeat: true, defaults: []string{"Minus"}},
+	{name: "zoom-fit", help: "Fit the image in the editor", defaults: []string{"F"}},
+	{name: "keyframe", help: "Make the region under the cursor a keyframe of the track", edits: true, defaults: []string{"T"}},
+	{name: "new-track", help: "Start a new track with the next keyframe", defaults: []string{"shift+T"}},
+	{name: "interpolate", help: "Re-interpolate the current track", edits: true, defaults: []string{"I"}},
+	{name: "copy-previous", help: "Copy the previous image's regions (hold a class hotkey for one class)", edits: true, defaults: []string{"P"}},
+	{name: "copy-next", help: "Copy the next image's regions (hold a class hotkey for one class)", edits: true, defaults: []string{"shift+P"}},
+	{name: "accept-suggestion", help: "Accept the suggestion under the cursor", edits: true, defaults: []string{"Y"}},
+	{name: "accept-suggestions", help: "Accept all shown suggestions", edits: true, defaults: []string{"shift+Y"}},
+	{name: "reject-suggestion", help: "Reject the suggestion under the cursor", edits: true, defaults: []string{"D"}},
+	{name: "reject-suggestions", help: "Reject all shown suggestions", edits: true, defaults: []string{"shift+D"}},
+	{name: "follow", help: "Follow the region under the cursor, or all, with the tracker", defaults: []string{"O"}},
+	{name: "accept-proposals", help: "Accept the tracker's proposals and follow them on", edits: true, defaults: []string{"Enter"}},
+	{name: "stop-following", help: "Stop following", defaults: []string{"Escape"}},
+	{name: "copy-regions", help: "Copy the region under the cursor, or all regions", defaults: []string{"ctrl+C"}},
+	{name: "paste-regions", help: "Paste copied regions", edits: true, defaults: []string{"ctrl+V"}},
+	{name: "cheatsheet", help: "Show or hide these shortcuts", defaults: []string{"shift+Slash", "F1"}},
+}
+
+// keyBinding is a key and the modifiers held with it. Ctrl matches either
+// control or command.
+type keyBinding struct {
+	key              ebiten.Key
+	ctrl, shift, alt bool
+}
+
+// parseKeyBinding reads a binding such as "J", "Down" or "ctrl+shift+Z".
+func parseKeyBinding(s string) (keyBinding, error) {
+	var b keyBinding
+	parts := strings.Split(s, "+")
+	for _, modifier := range parts[:len(parts)-1] {
+		switch strings.ToLower(modifier) {
+		case "ctrl", "control", "cmd", "meta":
+			b.ctrl = true
+		case "shift":
+			b.shift = true
+		case "alt", "option":
+			b.alt = true
+		default:
+			return b, fmt.Errorf("unknown modifier %q in %q", modifier, s)
+		}
+	}
+	if err := b.key.UnmarshalText([]byte(parts[len(parts)-1])); err != nil {
+		return b, fmt.Errorf("unknown key %q", parts[len(parts)-1])
+	}
+	return b, nil
+}
+
+func (b keyBinding) String() string {
+	var s strings.Builder
+	if b.ctrl {
+		s.WriteString("ctrl+")
+	}
+	if b.shift {
+		s.WriteString("shift+")
+	}
+	if b.alt {
+		s.WriteString("alt+")
+	}
+	s.WriteString(keyLabel(b.key))
+	return s.String()
+}
+
+// modifiersHeld reports whether exactly b's modifiers are held.
+func (b keyBinding) modifiersHeld() bool {
+	ctrl := ebiten.IsKeyPressed(ebiten.KeyControl) || ebiten.IsKeyPressed(ebiten.KeyMeta)
+	return ctrl == b.ctrl && ebiten.IsKeyPressed(ebiten.KeyShift) == b.shift && ebiten.IsKeyPressed(ebiten.KeyAlt) == b.alt
+}
+
+// keymap is the keys bound to each action, by action name.
+type keymap map[string][]keyBinding
+
+// defaultKeymap returns the built in keymap.
+func defaultKeymap() keymap {
+	km := keymap{}
+	for _, a := range keyActions {
+		for _, s := range a.defaults {
+			b, err := parseKeyBinding(s)
+			if err != nil {
+				panic(err)
+			}
+			km[a.name] = append(km[a.name], b)
+		}
+	}
+	return km
+}
+
+// loadKeymap reads the keymap file, or the default one in the user's
+// configuration directory if filename is empty, over the defaults. A missing
+// default file just gives the defaults.
+func loadKeymap(filename string) (keymap, error) {
+	km := defaultKeymap()
+	explicit := filename != ""
+	if !explicit {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return km, nil
+		}
+		filename = filepath.Join(dir, keymapFile)
+	}
+	file, err := os.Open(filename)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		return km, nil
+	} else if err != nil {
+		return km, err
+	}
+	defer file.Close()
+	if err := parseKeymap(file, km); err != nil {
+		return km, fmt.Errorf("%s: %w", filename, err)
+	}
+	log.Printf("Loaded keymap %s", filename)
+	return km, nil
+}
+
+// parseKeymap reads "action key key…" lines into km, each replacing the
+// action's keys; an action with no keys is unbound. # starts a comment.
+func parseKeymap(reader io.Reader, km keymap) error {
+	scanner := bufio.NewScanner(reader)
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if !slices.ContainsFunc(keyActions, func(a keyAction) bool { return a.name == fields[0] }) {
+			return fmt.Errorf("line %d: unknown action %q", line, fields[0])
+		}
+		var bindings []keyBinding
+		for _, field := range fields[1:] {
+			b, err := parseKeyBinding(field)
+			if err != nil {
+				return fmt.Errorf("line %d: %w", line, err)
+			}
+			bindings = append(bindings, b)
+		}
+		km[fields[0]] = bindings
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return km.checkConflicts()
+}
+
+// checkConflicts reports a key bound to more than one action.
+func (km keymap) checkConflicts() error {
+	bound := map[keyBinding]string{}
+	for _, a := range keyActions {
+		for _, b := range km[a.name] {
+			if other, ok := bound[b]; ok {
+				return fmt.Errorf("%s is bound to both %s and %s", b, other, a.name)
+			}
+			bound[b] = a.name
+		}
+	}
+	return nil
+}
+
+// writeKeymap writes km in the keymap file format.
+func writeKeymap(w io.Writer, km keymap) error {
+	for _, a := range keyActions {
+		fields := []string{a.name}
+		for _, b := range km[a.name] {
+			fields = append(fields, b.String())
+		}
+		if _, err := fmt.Fprintf(w, "%s # %s\n", strings.Join(fields, " "), a.help); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pressed reports whether a key of action a was just pressed, or for a
+// repeating action is auto-repeating.
+func (km keymap) pressed(a keyAction) bool {
+	for _, b := range km[a.name] {
+		if !b.modifiersHeld() {
+			continue
+		}
+		if a.repeat && keyRepeating(b.key) || !a.repeat && inpututil.IsKeyJustPressed(b.key) {
+			return true
+		}
+	}
+	return false
+}
+
+// held returns the binding of action whose key and modifiers are held, or
+// nil.
+func (km keymap) held(action string) *keyBinding {
+	for _, b := range km[action] {
+		if b.modifiersHeld() && ebiten.IsKeyPressed(b.key) {
+			return &b
+		}
+	}
+	return nil
+}
+
+// keys describes the keys bound to an action.
+func (km keymap) keys(action string) string {
+	keys := make([]string, len(km[action]))
+	for i, b := range km[action] {
+		keys[i] = b.String()
+	}
+	if len(keys) == 0 {
+		return "unbound"
+	}
+	return strings.Join(keys, ", ")
+}
+
+// cheatsheet lists the active keyboard and mouse shortcuts.
+func (m *appModel) cheatsheet() string {
+	var s strings.Builder
+	for _, a := range keyActions {
+		fmt.Fprintf(&s, "%s\t%s\n", m.keymap.keys(a.name), a.help)
+	}
+	var classes []string
+	for i := range m.labels {
+		if key, ok := m.classKey(i); ok {
+			classes = append(classes, fmt.Sprintf("%s %s", keyLabel(key), m.labels[i]))
+		}
+	}
+	if len(classes) > 0 {
+		fmt.Fprintf(&s, "%s\t%s\n", "Class hotkeys", strings.Join(classes, ", "))
+	}
+	s.WriteString("Left drag\tDraw a region of the current class\n")
+	s.WriteString("Right click\tDelete a region, or re-tag it while holding a class hotkey\n")
+	s.WriteString("Middle drag\tPan; ctrl+wheel zooms around the cursor\n")
+	return s.String()
+}
+
+// runAction does what a keyboard action does, reporting false if it doesn't
+// apply now, so the key is left for something else.
+func (r *Root) runAction(context *guigui.Context, action string) bool {
+	m := &r.model
+	switch action {
+	case "next-image":
+		r.selectFile(m.visibleStep(1))
+	case "prev-image":
+		r.selectFile(m.visibleStep(-1))
+	case "prev-class":
+		if m.drawingIndex > 0 {
+			m.drawingIndex--
+		}
+	case "next-class":
+		if m.drawingIndex < len(m.labels)-1 {
+			m.drawingIndex++
+		}
+	case "class-palette":
+		r.palette.open(context, -1)
+	case "retag-palette":
+		if region := r.pane.editor.regionAtCursor(); region >= 0 {
+			r.palette.open(context, region)
+		}
+	case "next-unlabelled", "prev-unlabelled":
+		direction := 1
+		if action == "prev-unlabelled" {
+			direction = -1
+		}
+		// Find the next image that's not labeled
+		for step := direction; ; step += direction {
+			i := m.visibleStep(step)
+			if i < 0 {
+				break
+			}
+			if m.isUnlabelled(i) {
+				log.Printf("Found unlabeled image %s", m.files[i])
+				r.selectFile(i)
+				break
+			}
+		}
+	case "most-uncertain":
+		if i := m.mostUncertain(); i >= 0 {
+			log.Printf("Most uncertain unlabelled image is %s", m.files[i])
+			r.selectFile(i)
+		}
+	case "delete-region":
+		if i := r.pane.editor.regionAtCursor(); i >= 0 {
+			m.currentRegions.Remove(i)
+			m.regionsChanged()
+		}
+	case "undo":
+		m.undoRegions()
+	case "needs-review":
+		m.setStatus(StatusNeedsReview)
+	case "approve":
+		m.setStatus(StatusApproved)
+	case "reject":
+		m.setStatus(StatusRejected)
+		// Rejections usually need an explanation, so go straight to it.
+		context.SetFocused(&r.pane.commentInput, true)
+	case "clear-status":
+		m.setStatus(StatusUnlabelled)
+	case "toggle-empty":
+		m.toggleEmpty()
+	case "toggle-grid":
+		if m.view == viewGrid {
+			r.setView(viewEditor)
+		} else {
+			r.setView(viewGrid)
+		}
+	case "zoom-in":
+		r.pane.editor.zoomBy(1.25)
+	case "zoom-out":
+		r.pane.editor.zoomBy(1 / 1.25)
+	case "zoom-fit":
+		r.pane.editor.resetZoom()
+	case "keyframe":
+		if i := r.pane.editor.regionAtCursor(); i >= 0 {
+			m.markKeyframe(i)
+		}
+	case "new-track":
+		m.activeTrack = 0
+		log.Printf("The next keyframe starts a new track")
+	case "interpolate":
+		m.interpolateActiveTrack()
+	case "copy-previous", "copy-next":
+		step := -1
+		if action == "copy-next" {
+			step = 1
+		}
+		// Holding a class's hotkey copies just that class.
+		m.copyFromNeighbour(step, m.pressedClass(ebiten.IsKeyPressed, m.keymap.held(action)))
+	case "accept-suggestion", "reject-suggestion", "accept-suggestions", "reject-suggestions":
+		suggestions := m.shownSuggestions()
+		if action == "accept-suggestion" || action == "reject-suggestion" {
+			suggestions = nil
+			if s, ok := r.pane.editor.suggestionAtCursor(); ok {
+				suggestions = []Suggestion{s}
+			}
+		}
+		if strings.HasPrefix(action, "accept") {
+			m.acceptSuggestions(suggestions)
+		} else {
+			m.rejectSuggestions(suggestions)
+		}
+	case "follow":
+		if i := m.startFollowing(r.pane.editor.regionAtCursor()); i >= 0 {
+			r.selectFile(i)
+		}
+	case "accept-proposals":
+		if m.follow == nil {
+			return false
+		}
+		if i := m.acceptProposals(); i >= 0 {
+			r.selectFile(i)
+		}
+	case "stop-following":
+		if m.follow == nil {
+			return false
+		}
+		m.stopFollowing()
+	case "copy-regions":
+		m.copyRegions(r.pane.editor.regionAtCursor())
+	case "paste-regions":
+		m.pasteClipboard()
+	case "cheatsheet":
+		r.cheatsheet.SetOpen(!r.cheatsheet.IsOpen())
+	default:
+		return false
+	}
+	return true
+}
+
+// cheatsheetOverlay shows the active keyboard shortcuts.
+type cheatsheetOverlay struct {
+	guigui.DefaultWidget
+
+	model *appModel
+
+	popup   basicwidget.Popup
+	panel   basicwidget.Panel
+	content basicwidget.Text
+}
+
+func (c *cheatsheetOverlay) SetModel(m *appModel) {
+	c.model = m
+}
+
+func (c *cheatsheetOverlay) SetOpen(open bool) {
+	c.popup.SetOpen(open)
+}
+
+func (c *cheatsheetOverlay) IsOpen() bool {
+	return c.popup.IsOpen()
+}
+
+func (c *cheatsheetOverlay) Build(context *guigui.Context, adder *guigui.ChildAdder) error {
+	adder.AddWidget(&c.popup)
+	c.popup.SetContent(&c.panel)
+	c.popup.SetCloseByClickingOutside(true)
+	c.popup.SetAnimated(false)
+	c.panel.SetContent(&c.content)
+	c.panel.SetContentConstraints(basicwidget.PanelContentConstraintsFixedWidth)
+	if c.model != nil {
+		c.content.SetValue(c.model.cheatsheet())
+	}
+	c.content.SetMultiline(true)
+	c.content.SetTabWidth(float64(7 * basicwidget.UnitSize(context)))
+	return nil
+}
+
+func (c *cheatsheetOverlay) Layout(context *guigui.Context, widgetBounds *guigui.WidgetBounds, layouter *guigui.ChildLayouter) {
+	u := basicwidget.UnitSize(context)
+	app := context.AppBounds()
+	size := image.Pt(min(app.Dx()-2*u, 28*u), app.Dy()-2*u)
+	pos := image.Pt(app.Min.X+(app.Dx()-size.X)/2, app.Min.Y+u)
+	layouter.LayoutWidget(&c.popup, image.Rectangle{Min: pos, Max: pos.Add(size)})
+}
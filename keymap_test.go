@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+func TestParseKeyBinding(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    keyBinding
+		wantErr bool
+	}{
+		{in: "J", want: keyBinding{key: ebiten.KeyJ}},
+		{in: "Down", want: keyBinding{key: ebiten.KeyArrowDown}},
+		{in: "shift+N", want: keyBinding{key: ebiten.KeyN, shift: true}},
+		{in: "ctrl+shift+Z", want: keyBinding{key: ebiten.KeyZ, ctrl: true, shift: true}},
+		{in: "cmd+C", want: keyBinding{key: ebiten.KeyC, ctrl: true}},
+		{in: "Option+F1", want: keyBinding{key: ebiten.KeyF1, alt: true}},
+		{in: "hyper+J", wantErr: true},
+		{in: "ctrl+Nope", wantErr: true},
+		{in: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseKeyBinding(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseKeyBinding(%q) = %v, want an error", tt.in, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseKeyBinding(%q): %s", tt.in, err)
+			}
+			if got != tt.want {
+				t.Errorf("parseKeyBinding(%q) = %+v, want %+v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseKeymap(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		want    map[string][]string // changed actions and their keys
+		wantErr string
+	}{
+		{
+			name: "rebind",
+			file: "next-image Down S # comment\n\n# a comment line\n",
+			want: map[string][]string{"next-image": {"ArrowDown", "S"}},
+		},
+		{
+			name: "unbind",
+			file: "zoom-fit\n",
+			want: map[string][]string{"zoom-fit": nil},
+		},
+		{
+			name: "swap keys",
+			file: "approve X\nreject A\n",
+			want: map[string][]string{"approve": {"X"}, "reject": {"A"}},
+		},
+		{
+			name:    "unknown action",
+			file:    "fly-away F\n",
+			wantErr: `line 1: unknown action "fly-away"`,
+		},
+		{
+			name:    "unknown key",
+			file:    "\nundo ctrl+Nope\n",
+			wantErr: `line 2: unknown key "Nope"`,
+		},
+		{
+			name:    "conflict with a default",
+			file:    "approve J\n",
+			wantErr: "J is bound to both next-image and approve",
+		},
+		{
+			name:    "conflict within a line",
+			file:    "approve Q Q\n",
+			wantErr: "Q is bound to both approve and approve",
+		},
+		{
+			name: "modifiers tell bindings apart",
+			file: "approve shift+J\n",
+			want: map[string][]string{"approve": {"shift+J"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			km := defaultKeymap()
+			err := parseKeymap(strings.NewReader(tt.file), km)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("parseKeymap error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseKeymap: %s", err)
+			}
+			defaults := defaultKeymap()
+			for _, a := range keyActions {
+				want, changed := tt.want[a.name]
+				if !changed {
+					if !slices.Equal(km[a.name], defaults[a.name]) {
+						t.Errorf("%s changed to %v", a.name, km[a.name])
+					}
+					continue
+				}
+				var got []string
+				for _, b := range km[a.name] {
+					got = append(got, b.String())
+				}
+				if !slices.Equal(got, want) {
+					t.Errorf("%s = %v, want %v", a.name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDefaultKeymapHasNoConflicts(t *testing.T) {
+	if err := defaultKeymap().checkConflicts(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWriteKeymapRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeKeymap(&buf, defaultKeymap()); err != nil {
+		t.Fatal(err)
+	}
+	km := keymap{}
+	if err := parseKeymap(&buf, km); err != nil {
+		t.Fatalf("parsing the written keymap: %s", err)
+	}
+	if !maps.EqualFunc(km, defaultKeymap(), slices.Equal) {
+		t.Errorf("round trip gave %v, want %v", km, defaultKeymap())
+	}
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/AndreRenaud/fastmark/storage"
+)
+
+// tiffOrientation is the EXIF/TIFF tag giving how the stored pixels must be
+// transformed for display. Its values 1 to 8 are: identity, mirror
+// horizontally, rotate 180°, mirror vertically, transpose, rotate 90°
+// clockwise, transverse and rotate 90° anticlockwise.
+const tiffOrientation = 274
+
+// exifHeaderBytes is how much of a JPEG is read to find its orientation.
+const exifHeaderBytes = 256 << 10
+
+// exifOrientation returns the orientation recorded in a JPEG's EXIF block or
+// a TIFF's first IFD, or 1 if there is none.
+func exifOrientation(data []byte) int {
+	tiffData := data
+	if bytes.HasPrefix(data, []byte{0xff, 0xd8}) {
+		tiffData = jpegExif(data)
+	}
+	if len(tiffData) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch {
+	case bytes.HasPrefix(tiffData, []byte("II*\x00")):
+		order = binary.LittleEndian
+	case bytes.HasPrefix(tiffData, []byte("MM\x00*")):
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	tags, err := readTIFFTags(tiffData, order, int64(order.Uint32(tiffData[4:8])))
+	if err != nil || len(tags[tiffOrientation]) == 0 {
+		return 1
+	}
+	if o := int(tags[tiffOrientation][0]); o >= 1 && o <= 8 {
+		return o
+	}
+	return 1
+}
+
+// jpegExif returns the TIFF structure inside a JPEG's APP1 Exif segment.
+func jpegExif(data []byte) []byte {
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xff {
+			return nil
+		}
+		marker := data[i+1]
+		// Start of scan: the metadata segments are all before it.
+		if marker == 0xda || marker == 0xd9 {
+			return nil
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return nil
+		}
+		if segment := data[i+4 : end]; marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment[6:]
+		}
+		i = end
+	}
+	return nil
+}
+
+// readOrientation reads just enough of an image file to find its
+// orientation.
+func readOrientation(backend storage.Storage, filename string) (int, error) {
+	f, err := backend.Open(filename)
+	if err != nil {
+		return 1, err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	// A TIFF's first IFD may be anywhere in the file.
+	if !isTIFFFile(filename) {
+		r = io.LimitReader(f, exifHeaderBytes)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return 1, err
+	}
+	return exifOrientation(data), nil
+}
+
+// swapsAxes reports whether orientation o turns the image on its side.
+func swapsAxes(o int) bool {
+	return o >= 5 && o <= 8
+}
+
+// inverseOrientation returns the orientation undoing o.
+func inverseOrientation(o int) int {
+	switch o {
+	case 6:
+		return 8
+	case 8:
+		return 6
+	}
+	return o
+}
+
+// orientPoint maps a normalized point in the stored image to the displayed
+// image under orientation o.
+func orientPoint(o int, x, y float64) (float64, float64) {
+	switch o {
+	case 2:
+		return 1 - x, y
+	case 3:
+		return 1 - x, 1 - y
+	case 4:
+		return x, 1 - y
+	case 5:
+		return y, x
+	case 6:
+		return 1 - y, x
+	case 7:
+		return 1 - y, 1 - x
+	case 8:
+		return y, 1 - x
+	}
+	return x, y
+}
+
+// orientRegion maps a region from stored image coordinates to the image as
+// displayed under orientation o.
+func orientRegion(r Region, o int) Region {
+	r.xMid, r.yMid = orientPoint(o, r.xMid, r.yMid)
+	if swapsAxes(o) {
+		r.width, r.height = r.height, r.width
+	}
+	return r
+}
+
+// orientImage returns img transformed for display under orientation o.
+// Images with a plain pixel buffer keep their type, so 16-bit data survives.
+func orientImage(img image.Image, o int) image.Image {
+	if o <= 1 || o > 8 {
+		return img
+	}
+	pix, stride, bpp, ok := pixelBuffer(img)
+	if !ok {
+		return orientImage(toRGBA(img), o)
+	}
+
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	size := image.Pt(w, h)
+	if swapsAxes(o) {
+		size = image.Pt(h, w)
+	}
+	dst := newImageLike(img, image.Rectangle{Max: size})
+	dstPix, dstStride, _, _ := pixelBuffer(dst)
+	parallelRows(h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := range w {
+				dx, dy := orientPixel(o, x, y, w, h)
+				copy(dstPix[dy*dstStride+dx*bpp:][:bpp], pix[y*stride+x*bpp:][:bpp])
+			}
+		}
+	})
+	return dst
+}
+
+// pixelBuffer returns the pixels of the image types with one plain buffer,
+// and the bytes per pixel.
+func pixelBuffer(img image.Image) (pix []uint8, stride, bpp int, ok bool) {
+	switch img := img.(type) {
+	case *image.RGBA:
+		return img.Pix, img.Stride, 4, true
+	case *image.NRGBA:
+		return img.Pix, img.Stride, 4, true
+	case *image.Gray:
+		return img.Pix, img.Stride, 1, true
+	case *image.Gray16:
+		return img.Pix, img.Stride, 2, true
+	case *image.RGBA64:
+		return img.Pix, img.Stride, 8, true
+	case *image.NRGBA64:
+		return img.Pix, img.Stride, 8, true
+	}
+	return nil, 0, 0, false
+}
+
+// newImageLike returns a new image of the same type as img, which must be
+// one pixelBuffer accepts.
+func newImageLike(img image.Image, r image.Rectangle) image.Image {
+	switch img.(type) {
+	case *image.NRGBA:
+		return image.NewNRGBA(r)
+	case *image.Gray:
+		return image.NewGray(r)
+	case *image.Gray16:
+		return image.NewGray16(r)
+	case *image.RGBA64:
+		return image.NewRGBA64(r)
+	case *image.NRGBA64:
+		return image.NewNRGBA64(r)
+	}
+	return image.NewRGBA(r)
+}
+
+// orientPixel is orientPoint for the pixel at x, y of a w by h image.
+func orientPixel(o, x, y, w, h int) (int, int) {
+	switch o {
+	case 2:
+		return w - 1 - x, y
+	case 3:
+		return w - 1 - x, h - 1 - y
+	case 4:
+		return x, h - 1 - y
+	case 5:
+		return y, x
+	case 6:
+		return h - 1 - y, x
+	case 7:
+		return h - 1 - y, w - 1 - x
+	case 8:
+		return y, w - 1 - x
+	}
+	return x, y
+}
+
+// orientSuffix is added to the names of converted files until they are all
+// written and can replace the originals.
+const orientSuffix = ".orient"
+
+// convertLabelOrientation rewrites every label file, and the track boxes and
+// rejected suggestions in the status files, so their coordinates are
+// relative to the oriented image (toOriented) or the stored pixels, and
+// records the new convention in the dataset settings. Images without an
+// orientation are left alone. The files are replaced with replaceFiles, and
+// the setting only changed once they all have been, so a failure never
+// leaves files converted without the setting saying so.
+func convertLabelOrientation(backend storage.Storage, toOriented bool) error {
+	// Edits still being saved would undo the conversion.
+	labelWrites.flush()
+	settings, err := LoadDatasetSettings(backend)
+	if err != nil {
+		return err
+	}
+	if settings.OrientedLabels == toOriented {
+		return fmt.Errorf("labels are already relative to the %s image", settings.orientationName())
+	}
+	files, err := listImages(backend)
+	if err != nil {
+		return err
+	}
+	changed := map[string]string{}
+	converted := 0
+	for _, file := range files {
+		// Video frames have no EXIF orientation.
+		if !isImageFile(file) {
+			continue
+		}
+		o, err := readOrientation(backend, imagePath(file))
+		if err != nil {
+			return err
+		}
+		if o == 1 {
+			continue
+		}
+		// Oriented labels are turned back into stored ones by the inverse.
+		if !toOriented {
+			o = inverseOrientation(o)
+		}
+		if _, err := backend.Stat(labelFileName(file)); err == nil {
+			text, err := readFileText(backend, labelFileName(file))
+			if err != nil {
+				return err
+			}
+			regions := parseRegions(strings.NewReader(text), labelFileName(file))
+			for i, r := range regions {
+				regions[i] = orientRegion(r, o)
+			}
+			if len(regions) > 0 {
+				changed[labelFileName(file)] = regionsText(regions)
+				converted++
+			}
+		}
+		if _, err := backend.Stat(stateFileName(file)); err == nil {
+			state, err := LoadImageState(backend, stateFileName(file))
+			if err != nil {
+				return err
+			}
+			if len(state.Tracks) == 0 && len(state.Rejected) == 0 {
+				continue
+			}
+			for i, t := range state.Tracks {
+				state.Tracks[i].Region = orientRegion(t.Region, o)
+			}
+			for i, r := range state.Rejected {
+				state.Rejected[i] = orientRegion(r, o)
+			}
+			changed[stateFileName(file)] = state.text()
+		}
+	}
+	if err := replaceFiles(backend, changed, orientSuffix); err != nil {
+		return err
+	}
+	settings.OrientedLabels = toOriented
+	if err := settings.Save(); err != nil {
+		return err
+	}
+	log.Printf("Converted %d label files to be relative to the %s image", converted, settings.orientationName())
+	return nil
+}
+
+// orientationMap remembers the EXIF orientation of each decoded file, so
+// regions can be drawn over the rotated image. Decoders record into it from
+// their own goroutines.
+type orientationMap struct {
+	mu           sync.Mutex
+	orientations map[string]int
+}
+
+func (o *orientationMap) set(file string, orientation int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.orientations == nil {
+		o.orientations = map[string]int{}
+	}
+	o.orientations[file] = orientation
+}
+
+// get returns file's orientation, or 1 if it hasn't been decoded.
+func (o *orientationMap) get(file string) int {
+	orientation, _ := o.lookup(file)
+	return orientation
+}
+
+// lookup returns file's orientation, reporting whether it is known.
+func (o *orientationMap) lookup(file string) (int, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if orientation, ok := o.orientations[file]; ok {
+		return orientation, true
+	}
+	return 1, false
+}
+
+func (o *orientationMap) reset() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	clear(o.orientations)
+}
+
+// labelOrientation is the orientation taking file's label coordinates to the
+// displayed image: none if the dataset's labels are already oriented.
+func (m *appModel) labelOrientation(file string) int {
+	if m.settings.OrientedLabels {
+		return 1
+	}
+	return m.orientations.get(file)
+}
+
+// loadOrientation reads file's orientation from its header if it hasn't
+// been decoded yet, so regions copied to or from it before it is shown are
+// converted correctly.
+func (m *appModel) loadOrientation(file string) {
+	if m.settings.OrientedLabels || !isImageFile(file) {
+		return
+	}
+	if _, ok := m.orientations.lookup(file); ok {
+		return
+	}
+	orientation, err := readOrientation(m.backend, imagePath(file))
+	if err != nil {
+		log.Printf("Error reading orientation of %s: %s", file, err)
+		return
+	}
+	m.orientations.set(file, orientation)
+}
+
+// displayRegion maps a region from file's label file to the displayed image.
+func (m *appModel) displayRegion(file string, r Region) Region {
+	return orientRegion(r, m.labelOrientation(file))
+}
+
+// labelRegion maps a region drawn on the displayed image back to file's
+// label coordinates.
+func (m *appModel) labelRegion(file string, r Region) Region {
+	return orientRegion(r, inverseOrientation(m.labelOrientation(file)))
+}
+
+// setOrientedLabels converts the dataset's labels in the background to be
+// relative to the oriented image, or to the stored pixels, as
+// -convert-orientation does. The labels are locked until the result arrives
+// on orientResults.
+func (m *appModel) setOrientedLabels(oriented bool) {
+	if m.settings.OrientedLabels == oriented || m.labelsLocked() || m.splitting {
+		return
+	}
+	m.reorienting = true
+	backend := m.backend
+	go func() {
+		m.orientResults <- orientResult{oriented: oriented, err: convertLabelOrientation(backend, oriented)}
+	}()
+}
+
+// orientResult is the outcome of converting the labels' orientation in the
+// background.
+type orientResult struct {
+	oriented bool
+	err      error
+}
+
+// reorientDone records the result of a conversion. The settings are saved
+// again, after any saved while it ran, which still had the old convention.
+func (m *appModel) reorientDone(res orientResult) {
+	m.reorienting = false
+	if res.err != nil {
+		log.Printf("Error converting label orientation: %s", res.err)
+		return
+	}
+	m.settings.OrientedLabels = res.oriented
+	if err := m.settings.Save(); err != nil {
+		log.Printf("Error saving dataset settings: %s", err)
+	}
+}
+
+// currentFile is the selected file's name, or "" if there is none.
+func (m *appModel) currentFile() string {
+	if m.selectedIndex < 0 || m.selectedIndex >= len(m.files) {
+		return ""
+	}
+	return m.files[m.selectedIndex]
+}
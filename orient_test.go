@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"math"
+	"testing"
+)
+
+func TestOrientPoint(t *testing.T) {
+	// Where the stored point (0.2, 0.1) is displayed under each orientation.
+	want := map[int][2]float64{
+		1: {0.2, 0.1},
+		2: {0.8, 0.1},
+		3: {0.8, 0.9},
+		4: {0.2, 0.9},
+		5: {0.1, 0.2},
+		6: {0.9, 0.2},
+		7: {0.9, 0.8},
+		8: {0.1, 0.8},
+	}
+	const w, h = 10, 20
+	for o := 1; o <= 8; o++ {
+		x, y := orientPoint(o, 0.2, 0.1)
+		if math.Abs(x-want[o][0]) > 1e-9 || math.Abs(y-want[o][1]) > 1e-9 {
+			t.Errorf("orientPoint(%d) = %v, %v, want %v", o, x, y, want[o])
+		}
+		if bx, by := orientPoint(inverseOrientation(o), x, y); math.Abs(bx-0.2) > 1e-9 || math.Abs(by-0.1) > 1e-9 {
+			t.Errorf("orientation %d and its inverse give %v, %v", o, bx, by)
+		}
+		// The pixel mapping agrees with the point one at pixel centres.
+		dw, dh := w, h
+		if swapsAxes(o) {
+			dw, dh = h, w
+		}
+		px, py := orientPixel(o, 2, 3, w, h)
+		cx, cy := orientPoint(o, 2.5/w, 3.5/h)
+		if px != int(cx*float64(dw)) || py != int(cy*float64(dh)) {
+			t.Errorf("orientPixel(%d) = %d, %d, want %v, %v", o, px, py, cx*float64(dw), cy*float64(dh))
+		}
+	}
+}
+
+func TestOrientRegion(t *testing.T) {
+	r := Region{xMid: 0.25, yMid: 0.5, width: 0.1, height: 0.3, index: 2}
+	tests := []struct {
+		o    int
+		want Region
+	}{
+		{1, r},
+		{3, Region{xMid: 0.75, yMid: 0.5, width: 0.1, height: 0.3, index: 2}},
+		{6, Region{xMid: 0.5, yMid: 0.25, width: 0.3, height: 0.1, index: 2}},
+		{8, Region{xMid: 0.5, yMid: 0.75, width: 0.3, height: 0.1, index: 2}},
+	}
+	for _, tt := range tests {
+		got := orientRegion(r, tt.o)
+		for _, d := range []float64{got.xMid - tt.want.xMid, got.yMid - tt.want.yMid, got.width - tt.want.width, got.height - tt.want.height} {
+			if math.Abs(d) > 1e-9 || got.index != tt.want.index {
+				t.Errorf("orientRegion(%d) = %+v, want %+v", tt.o, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestOrientImage(t *testing.T) {
+	// 0 1 2
+	// 3 4 5
+	src := &image.Gray{Pix: []byte{0, 1, 2, 3, 4, 5}, Stride: 3, Rect: image.Rect(0, 0, 3, 2)}
+	tests := []struct {
+		o    int
+		size image.Point
+		want []byte
+	}{
+		{1, image.Pt(3, 2), []byte{0, 1, 2, 3, 4, 5}},
+		{2, image.Pt(3, 2), []byte{2, 1, 0, 5, 4, 3}},
+		{3, image.Pt(3, 2), []byte{5, 4, 3, 2, 1, 0}},
+		{4, image.Pt(3, 2), []byte{3, 4, 5, 0, 1, 2}},
+		{5, image.Pt(2, 3), []byte{0, 3, 1, 4, 2, 5}},
+		{6, image.Pt(2, 3), []byte{3, 0, 4, 1, 5, 2}},
+		{7, image.Pt(2, 3), []byte{5, 2, 4, 1, 3, 0}},
+		{8, image.Pt(2, 3), []byte{2, 5, 1, 4, 0, 3}},
+	}
+	for _, tt := range tests {
+		got, ok := orientImage(src, tt.o).(*image.Gray)
+		if !ok {
+			t.Errorf("orientImage(%d) changed the image type", tt.o)
+			continue
+		}
+		if got.Bounds().Size() != tt.size || !bytes.Equal(got.Pix, tt.want) {
+			t.Errorf("orientImage(%d) = %v %v, want %v %v", tt.o, got.Bounds().Size(), got.Pix, tt.size, tt.want)
+		}
+	}
+}
+
+func TestExifOrientation(t *testing.T) {
+	orientedTIFF := func(order testOrder, o uint32) []byte {
+		return encodeTIFF(order, []testTag{{tiffOrientation, 3, []uint32{o}}}, nil)
+	}
+	// jpeg wraps TIFF data in an Exif APP1 segment after another segment.
+	jpeg := func(exif []byte) []byte {
+		data := []byte{0xff, 0xd8, 0xff, 0xe0, 0x00, 0x04, 'J', 'F'}
+		segment := append([]byte("Exif\x00\x00"), exif...)
+		data = append(data, 0xff, 0xe1)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(segment)+2))
+		data = append(data, segment...)
+		return append(data, 0xff, 0xda, 0x00, 0x02)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{"TIFF", orientedTIFF(binary.LittleEndian, 6), 6},
+		{"big-endian TIFF", orientedTIFF(binary.BigEndian, 3), 3},
+		{"TIFF without the tag", encodeTIFF(binary.LittleEndian, []testTag{{tiffImageWidth, 3, []uint32{4}}}, nil), 1},
+		{"invalid orientation", orientedTIFF(binary.LittleEndian, 9), 1},
+		{"JPEG", jpeg(orientedTIFF(binary.BigEndian, 8)), 8},
+		{"JPEG without Exif", []byte{0xff, 0xd8, 0xff, 0xda, 0x00, 0x02}, 1},
+		{"truncated JPEG", jpeg(orientedTIFF(binary.BigEndian, 8))[:12], 1},
+		{"PNG", []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x00"), 1},
+	}
+	for _, tt := range tests {
+		if got := exifOrientation(tt.data); got != tt.want {
+			t.Errorf("%s: exifOrientation = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
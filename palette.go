@@ -0,0 +1,250 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"image"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/guigui-gui/guigui"
+	"github.com/guigui-gui/guigui/basicwidget"
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+)
+
+// matchClasses returns the classes matching a type-ahead query, best first:
+// the class of that number or name, then names starting with the query, then
+// names with a word starting with it, then names containing it, and lastly
+// names containing its letters in order. Ties keep class order.
+func matchClasses(names []string, query string) []int {
+	query = strings.ToLower(strings.TrimSpace(query))
+	type match struct{ class, score int }
+	var matches []match
+	for i, name := range names {
+		name = strings.ToLower(name)
+		score := -1
+		switch {
+		case query == "" || query == name || query == strconv.Itoa(i):
+			score = 0
+		case strings.HasPrefix(name, query):
+			score = 1
+		case wordPrefix(name, query):
+			score = 2
+		case strings.Contains(name, query):
+			score = 3
+		case subsequence(name, query):
+			score = 4
+		}
+		if score >= 0 {
+			matches = append(matches, match{i, score})
+		}
+	}
+	slices.SortStableFunc(matches, func(a, b match) int { return cmp.Compare(a.score, b.score) })
+	classes := make([]int, len(matches))
+	for i, m := range matches {
+		classes[i] = m.class
+	}
+	return classes
+}
+
+// wordPrefix reports whether a word of name, after a space, _, - or ., starts
+// with query.
+func wordPrefix(name, query string) bool {
+	for i := range len(name) {
+		if strings.ContainsRune(" _-.", rune(name[i])) && strings.HasPrefix(name[i+1:], query) {
+			return true
+		}
+	}
+	return false
+}
+
+// subsequence reports whether name contains query's characters in order.
+func subsequence(name, query string) bool {
+	for _, c := range query {
+		i := strings.IndexRune(name, c)
+		if i < 0 {
+			return false
+		}
+		name = name[i+len(string(c)):]
+	}
+	return true
+}
+
+// retagRegion changes the class of the selected file's region i.
+func (m *appModel) retagRegion(i, class int) {
+	if i < 0 || i >= len(m.currentRegions.Regions) {
+		return
+	}
+	m.currentRegions.Regions[i].index = class
+	m.currentRegions.SaveAsync()
+	m.regionsChanged()
+}
+
+// classPalette is a popup for picking a class by typing part of its name or
+// its number: the class to draw, or the new class of a region.
+type classPalette struct {
+	guigui.DefaultWidget
+
+	popup   basicwidget.Popup
+	content classPaletteContent
+}
+
+// classPaletteContent is the palette's query box and the classes matching it.
+type classPaletteContent struct {
+	guigui.DefaultWidget
+
+	model *appModel
+
+	queryInput basicwidget.TextInput
+	list       basicwidget.List[int]
+	hintText   basicwidget.Text
+
+	// region is the selected file's region being re-tagged, or -1 to pick
+	// the drawing class.
+	region       int
+	matches      []int
+	highlight    int
+	highlighting bool
+
+	listItems []basicwidget.ListItem[int]
+	colItems  []guigui.LinearLayoutItem
+
+	close func()
+}
+
+func (p *classPalette) SetModel(m *appModel) {
+	p.content.model = m
+}
+
+// open shows the palette, to re-tag region, or to pick the drawing class if
+// region is -1.
+func (p *classPalette) open(context *guigui.Context, region int) {
+	p.content.region = region
+	p.content.highlight = 0
+	p.content.queryInput.ForceSetValue("")
+	p.popup.SetOpen(true)
+	context.SetFocused(&p.content.queryInput, true)
+}
+
+func (p *classPalette) IsOpen() bool {
+	return p.popup.IsOpen()
+}
+
+func (p *classPalette) Build(context *guigui.Context, adder *guigui.ChildAdder) error {
+	adder.AddWidget(&p.popup)
+	p.content.close = func() {
+		p.popup.SetOpen(false)
+	}
+	p.popup.SetContent(&p.content)
+	p.popup.SetCloseByClickingOutside(true)
+	p.popup.SetAnimated(false)
+	return nil
+}
+
+func (p *classPalette) Layout(context *guigui.Context, widgetBounds *guigui.WidgetBounds, layouter *guigui.ChildLayouter) {
+	u := basicwidget.UnitSize(context)
+	app := context.AppBounds()
+	size := image.Pt(14*u, 12*u)
+	pos := image.Pt(app.Min.X+(app.Dx()-size.X)/2, app.Min.Y+app.Dy()/6)
+	layouter.LayoutWidget(&p.popup, image.Rectangle{Min: pos, Max: pos.Add(size)})
+}
+
+// choose applies the chosen class and closes the palette.
+func (c *classPaletteContent) choose(class int) {
+	m := c.model
+	if c.region >= 0 {
+		m.retagRegion(c.region, class)
+	} else {
+		m.drawingIndex = class
+	}
+	c.close()
+}
+
+func (c *classPaletteContent) WriteStateKey(context *guigui.Context, w *guigui.StateKeyWriter) {
+	w.WriteInt(c.highlight)
+	w.WriteInt(c.region)
+}
+
+func (c *classPaletteContent) Build(context *guigui.Context, adder *guigui.ChildAdder) error {
+	adder.AddWidget(&c.queryInput)
+	adder.AddWidget(&c.list)
+	adder.AddWidget(&c.hintText)
+
+	m := c.model
+	if m == nil {
+		return nil
+	}
+	c.matches = matchClasses(m.labels, c.queryInput.Value())
+	c.highlight = min(max(c.highlight, 0), max(len(c.matches)-1, 0))
+
+	c.queryInput.SetPlaceholder("Class name or number")
+	c.queryInput.OnValueChanged(func(context *guigui.Context, text string, committed bool) {
+		c.highlight = 0
+	})
+	c.queryInput.OnHandleButtonInput(func(context *guigui.Context, widgetBounds *guigui.WidgetBounds) guigui.HandleInputResult {
+		switch {
+		case inpututil.IsKeyJustPressed(ebiten.KeyEnter):
+			if c.highlight < len(c.matches) {
+				c.choose(c.matches[c.highlight])
+			}
+		case inpututil.IsKeyJustPressed(ebiten.KeyEscape):
+			c.close()
+		case keyRepeating(ebiten.KeyDown):
+			c.highlight = min(c.highlight+1, len(c.matches)-1)
+		case keyRepeating(ebiten.KeyUp):
+			c.highlight = max(c.highlight-1, 0)
+		default:
+			return guigui.HandleInputResult{}
+		}
+		return guigui.HandleInputByWidget(&c.queryInput)
+	})
+
+	c.listItems = slices.Delete(c.listItems, 0, len(c.listItems))
+	for _, class := range c.matches {
+		item := basicwidget.ListItem[int]{
+			Text:      fmt.Sprintf("%d %s", class, m.labelName(class)),
+			TextStyle: basicwidget.TextStyle{Color: m.classColor(class)},
+			Value:     class,
+		}
+		if key, ok := m.classKey(class); ok {
+			item.KeyText = keyLabel(key)
+		}
+		c.listItems = append(c.listItems, item)
+	}
+	c.list.SetItems(c.listItems)
+	// Following the highlight selects an item too, which isn't a choice.
+	c.highlighting = true
+	c.list.SelectItemByIndex(c.highlight)
+	c.list.EnsureItemVisibleByIndex(c.highlight)
+	c.highlighting = false
+	c.list.OnItemSelected(func(context *guigui.Context, index int) {
+		if item, ok := c.list.ItemByIndex(index); ok && !c.highlighting {
+			c.choose(item.Value)
+		}
+	})
+
+	hint := "Enter draws with the class"
+	if c.region >= 0 {
+		hint = fmt.Sprintf("Enter re-tags region %d", c.region)
+	}
+	c.hintText.SetValue(hint + "; escape closes")
+	return nil
+}
+
+func (c *classPaletteContent) Layout(context *guigui.Context, widgetBounds *guigui.WidgetBounds, layouter *guigui.ChildLayouter) {
+	u := basicwidget.UnitSize(context)
+	c.colItems = slices.Delete(c.colItems, 0, len(c.colItems))
+	c.colItems = append(c.colItems,
+		guigui.LinearLayoutItem{Widget: &c.queryInput},
+		guigui.LinearLayoutItem{Widget: &c.list, Size: guigui.FlexibleSize(1)},
+		guigui.LinearLayoutItem{Widget: &c.hintText},
+	)
+	(guigui.LinearLayout{
+		Direction: guigui.LayoutDirectionVertical,
+		Items:     c.colItems,
+		Gap:       u / 4,
+		Padding:   guigui.Padding{Start: u / 2, Top: u / 2, End: u / 2, Bottom: u / 2},
+	}).LayoutWidgets(context, widgetBounds.Bounds(), layouter)
+}
@@ -21,19 +21,62 @@ type editorPane struct {
 	changeDirButton      basicwidget.Button
 	contrastCheckbox     basicwidget.Checkbox
 	contrastLabel        basicwidget.Text
+	clipSelect           basicwidget.Select[float64]
+	brightnessLabel      basicwidget.Text
+	brightnessSlider     basicwidget.Slider
+	gammaLabel           basicwidget.Text
+	gammaSlider          basicwidget.Slider
+	claheCheckbox        basicwidget.Checkbox
+	claheLabel           basicwidget.Text
+	autoWindowCheckbox   basicwidget.Checkbox
+	autoWindowLabel      basicwidget.Text
+	levelLabel           basicwidget.Text
+	levelSlider          basicwidget.Slider
+	windowLabel          basicwidget.Text
+	windowSlider         basicwidget.Slider
 	backendText          basicwidget.Text
 	currentFileText      clickableText
 	regionsText          basicwidget.Text
+	statusText           basicwidget.Text
+	commentInput         basicwidget.TextInput
 	drawingLabelText     basicwidget.Text
 	editor               regionEditor
 	helpText             basicwidget.Text
 	summaryText          basicwidget.Text
 	categoryText         basicwidget.Text
 	updateMetadataButton basicwidget.Button
+	orientationLabel     basicwidget.Text
+	orientationSelect    basicwidget.Select[bool]
+	carryCheckbox        basicwidget.Checkbox
+	carryLabel           basicwidget.Text
+	compareCheckbox      basicwidget.Checkbox
+	compareLabel         basicwidget.Text
+	iouLabel             basicwidget.Text
+	iouSlider            basicwidget.Slider
+	predictionsLabel     basicwidget.Text
+	predictionsInput     basicwidget.TextInput
+	thresholdLabel       basicwidget.Text
+	thresholdSlider      basicwidget.Slider
+	runModelButton       basicwidget.Button
+	runAllButton         basicwidget.Button
+	modelStatusText      basicwidget.Text
+	splitInput           basicwidget.TextInput
+	splitButton          basicwidget.Button
+	splitStatusText      basicwidget.Text
+	remapInput           basicwidget.TextInput
+	previewRemapButton   basicwidget.Button
+	remapButton          basicwidget.Button
+	remapStatusText      basicwidget.Text
 
 	colItems       []guigui.LinearLayoutItem
 	toolbarItems   []guigui.LinearLayoutItem
+	adjustItems    []guigui.LinearLayoutItem
+	windowItems    []guigui.LinearLayoutItem
+	statusRowItems []guigui.LinearLayoutItem
 	buttonRowItems []guigui.LinearLayoutItem
+	suggestItems   []guigui.LinearLayoutItem
+	splitItems     []guigui.LinearLayoutItem
+	remapItems     []guigui.LinearLayoutItem
 }
 
 func (p *editorPane) SetModel(m *appModel) {
@@ -44,15 +87,52 @@ func (p *editorPane) Build(context *guigui.Context, adder *guigui.ChildAdder) er
 	adder.AddWidget(&p.changeDirButton)
 	adder.AddWidget(&p.contrastCheckbox)
 	adder.AddWidget(&p.contrastLabel)
+	adder.AddWidget(&p.clipSelect)
+	adder.AddWidget(&p.brightnessLabel)
+	adder.AddWidget(&p.brightnessSlider)
+	adder.AddWidget(&p.gammaLabel)
+	adder.AddWidget(&p.gammaSlider)
+	adder.AddWidget(&p.claheCheckbox)
+	adder.AddWidget(&p.claheLabel)
+	adder.AddWidget(&p.autoWindowCheckbox)
+	adder.AddWidget(&p.autoWindowLabel)
+	adder.AddWidget(&p.levelLabel)
+	adder.AddWidget(&p.levelSlider)
+	adder.AddWidget(&p.windowLabel)
+	adder.AddWidget(&p.windowSlider)
 	adder.AddWidget(&p.backendText)
 	adder.AddWidget(&p.currentFileText)
 	adder.AddWidget(&p.regionsText)
+	adder.AddWidget(&p.statusText)
+	adder.AddWidget(&p.commentInput)
 	adder.AddWidget(&p.drawingLabelText)
 	adder.AddWidget(&p.editor)
 	adder.AddWidget(&p.helpText)
 	adder.AddWidget(&p.summaryText)
 	adder.AddWidget(&p.categoryText)
 	adder.AddWidget(&p.updateMetadataButton)
+	adder.AddWidget(&p.orientationLabel)
+	adder.AddWidget(&p.orientationSelect)
+	adder.AddWidget(&p.carryCheckbox)
+	adder.AddWidget(&p.carryLabel)
+	adder.AddWidget(&p.compareCheckbox)
+	adder.AddWidget(&p.compareLabel)
+	adder.AddWidget(&p.iouLabel)
+	adder.AddWidget(&p.iouSlider)
+	adder.AddWidget(&p.predictionsLabel)
+	adder.AddWidget(&p.predictionsInput)
+	adder.AddWidget(&p.thresholdLabel)
+	adder.AddWidget(&p.thresholdSlider)
+	adder.AddWidget(&p.runModelButton)
+	adder.AddWidget(&p.runAllButton)
+	adder.AddWidget(&p.modelStatusText)
+	adder.AddWidget(&p.splitInput)
+	adder.AddWidget(&p.splitButton)
+	adder.AddWidget(&p.splitStatusText)
+	adder.AddWidget(&p.remapInput)
+	adder.AddWidget(&p.previewRemapButton)
+	adder.AddWidget(&p.remapButton)
+	adder.AddWidget(&p.remapStatusText)
 
 	m := p.model
 	if m == nil {
@@ -64,13 +144,101 @@ func (p *editorPane) Build(context *guigui.Context, adder *guigui.ChildAdder) er
 		m.selectDirectory()
 	})
 
-	p.contrastCheckbox.SetValue(m.autoContrast)
+	p.contrastCheckbox.SetValue(m.adjust.autoContrast)
 	p.contrastCheckbox.OnValueChanged(func(context *guigui.Context, value bool) {
-		m.autoContrast = value
-		m.regenerateDisplayImage()
+		a := m.adjust
+		a.autoContrast = value
+		m.setAdjust(a)
 	})
 	p.contrastLabel.SetValue("Auto contrast")
 	p.contrastLabel.SetVerticalAlign(basicwidget.VerticalAlignMiddle)
+	p.clipSelect.SetItems([]basicwidget.SelectItem[float64]{
+		{Text: "Min/max", Value: 0},
+		{Text: "Clip 0.5%", Value: 0.5},
+		{Text: "Clip 1%", Value: 1},
+		{Text: "Clip 2%", Value: 2},
+	})
+	p.clipSelect.SelectItemByValue(m.adjust.clip)
+	context.SetEnabled(&p.clipSelect, m.adjust.autoContrast)
+	p.clipSelect.OnItemSelected(func(context *guigui.Context, index int) {
+		if item, ok := p.clipSelect.ItemByIndex(index); ok {
+			a := m.adjust
+			a.clip = item.Value
+			m.setAdjust(a)
+		}
+	})
+
+	p.brightnessLabel.SetValue(fmt.Sprintf("Brightness %+d%%", m.adjust.brightness))
+	p.brightnessLabel.SetVerticalAlign(basicwidget.VerticalAlignMiddle)
+	p.brightnessSlider.SetMinimumValue(-100)
+	p.brightnessSlider.SetMaximumValue(100)
+	p.brightnessSlider.SetStep(5)
+	p.brightnessSlider.SetValue(m.adjust.brightness)
+	p.brightnessSlider.OnValueChanged(func(context *guigui.Context, value int) {
+		a := m.adjust
+		a.brightness = value
+		m.setAdjust(a)
+	})
+	p.gammaLabel.SetValue(fmt.Sprintf("Gamma %.2f", float64(m.adjust.gamma)/100))
+	p.gammaLabel.SetVerticalAlign(basicwidget.VerticalAlignMiddle)
+	p.gammaSlider.SetMinimumValue(20)
+	p.gammaSlider.SetMaximumValue(300)
+	p.gammaSlider.SetStep(5)
+	p.gammaSlider.SetValue(m.adjust.gamma)
+	p.gammaSlider.OnValueChanged(func(context *guigui.Context, value int) {
+		a := m.adjust
+		a.gamma = value
+		m.setAdjust(a)
+	})
+	p.claheCheckbox.SetValue(m.adjust.clahe)
+	p.claheCheckbox.OnValueChanged(func(context *guigui.Context, value bool) {
+		a := m.adjust
+		a.clahe = value
+		m.setAdjust(a)
+	})
+	p.claheLabel.SetValue("CLAHE")
+	p.claheLabel.SetVerticalAlign(basicwidget.VerticalAlignMiddle)
+
+	// Window and level only apply to 16-bit images.
+	highDepth := m.currentImage != nil && isHighDepth(m.currentImage)
+	autoWindow := m.adjust.window == 0
+	p.autoWindowCheckbox.SetValue(autoWindow)
+	p.autoWindowCheckbox.OnValueChanged(func(context *guigui.Context, value bool) {
+		a := m.adjust
+		a.window, a.level = 0, 0
+		if !value {
+			a.window, a.level = 0xffff, 0x8000
+		}
+		m.setAdjust(a)
+	})
+	p.autoWindowLabel.SetValue("Auto 16-bit window")
+	p.autoWindowLabel.SetVerticalAlign(basicwidget.VerticalAlignMiddle)
+	p.levelLabel.SetValue(fmt.Sprintf("Level %d", m.adjust.level))
+	p.levelLabel.SetVerticalAlign(basicwidget.VerticalAlignMiddle)
+	p.levelSlider.SetMinimumValue(0)
+	p.levelSlider.SetMaximumValue(0xffff)
+	p.levelSlider.SetStep(16)
+	p.levelSlider.SetValue(m.adjust.level)
+	p.levelSlider.OnValueChanged(func(context *guigui.Context, value int) {
+		a := m.adjust
+		a.level = value
+		m.setAdjust(a)
+	})
+	p.windowLabel.SetValue(fmt.Sprintf("Window %d", m.adjust.window))
+	p.windowLabel.SetVerticalAlign(basicwidget.VerticalAlignMiddle)
+	p.windowSlider.SetMinimumValue(16)
+	p.windowSlider.SetMaximumValue(0xffff)
+	p.windowSlider.SetStep(16)
+	p.windowSlider.SetValue(m.adjust.window)
+	p.windowSlider.OnValueChanged(func(context *guigui.Context, value int) {
+		a := m.adjust
+		a.window = value
+		m.setAdjust(a)
+	})
+	context.SetEnabled(&p.autoWindowCheckbox, highDepth)
+	for _, w := range []guigui.Widget{&p.levelSlider, &p.windowSlider} {
+		context.SetEnabled(w, highDepth && !autoWindow)
+	}
 
 	if m.backend != nil {
 		p.backendText.SetValue(m.backend.Describe())
@@ -97,12 +265,30 @@ func (p *editorPane) Build(context *guigui.Context, adder *guigui.ChildAdder) er
 	}
 	p.regionsText.SetValue(fmt.Sprintf("Regions: %s", regionSummary))
 
-	p.drawingLabelText.SetValue(fmt.Sprintf("Drawing label: %d %s (Press 1-9 to select new type)", m.drawingIndex, m.labelName(m.drawingIndex)))
-	p.drawingLabelText.SetColor(RegionIndexColor(m.drawingIndex))
+	status := effectiveStatus(m.currentState, len(m.currentRegions.Regions))
+	if m.currentState.Empty {
+		p.statusText.SetValue(fmt.Sprintf("Status: %s (no objects)", status))
+	} else {
+		p.statusText.SetValue(fmt.Sprintf("Status: %s", status))
+	}
+	p.statusText.SetColor(status.Color())
+	p.statusText.SetVerticalAlign(basicwidget.VerticalAlignMiddle)
+	p.commentInput.SetPlaceholder("Review comment")
+	p.commentInput.SetValue(m.currentState.Comment)
+	p.commentInput.OnValueChanged(func(context *guigui.Context, text string, committed bool) {
+		if committed {
+			m.setReviewComment(text)
+		}
+	})
+
+	p.drawingLabelText.SetValue(fmt.Sprintf("Drawing label: %d %s (Press a class's hotkey, or %s to search, to select new type)", m.drawingIndex, m.labelName(m.drawingIndex), m.keymap.keys("class-palette")))
+	p.drawingLabelText.SetColor(m.classColor(m.drawingIndex))
 
 	p.editor.SetModel(m)
 
-	p.helpText.SetValue("Press n to find next unlabeled image")
+	p.helpText.SetValue(fmt.Sprintf("Press %s to find next unlabeled image; %s: needs review, %s: approve, %s: reject, %s: clear status, %s: no objects; %s: all shortcuts",
+		m.keymap.keys("next-unlabelled"), m.keymap.keys("needs-review"), m.keymap.keys("approve"), m.keymap.keys("reject"),
+		m.keymap.keys("clear-status"), m.keymap.keys("toggle-empty"), m.keymap.keys("cheatsheet")))
 
 	meta := m.metadataSnapshot()
 	p.summaryText.SetValue(meta.Summary())
@@ -114,6 +300,132 @@ func (p *editorPane) Build(context *guigui.Context, adder *guigui.ChildAdder) er
 		m.startMetadataScan()
 	})
 
+	p.predictionsLabel.SetValue("Predictions")
+	p.predictionsLabel.SetVerticalAlign(basicwidget.VerticalAlignMiddle)
+	p.predictionsInput.SetPlaceholder(defaultPredictionsDir)
+	p.predictionsInput.SetValue(m.settings.PredictionsDir)
+	p.predictionsInput.OnValueChanged(func(context *guigui.Context, text string, committed bool) {
+		if committed {
+			m.setPredictionsDir(text)
+		}
+	})
+	// A remap or conversion rewrites the settings too.
+	context.SetEnabled(&p.predictionsInput, !m.labelsLocked())
+	p.thresholdLabel.SetValue(fmt.Sprintf("Confidence ≥ %d%% (%d shown)", m.suggestThreshold, len(m.shownSuggestions())))
+	p.thresholdLabel.SetVerticalAlign(basicwidget.VerticalAlignMiddle)
+	p.thresholdSlider.SetMinimumValue(0)
+	p.thresholdSlider.SetMaximumValue(100)
+	p.thresholdSlider.SetStep(5)
+	p.thresholdSlider.SetValue(m.suggestThreshold)
+	p.thresholdSlider.OnValueChanged(func(context *guigui.Context, value int) {
+		m.setSuggestThreshold(value)
+	})
+
+	if m.comparing {
+		d := m.currentComparison()
+		p.compareLabel.SetValue(fmt.Sprintf("Compare: %d found, %d wrong, %d missed", len(d.found), len(d.wrong), len(d.missed)))
+	} else {
+		p.compareLabel.SetValue("Compare with predictions")
+	}
+	p.compareLabel.SetVerticalAlign(basicwidget.VerticalAlignMiddle)
+	p.compareCheckbox.SetValue(m.comparing)
+	p.compareCheckbox.OnValueChanged(func(context *guigui.Context, value bool) {
+		m.comparing = value
+	})
+	p.iouLabel.SetValue(fmt.Sprintf("IoU ≥ %d%%", m.compareIoU))
+	p.iouLabel.SetVerticalAlign(basicwidget.VerticalAlignMiddle)
+	p.iouSlider.SetMinimumValue(5)
+	p.iouSlider.SetMaximumValue(95)
+	p.iouSlider.SetStep(5)
+	p.iouSlider.SetValue(m.compareIoU)
+	p.iouSlider.OnValueChanged(func(context *guigui.Context, value int) {
+		m.setCompareIoU(value)
+	})
+	context.SetEnabled(&p.iouSlider, m.comparing)
+
+	p.runModelButton.SetText("Run model")
+	p.runModelButton.OnDown(func(context *guigui.Context) {
+		if file := m.currentFile(); file != "" {
+			m.runModel([]string{file})
+		}
+	})
+	p.runAllButton.SetText("Run on all images")
+	p.runAllButton.OnDown(func(context *guigui.Context) {
+		m.runModel(m.files)
+	})
+	context.SetEnabled(&p.runModelButton, m.model != nil)
+	context.SetEnabled(&p.runAllButton, m.model != nil)
+	p.modelStatusText.SetValue(m.modelStatus)
+	p.modelStatusText.SetVerticalAlign(basicwidget.VerticalAlignMiddle)
+
+	p.splitInput.SetPlaceholder("Split, e.g. 80/10/10 stratify group=sequence")
+	p.splitInput.OnValueChanged(func(context *guigui.Context, text string, committed bool) {
+		p.splitInput.SetError(false)
+		p.splitInput.SetSupportText("")
+		if !committed {
+			m.splitPreviewed = nil
+		}
+	})
+	p.splitButton.SetText("Split dataset")
+	p.splitButton.OnDown(func(context *guigui.Context) {
+		spec, err := parseSplitSpec(p.splitInput.Value())
+		p.splitInput.SetError(err != nil)
+		if err != nil {
+			p.splitInput.SetSupportText(err.Error())
+			return
+		}
+		m.startSplit(spec)
+	})
+	context.SetEnabled(&p.splitButton, !m.splitting)
+	p.splitStatusText.SetValue(m.splitStatus)
+	p.splitStatusText.SetVerticalAlign(basicwidget.VerticalAlignMiddle)
+
+	p.remapInput.SetPlaceholder("Classes, e.g. car=car,truck; person")
+	p.remapInput.OnValueChanged(func(context *guigui.Context, text string, committed bool) {
+		p.remapInput.SetError(false)
+		p.remapInput.SetSupportText("")
+	})
+	remap := func(dryRun bool) {
+		err := m.startRemap(p.remapInput.Value(), dryRun)
+		p.remapInput.SetError(err != nil)
+		if err != nil {
+			p.remapInput.SetSupportText(err.Error())
+		}
+	}
+	p.previewRemapButton.SetText("Preview")
+	p.previewRemapButton.OnDown(func(context *guigui.Context) {
+		remap(true)
+	})
+	p.remapButton.SetText("Remap classes")
+	p.remapButton.OnDown(func(context *guigui.Context) {
+		remap(false)
+	})
+	context.SetEnabled(&p.previewRemapButton, !m.remapping)
+	context.SetEnabled(&p.remapButton, !m.remapping)
+	p.remapStatusText.SetValue(m.remapStatus)
+	p.remapStatusText.SetVerticalAlign(basicwidget.VerticalAlignMiddle)
+
+	p.carryLabel.SetValue("Carry regions forward")
+	p.carryLabel.SetVerticalAlign(basicwidget.VerticalAlignMiddle)
+	p.carryCheckbox.SetValue(m.carryRegions)
+	p.carryCheckbox.OnValueChanged(func(context *guigui.Context, value bool) {
+		m.carryRegions = value
+	})
+
+	p.orientationLabel.SetValue("Labels are relative to")
+	p.orientationLabel.SetVerticalAlign(basicwidget.VerticalAlignMiddle)
+	p.orientationSelect.SetItems([]basicwidget.SelectItem[bool]{
+		{Text: "Stored pixels", Value: false},
+		{Text: "EXIF-oriented image", Value: true},
+	})
+	p.orientationSelect.SelectItemByValue(m.settings.OrientedLabels)
+	context.SetEnabled(&p.orientationSelect, !m.labelsLocked() && !m.splitting)
+	p.orientationSelect.OnItemSelected(func(context *guigui.Context, index int) {
+		if item, ok := p.orientationSelect.ItemByIndex(index); ok {
+			m.setOrientedLabels(item.Value)
+		}
+	})
+
 	return nil
 }
 
@@ -133,27 +445,127 @@ func (p *editorPane) layout(context *guigui.Context) guigui.LinearLayout {
 		Gap:       u / 4,
 	}
 
+	p.adjustItems = slices.Delete(p.adjustItems, 0, len(p.adjustItems))
+	p.adjustItems = append(p.adjustItems,
+		guigui.LinearLayoutItem{Widget: &p.clipSelect},
+		guigui.LinearLayoutItem{Widget: &p.brightnessLabel, Size: guigui.FixedSize(5 * u)},
+		guigui.LinearLayoutItem{Widget: &p.brightnessSlider, Size: guigui.FixedSize(5 * u)},
+		guigui.LinearLayoutItem{Widget: &p.gammaLabel, Size: guigui.FixedSize(4 * u)},
+		guigui.LinearLayoutItem{Widget: &p.gammaSlider, Size: guigui.FixedSize(5 * u)},
+		guigui.LinearLayoutItem{Widget: &p.claheCheckbox, Size: guigui.FixedSize(u)},
+		guigui.LinearLayoutItem{Widget: &p.claheLabel},
+		guigui.LinearLayoutItem{Size: guigui.FlexibleSize(1)},
+	)
+	adjustRow := guigui.LinearLayout{
+		Direction: guigui.LayoutDirectionHorizontal,
+		Items:     p.adjustItems,
+		Gap:       u / 4,
+	}
+
 	p.buttonRowItems = slices.Delete(p.buttonRowItems, 0, len(p.buttonRowItems))
 	p.buttonRowItems = append(p.buttonRowItems,
 		guigui.LinearLayoutItem{Widget: &p.updateMetadataButton},
+		guigui.LinearLayoutItem{Widget: &p.carryCheckbox, Size: guigui.FixedSize(u)},
+		guigui.LinearLayoutItem{Widget: &p.carryLabel},
+		guigui.LinearLayoutItem{Widget: &p.compareCheckbox, Size: guigui.FixedSize(u)},
+		guigui.LinearLayoutItem{Widget: &p.compareLabel},
+		guigui.LinearLayoutItem{Widget: &p.iouLabel, Size: guigui.FixedSize(3 * u)},
+		guigui.LinearLayoutItem{Widget: &p.iouSlider, Size: guigui.FixedSize(4 * u)},
 		guigui.LinearLayoutItem{Size: guigui.FlexibleSize(1)},
+		guigui.LinearLayoutItem{Widget: &p.orientationLabel},
+		guigui.LinearLayoutItem{Widget: &p.orientationSelect},
 	)
 	buttonRow := guigui.LinearLayout{
 		Direction: guigui.LayoutDirectionHorizontal,
 		Items:     p.buttonRowItems,
+		Gap:       u / 4,
+	}
+
+	p.statusRowItems = slices.Delete(p.statusRowItems, 0, len(p.statusRowItems))
+	p.statusRowItems = append(p.statusRowItems,
+		guigui.LinearLayoutItem{Widget: &p.statusText},
+		guigui.LinearLayoutItem{Widget: &p.commentInput, Size: guigui.FlexibleSize(1)},
+	)
+	statusRow := guigui.LinearLayout{
+		Direction: guigui.LayoutDirectionHorizontal,
+		Items:     p.statusRowItems,
+		Gap:       u / 4,
+	}
+
+	p.windowItems = slices.Delete(p.windowItems, 0, len(p.windowItems))
+	p.windowItems = append(p.windowItems,
+		guigui.LinearLayoutItem{Widget: &p.autoWindowCheckbox, Size: guigui.FixedSize(u)},
+		guigui.LinearLayoutItem{Widget: &p.autoWindowLabel},
+		guigui.LinearLayoutItem{Widget: &p.levelLabel, Size: guigui.FixedSize(4 * u)},
+		guigui.LinearLayoutItem{Widget: &p.levelSlider, Size: guigui.FixedSize(6 * u)},
+		guigui.LinearLayoutItem{Widget: &p.windowLabel, Size: guigui.FixedSize(4 * u)},
+		guigui.LinearLayoutItem{Widget: &p.windowSlider, Size: guigui.FixedSize(6 * u)},
+		guigui.LinearLayoutItem{Size: guigui.FlexibleSize(1)},
+	)
+	windowRow := guigui.LinearLayout{
+		Direction: guigui.LayoutDirectionHorizontal,
+		Items:     p.windowItems,
+		Gap:       u / 4,
+	}
+
+	p.suggestItems = slices.Delete(p.suggestItems, 0, len(p.suggestItems))
+	p.suggestItems = append(p.suggestItems,
+		guigui.LinearLayoutItem{Widget: &p.predictionsLabel},
+		guigui.LinearLayoutItem{Widget: &p.predictionsInput, Size: guigui.FixedSize(8 * u)},
+		guigui.LinearLayoutItem{Widget: &p.thresholdLabel, Size: guigui.FixedSize(9 * u)},
+		guigui.LinearLayoutItem{Widget: &p.thresholdSlider, Size: guigui.FixedSize(6 * u)},
+		guigui.LinearLayoutItem{Widget: &p.runModelButton},
+		guigui.LinearLayoutItem{Widget: &p.runAllButton},
+		guigui.LinearLayoutItem{Widget: &p.modelStatusText, Size: guigui.FlexibleSize(1)},
+	)
+	suggestRow := guigui.LinearLayout{
+		Direction: guigui.LayoutDirectionHorizontal,
+		Items:     p.suggestItems,
+		Gap:       u / 4,
+	}
+
+	p.splitItems = slices.Delete(p.splitItems, 0, len(p.splitItems))
+	p.splitItems = append(p.splitItems,
+		guigui.LinearLayoutItem{Widget: &p.splitInput, Size: guigui.FixedSize(14 * u)},
+		guigui.LinearLayoutItem{Widget: &p.splitButton},
+		guigui.LinearLayoutItem{Widget: &p.splitStatusText, Size: guigui.FlexibleSize(1)},
+	)
+	splitRow := guigui.LinearLayout{
+		Direction: guigui.LayoutDirectionHorizontal,
+		Items:     p.splitItems,
+		Gap:       u / 4,
+	}
+
+	p.remapItems = slices.Delete(p.remapItems, 0, len(p.remapItems))
+	p.remapItems = append(p.remapItems,
+		guigui.LinearLayoutItem{Widget: &p.remapInput, Size: guigui.FixedSize(14 * u)},
+		guigui.LinearLayoutItem{Widget: &p.previewRemapButton},
+		guigui.LinearLayoutItem{Widget: &p.remapButton},
+		guigui.LinearLayoutItem{Widget: &p.remapStatusText, Size: guigui.FlexibleSize(1)},
+	)
+	remapRow := guigui.LinearLayout{
+		Direction: guigui.LayoutDirectionHorizontal,
+		Items:     p.remapItems,
+		Gap:       u / 4,
 	}
 
 	p.colItems = slices.Delete(p.colItems, 0, len(p.colItems))
 	p.colItems = append(p.colItems,
 		guigui.LinearLayoutItem{Layout: &toolbar},
+		guigui.LinearLayoutItem{Layout: &adjustRow},
+		guigui.LinearLayoutItem{Layout: &windowRow},
+		guigui.LinearLayoutItem{Layout: &suggestRow},
 		guigui.LinearLayoutItem{Widget: &p.currentFileText},
 		guigui.LinearLayoutItem{Widget: &p.regionsText},
+		guigui.LinearLayoutItem{Layout: &statusRow},
 		guigui.LinearLayoutItem{Widget: &p.drawingLabelText},
 		guigui.LinearLayoutItem{Widget: &p.editor},
 		guigui.LinearLayoutItem{Widget: &p.helpText},
 		guigui.LinearLayoutItem{Widget: &p.summaryText},
 		guigui.LinearLayoutItem{Widget: &p.categoryText},
 		guigui.LinearLayoutItem{Layout: &buttonRow},
+		guigui.LinearLayoutItem{Layout: &splitRow},
+		guigui.LinearLayoutItem{Layout: &remapRow},
 	)
 	return guigui.LinearLayout{
 		Direction: guigui.LayoutDirectionVertical,
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/AndreRenaud/fastmark/storage"
+)
+
+// defaultPredictionsDir is where a detector's predictions are looked for,
+// relative to the dataset, unless the dataset settings say otherwise.
+const defaultPredictionsDir = "predictions"
+
+// defaultSuggestThreshold is the lowest confidence, in percent, of the
+// predictions offered as suggestions.
+const defaultSuggestThreshold = 50
+
+// Suggestion is a detector's prediction for an image, offered in the editor
+// as a region to accept or reject. Its region is in label coordinates.
+type Suggestion struct {
+	Region     Region
+	Confidence float64
+}
+
+// predictionFileName returns the file in dir holding an image's predictions,
+// named like its label file.
+func predictionFileName(dir, image string) string {
+	return filepath.Join(dir, labelBase(image)+".txt")
+}
+
+// LoadPredictions reads a predictions file of "class x y w h conf" lines.
+// Lines without a confidence are taken as certain.
+func LoadPredictions(backend storage.Storage, filename string) ([]Suggestion, error) {
+	file, err := backend.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return parsePredictions(file, filename)
+}
+
+// parsePredictions reads "class x y w h conf" lines, logging and skipping
+// invalid ones. filename only names where they came from in the log.
+func parsePredictions(reader io.Reader, filename string) ([]Suggestion, error) {
+	var suggestions []Suggestion
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		columns := strings.Fields(scanner.Text())
+		if len(columns) != 5 && len(columns) != 6 {
+			log.Printf("Invalid line: %s in %s", scanner.Text(), filename)
+			continue
+		}
+		s := Suggestion{Confidence: 1}
+		var err error
+		if len(columns) == 6 {
+			if s.Confidence, err = strconv.ParseFloat(columns[5], 64); err != nil {
+				log.Printf("Invalid confidence: %s in %s", columns[5], filename)
+				continue
+			}
+		}
+		regions := parseRegions(strings.NewReader(strings.Join(columns[:5], " ")), filename)
+		if len(regions) != 1 {
+			continue
+		}
+		s.Region = regions[0]
+		suggestions = append(suggestions, s)
+	}
+	return suggestions, scanner.Err()
+}
+
+// predictionsText formats suggestions as predictions file lines.
+func predictionsText(suggestions []Suggestion) string {
+	var b strings.Builder
+	for _, s := range suggestions {
+		r := s.Region
+		fmt.Fprintf(&b, "%d %f %f %f %f %f\n", r.index, r.xMid, r.yMid, r.width, r.height, s.Confidence)
+	}
+	return b.String()
+}
+
+// loadSuggestions loads the selected file's predictions. Most images may
+// have none, so a missing file isn't logged.
+func (m *appModel) loadSuggestions(filename string) {
+	m.suggestions, _ = LoadPredictions(m.backend, predictionFileName(m.settings.predictionsDir(), filename))
+}
+
+// shownSuggestions are the selected file's suggestions at or above the
+// confidence threshold that haven't been accepted or rejected. None are
+// offered while they are being compared with the regions instead.
+func (m *appModel) shownSuggestions() []Suggestion {
+	if m.comparing {
+		return nil
+	}
+	var shown []Suggestion
+	for _, s := range m.suggestions {
+		if s.Confidence*100 < float64(m.suggestThreshold) {
+			continue
+		}
+		handled := func(r Region) bool { return r.index == s.Region.index && sameBox(r, s.Region) }
+		if slices.ContainsFunc(m.currentRegions.Regions, handled) || slices.ContainsFunc(m.currentState.Rejected, handled) {
+			continue
+		}
+		shown = append(shown, s)
+	}
+	return shown
+}
+
+// acceptSuggestions adds the given shown suggestions to the selected file's
+// regions.
+func (m *appModel) acceptSuggestions(suggestions []Suggestion) {
+	if len(suggestions) == 0 {
+		return
+	}
+	for _, s := range suggestions {
+		m.currentRegions.Regions = append(m.currentRegions.Regions, s.Region)
+	}
+	log.Printf("Accepted %d suggestions for %s", len(suggestions), m.currentFile())
+	m.currentRegions.SaveAsync()
+	m.regionsChanged()
+}
+
+// rejectSuggestions records the given shown suggestions as wrong, so they
+// aren't offered again.
+func (m *appModel) rejectSuggestions(suggestions []Suggestion) {
+	if len(suggestions) == 0 {
+		return
+	}
+	for _, s := range suggestions {
+		m.currentState.Rejected = append(m.currentState.Rejected, s.Region)
+	}
+	log.Printf("Rejected %d suggestions for %s", len(suggestions), m.currentFile())
+	m.currentState.SaveAsync()
+}
+
+// suggestionAt returns the shown suggestion under click, as for
+// getClosestRegion.
+func (m *appModel) suggestionAt(click image.Point, imageWidth, imageHeight int) (Suggestion, bool) {
+	shown := m.shownSuggestions()
+	regions := make([]Region, len(shown))
+	for i, s := range shown {
+		regions[i] = m.displayRegion(m.currentFile(), s.Region)
+	}
+	if i := closestRegion(regions, click, imageWidth, imageHeight); i >= 0 {
+		return shown[i], true
+	}
+	return Suggestion{}, false
+}
+
+// setPredictionsDir changes where predictions are read from and saves it in
+// the dataset settings. The summaries are rescanned, so the uncertainty,
+// errors, filters and sort order come from the new predictions.
+func (m *appModel) setPredictionsDir(dir string) {
+	dir = strings.TrimSpace(dir)
+	if dir == m.settings.PredictionsDir {
+		return
+	}
+	m.settings.PredictionsDir = dir
+	m.settings.SaveAsync()
+	if file := m.currentFile(); file != "" {
+		m.loadSuggestions(file)
+	}
+	m.startMetadataScan()
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"io"
+	"log"
+	"slices"
+	"sync"
+
+	"github.com/AndreRenaud/fastmark/storage"
+	"golang.org/x/image/tiff"
+)
+
+// prefetchAhead is how many files are decoded ahead of the selection in the
+// direction of travel; prefetchBehind is how many are kept decoded behind it.
+const (
+	prefetchAhead  = 4
+	prefetchBehind = 1
+)
+
+// imageCacheBytes bounds the memory used by decoded images kept for reuse.
+const imageCacheBytes = 1 << 30
+
+// prefetchMaxBytes is the most memory a prefetched decode may take. Larger
+// images are only decoded once selected, so stepping through a folder of
+// them can't exhaust memory.
+const prefetchMaxBytes = imageCacheBytes / 4
+
+// errTooLargeToPrefetch abandons a prefetch over prefetchMaxBytes.
+var errTooLargeToPrefetch = errors.New("too large to prefetch")
+
+// decodeMemory bounds the memory taken by the decodes in flight.
+var decodeMemory = newByteBudget(imageCacheBytes / 2)
+
+// byteBudget hands out shares of a memory limit.
+type byteBudget struct {
+	mu    sync.Mutex
+	freed sync.Cond
+	used  int
+	limit int
+}
+
+func newByteBudget(limit int) *byteBudget {
+	b := &byteBudget{limit: limit}
+	b.freed.L = &b.mu
+	return b
+}
+
+// acquire waits until n more bytes fit within the limit, reporting false if
+// ctx is cancelled first. A request for more than the whole limit waits for
+// everything else to finish, then goes ahead alone.
+func (b *byteBudget) acquire(ctx context.Context, n int) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stop := context.AfterFunc(ctx, func() {
+		b.mu.Lock()
+		b.freed.Broadcast()
+		b.mu.Unlock()
+	})
+	defer stop()
+	for b.used > 0 && b.used+n > b.limit {
+		if ctx.Err() != nil {
+			return false
+		}
+		b.freed.Wait()
+	}
+	if ctx.Err() != nil {
+		return false
+	}
+	b.used += n
+	return true
+}
+
+// release returns n bytes from an acquire.
+func (b *byteBudget) release(n int) {
+	if n == 0 {
+		return
+	}
+	b.mu.Lock()
+	b.used -= n
+	b.freed.Broadcast()
+	b.mu.Unlock()
+}
+
+// cachedImage is a decoded file ready to be shown. texture is created on the
+// main goroutine when the decode is delivered, so showing it is immediate.
+type cachedImage struct {
+	file    string
+	adjust  imageAdjust
+	source  image.Image
+	texture *tiledImage
+	bytes   int
+}
+
+// imageCache holds recently decoded images, evicting the least recently
+// used once over imageCacheBytes. It is only used on the main goroutine.
+// shown is the texture on screen, which mustn't be deallocated when its
+// entry goes; the garbage collector frees it once it is replaced.
+type imageCache struct {
+	entries []*cachedImage // most recently used last
+	bytes   int
+	shown   *tiledImage
+}
+
+func (c *imageCache) get(file string, adjust imageAdjust) *cachedImage {
+	i := slices.IndexFunc(c.entries, func(e *cachedImage) bool {
+		return e.file == file && e.adjust == adjust
+	})
+	if i < 0 {
+		return nil
+	}
+	e := c.entries[i]
+	c.entries = append(slices.Delete(c.entries, i, i+1), e)
+	return e
+}
+
+func (c *imageCache) add(e *cachedImage) {
+	c.entries = append(c.entries, e)
+	c.bytes += e.bytes
+	for c.bytes > imageCacheBytes && len(c.entries) > 1 {
+		c.bytes -= c.entries[0].bytes
+		c.release(c.entries[0])
+		c.entries = slices.Delete(c.entries, 0, 1)
+	}
+}
+
+func (c *imageCache) clear() {
+	for _, e := range c.entries {
+		c.release(e)
+	}
+	c.entries = nil
+	c.bytes = 0
+}
+
+// release frees the GPU memory of an entry's texture straight away, rather
+// than whenever the garbage collector gets to it.
+func (c *imageCache) release(e *cachedImage) {
+	if e.texture != c.shown {
+		e.texture.deallocate()
+	}
+}
+
+// imageBytes estimates the memory held by a decoded image and its texture.
+func imageBytes(img image.Image) int {
+	return img.Bounds().Dx() * img.Bounds().Dy() * 4 * 2
+}
+
+// decodedBytes estimates the memory decoding an image file's data will take,
+// as imageBytes does, from its header. Multi-band TIFFs also take their
+// shown bands. It is 0 if the header can't be read.
+func decodedBytes(data []byte, filename string) int {
+	var config image.Config
+	var err error
+	if isTIFFFile(filename) {
+		config, err = tiff.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			if h, err := readMultibandHeader(data); err == nil {
+				return h.planeBytes() + h.width*h.height*4*2
+			}
+		}
+	} else {
+		config, _, err = image.DecodeConfig(bytes.NewReader(data))
+	}
+	if err != nil {
+		return 0
+	}
+	return config.Width * config.Height * 4 * 2
+}
+
+// cancelReader stops a decode part way through once its context is
+// cancelled, which also stops any further network reads.
+type cancelReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c cancelReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// decodeJob is a decode in flight. id tells a cancelled job's late result
+// apart from a newer job for the same file.
+type decodeJob struct {
+	id     uint64
+	cancel context.CancelFunc
+}
+
+// decodeFile starts a background decode of file, unless one is already
+// running. The result is delivered on m.decoded. A prefetch is abandoned if
+// the image is over prefetchMaxBytes, and isn't tried again.
+func (m *appModel) decodeFile(file string, prefetch bool) {
+	if _, ok := m.decoding[file]; ok || (prefetch && m.tooLarge[file]) {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	m.decodeID++
+	id := m.decodeID
+	m.decoding[file] = decodeJob{id: id, cancel: cancel}
+	backend := m.backend
+	adjust := m.adjust
+
+	go func() {
+		// The decode and adjustment only go ahead once there is memory for
+		// them, which is known once the header has been read.
+		reserved := 0
+		reserve := func(n int) error {
+			if prefetch && n > prefetchMaxBytes {
+				return errTooLargeToPrefetch
+			}
+			if !decodeMemory.acquire(ctx, n) {
+				return ctx.Err()
+			}
+			reserved = n
+			return nil
+		}
+		img, orientation, err := loadImageContext(ctx, backend, imagePath(file), reserve)
+		if err != nil {
+			decodeMemory.release(reserved)
+			if ctx.Err() == nil && !errors.Is(err, errTooLargeToPrefetch) {
+				log.Printf("Error loading image %s: %s", file, err)
+			}
+			m.decoded <- decodedImage{job: id, file: file, tooLarge: errors.Is(err, errTooLargeToPrefetch)}
+			return
+		}
+		m.orientations.set(file, orientation)
+		display := adjustImage(img, adjust)
+		decodeMemory.release(reserved)
+		m.decoded <- decodedImage{job: id, file: file, adjust: adjust, source: img, display: display}
+	}()
+}
+
+// prefetchAround decodes the files around the selection, mostly in the
+// direction the user is moving, and cancels any other decodes in flight so
+// a long jump doesn't leave the workers busy with files no longer needed.
+func (m *appModel) prefetchAround(direction int) {
+	if direction == 0 {
+		direction = 1
+	}
+	// wanted[0] is the selected file, which is decoded whatever its size.
+	wanted := []string{m.files[m.selectedIndex]}
+	for step := 1; step <= prefetchAhead; step++ {
+		if i := m.visibleStep(step * direction); i >= 0 {
+			wanted = append(wanted, m.files[i])
+		}
+	}
+	for step := 1; step <= prefetchBehind; step++ {
+		if i := m.visibleStep(-step * direction); i >= 0 {
+			wanted = append(wanted, m.files[i])
+		}
+	}
+
+	for file, job := range m.decoding {
+		if !slices.Contains(wanted, file) {
+			job.cancel()
+			delete(m.decoding, file)
+		}
+	}
+	for i, file := range wanted {
+		if m.images.get(file, m.adjust) == nil {
+			m.decodeFile(file, i > 0)
+		}
+	}
+}
+
+// cancelDecodes stops every decode in flight.
+func (m *appModel) cancelDecodes() {
+	for file, job := range m.decoding {
+		job.cancel()
+		delete(m.decoding, file)
+	}
+}
+
+// imageDecoded caches a finished decode and shows it if it is for the
+// selected file, replacing any image shown with different adjustments. It runs on the main goroutine.
+func (m *appModel) imageDecoded(d decodedImage) {
+	if job, ok := m.decoding[d.file]; ok && job.id == d.job {
+		job.cancel()
+		delete(m.decoding, d.file)
+	}
+	if d.tooLarge {
+		m.tooLarge[d.file] = true
+	}
+	if d.source == nil || d.adjust != m.adjust {
+		return
+	}
+	e := &cachedImage{
+		file:    d.file,
+		adjust:  d.adjust,
+		source:  d.source,
+		texture: newTiledImage(d.display),
+		bytes:   imageBytes(d.source),
+	}
+	m.images.add(e)
+	if m.selectedIndex < 0 || m.selectedIndex >= len(m.files) || m.files[m.selectedIndex] != d.file {
+		return
+	}
+	if m.displayImage == nil || m.shownAdjust != d.adjust {
+		m.showImage(e)
+	}
+}
+
+func (m *appModel) showImage(e *cachedImage) {
+	m.currentImage = e.source
+	m.displayImage = e.texture
+	m.images.shown = e.texture
+	m.shownAdjust = e.adjust
+	m.imageGen++
+}
+
+// loadImageContext is loadImage, abandoning the read once ctx is cancelled.
+// If reserve isn't nil, it is given the memory the decode will take once
+// that is known, and the decode only goes ahead if it returns nil.
+func loadImageContext(ctx context.Context, backend storage.Storage, filename string, reserve func(int) error) (image.Image, int, error) {
+	if reserve == nil {
+		reserve = func(int) error { return nil }
+	}
+	if video, frame, ok := parseVideoFrame(imageName(filename)); ok {
+		img, err := loadVideoFrame(ctx, backend, video, frame)
+		if err != nil {
+			return nil, 1, err
+		}
+		// The frame is small next to the adjusted copy still to be made.
+		if err := reserve(imageBytes(img)); err != nil {
+			return nil, 1, err
+		}
+		return capImageSize(img, maxImageDimension), 1, nil
+	}
+	f, err := backend.Open(filename)
+	if err != nil {
+		return nil, 1, err
+	}
+	defer f.Close()
+	// The whole file is read first, as the orientation is in its header.
+	data, err := io.ReadAll(cancelReader{ctx: ctx, r: f})
+	if err != nil {
+		return nil, 1, err
+	}
+	if err := reserve(decodedBytes(data, filename)); err != nil {
+		return nil, 1, err
+	}
+	orientation := exifOrientation(data)
+	var img image.Image
+	if isTIFFFile(filename) {
+		img, err = decodeTIFF(data)
+	} else {
+		img, _, err = image.Decode(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, 1, err
+	}
+	return capImageSize(orientImage(img, orientation), maxImageDimension), orientation, nil
+}
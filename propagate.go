@@ -0,0 +1,111 @@
+package main
+
+import (
+	"log"
+	"slices"
+	"strings"
+
+	"golang.design/x/clipboard"
+)
+
+// pasteRegions adds regions, given relative to the displayed image, to the
+// selected file, skipping any it already has. It returns how many were
+// added.
+func (m *appModel) pasteRegions(regions []Region) int {
+	file := m.currentFile()
+	if file == "" {
+		return 0
+	}
+	m.loadOrientation(file)
+	added := 0
+	for _, r := range regions {
+		r = m.labelRegion(file, r)
+		if !r.Normalize() || slices.ContainsFunc(m.currentRegions.Regions, func(e Region) bool {
+			return e.index == r.index && sameBox(e, r)
+		}) {
+			continue
+		}
+		m.currentRegions.Regions = append(m.currentRegions.Regions, r)
+		added++
+	}
+	if added > 0 {
+		log.Printf("Pasted %d regions into %s", added, file)
+		m.currentRegions.SaveAsync()
+		m.regionsChanged()
+	}
+	return added
+}
+
+// copyRegionsFrom copies the regions of class (or all of them if class is
+// negative) from file into the selected file. regions are file's labels.
+func (m *appModel) copyRegionsFrom(file string, regions []Region, class int) int {
+	m.loadOrientation(file)
+	var display []Region
+	for _, r := range regions {
+		if class < 0 || r.index == class {
+			display = append(display, m.displayRegion(file, r))
+		}
+	}
+	return m.pasteRegions(display)
+}
+
+// copyFromNeighbour copies regions from the file step places away in the
+// file list into the selected file, as neighbouring frames share most boxes.
+func (m *appModel) copyFromNeighbour(step int, class int) {
+	i := m.visibleStep(step)
+	if i < 0 || i == m.selectedIndex {
+		return
+	}
+	regions, err := LoadRegionList(m.backend, labelFileName(m.files[i]))
+	if err != nil {
+		return
+	}
+	m.copyRegionsFrom(m.files[i], regions.Regions, class)
+}
+
+// carryForward copies the regions of the file just left into the newly
+// selected file if it is still unlabelled, for the sticky "Carry regions
+// forward" mode.
+func (m *appModel) carryForward(from string, regions []Region) {
+	// Regions being followed are proposed by the tracker instead.
+	if !m.carryRegions || m.follow != nil || m.labelsLocked() || from == "" || from == m.currentFile() || len(regions) == 0 {
+		return
+	}
+	if effectiveStatus(m.currentState, len(m.currentRegions.Regions)) != StatusUnlabelled {
+		return
+	}
+	m.copyRegionsFrom(from, regions, -1)
+}
+
+// copyRegions puts the selected file's region index, or all its regions if
+// index is negative, on the region clipboard. They are also copied to the
+// system clipboard as Darknet lines relative to the displayed image, so they
+// can be pasted into another FastMark window.
+func (m *appModel) copyRegions(index int) {
+	file := m.currentFile()
+	m.loadOrientation(file)
+	m.regionClipboard = m.regionClipboard[:0]
+	for i, r := range m.currentRegions.Regions {
+		if index < 0 || i == index {
+			m.regionClipboard = append(m.regionClipboard, m.displayRegion(file, r))
+		}
+	}
+	log.Printf("Copied %d regions from %s", len(m.regionClipboard), file)
+	if len(m.regionClipboard) > 0 {
+		copyToClipboard(regionsText(m.regionClipboard))
+	}
+}
+
+// pasteClipboard pastes Darknet lines from the system clipboard into the
+// selected file, or the regions last copied if it holds none.
+func (m *appModel) pasteClipboard() {
+	regions := m.regionClipboard
+	if clipboardErr == nil {
+		if text := clipboard.Read(clipboard.FmtText); len(text) > 0 {
+			if parsed := parseRegions(strings.NewReader(string(text)), "clipboard"); len(parsed) > 0 {
+				regions = parsed
+			}
+		}
+	}
+	m.pasteRegions(regions)
+}
@@ -4,7 +4,10 @@ import (
 	"bufio"
 	"fmt"
 	"image/color"
+	"io"
 	"log"
+	"math"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -54,7 +57,17 @@ func LoadRegionList(backend storage.Storage, filename string) (RegionList, error
 	}
 	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
+	r := RegionList{Regions: parseRegions(file, filename), filename: filename, backend: backend}
+	if cache != nil {
+		cache.Add(filename, r)
+	}
+	return r, nil
+}
+
+// parseRegions reads Darknet label lines, logging and skipping invalid ones.
+// filename only names where they came from in the log.
+func parseRegions(reader io.Reader, filename string) []Region {
+	scanner := bufio.NewScanner(reader)
 	var retval []Region
 	for scanner.Scan() {
 		columns := strings.Fields(scanner.Text())
@@ -91,31 +104,44 @@ func LoadRegionList(backend storage.Storage, filename string) (RegionList, error
 		}
 		retval = append(retval, region)
 	}
+	return retval
+}
 
-	r := RegionList{Regions: retval, filename: filename, backend: backend}
-	if cache != nil {
-		cache.Add(filename, r)
+// regionsText formats regions as Darknet label lines.
+func regionsText(regions []Region) string {
+	var b strings.Builder
+	for _, r := range regions {
+		fmt.Fprintf(&b, "%d %f %f %f %f\n", r.index, r.xMid, r.yMid, r.width, r.height)
 	}
-	return r, nil
+	return b.String()
 }
 
+// Save writes the regions, after any earlier saves of the same file.
 func (r RegionList) Save() error {
+	return <-r.queueSave()
+}
+
+// SaveAsync saves the regions in the background, so the UI doesn't wait on
+// storage. Failures are logged.
+func (r RegionList) SaveAsync() {
+	r.queueSave()
+}
+
+// queueSave hands a copy of the regions to labelWrites.
+func (r RegionList) queueSave() <-chan error {
 	log.Printf("Saving regions to %s", r.filename)
 	if r.filename == "" {
-		return fmt.Errorf("No filename specified for saving regions")
+		log.Printf("No filename specified for saving regions")
+		done := make(chan error, 1)
+		done <- fmt.Errorf("No filename specified for saving regions")
+		return done
 	}
+	// Copy, as the caller goes on editing its list.
+	r.Regions = slices.Clone(r.Regions)
 	if cache != nil {
 		cache.Add(r.filename, r)
 	}
-	file, err := r.backend.OpenWrite(r.filename, false)
-	if err != nil {
-		log.Printf("Error creating file %s: %s", r.filename, err)
-		return err
-	}
-	for _, region := range r.Regions {
-		fmt.Fprintf(file, "%d %f %f %f %f\n", region.index, region.xMid, region.yMid, region.width, region.height)
-	}
-	return file.Close()
+	return labelWrites.write(r.backend, r.filename, regionsText(r.Regions))
 }
 
 func RegionIndexColor(index int) color.Color {
@@ -135,8 +161,40 @@ func RegionIndexColor(index int) color.Color {
 	case 6:
 		return color.RGBA{0, 255, 255, 255}
 	default:
-		return color.YCbCr{255, uint8(index * 33), uint8(index * 16)}
+		// Step round the hue circle by the golden angle, so each class is
+		// far from those just before it, and vary the saturation and
+		// brightness so classes whose hues come round close together still
+		// differ.
+		index = max(index, -index)
+		hue := math.Mod(float64(index)*0.618033988749895, 1)
+		saturation := []float64{0.9, 0.55, 1}[index%3]
+		value := []float64{1, 0.8}[index/3%2]
+		return hsvColor(hue, saturation, value)
+	}
+}
+
+// hsvColor converts a hue, saturation and value, each from 0 to 1, to RGB.
+func hsvColor(h, s, v float64) color.RGBA {
+	sector := h * 6
+	i := math.Floor(sector)
+	f := sector - i
+	p, q, t := v*(1-s), v*(1-s*f), v*(1-s*(1-f))
+	var r, g, b float64
+	switch int(i) % 6 {
+	case 0:
+		r, g, b = v, t, p
+	case 1:
+		r, g, b = q, v, p
+	case 2:
+		r, g, b = p, v, t
+	case 3:
+		r, g, b = p, q, v
+	case 4:
+		r, g, b = t, p, v
+	default:
+		r, g, b = v, p, q
 	}
+	return color.RGBA{uint8(r*255 + 0.5), uint8(g*255 + 0.5), uint8(b*255 + 0.5), 255}
 }
 
 func (r Region) Color() color.Color {
@@ -182,7 +240,7 @@ func (r *RegionList) AddRegion(region Region) {
 	if region.Normalize() {
 		log.Printf("Added new region %#v", region)
 		r.Regions = append(r.Regions, region)
-		r.Save()
+		r.SaveAsync()
 	} else {
 		log.Printf("Invalid region: %#v", region)
 	}
@@ -192,7 +250,7 @@ func (r *RegionList) Remove(index int) {
 	if index >= 0 && index < len(r.Regions) {
 		r.Regions = append(r.Regions[:index], r.Regions[index+1:]...)
 		log.Printf("Removed region %d: %#v", index, r.Regions)
-		r.Save()
+		r.SaveAsync()
 	} else {
 		log.Printf("Invalid index: %d", index)
 	}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/AndreRenaud/fastmark/storage"
+)
+
+// remapSuffix is added to the names of rewritten files until they are all
+// written and can replace the originals.
+const remapSuffix = ".remap"
+
+// classRemap is a new class list. from maps each old class number to its
+// new one, or to -1 if its regions are deleted.
+type classRemap struct {
+	names []string
+	from  []int
+}
+
+// parseClassRemap reads the new class list, as ";" separated entries in the
+// new order. "name" keeps the old class of that name; "name=old,old" renames
+// or merges the old classes, by name or number, into it; and "name=" adds a
+// class without regions. Old classes that aren't mentioned are deleted.
+func parseClassRemap(spec string, old []string) (classRemap, error) {
+	remap := classRemap{from: make([]int, len(old))}
+	for i := range remap.from {
+		remap.from[i] = -1
+	}
+	for _, entry := range strings.Split(spec, ";") {
+		name, sources, merge := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		if name == "" {
+			if strings.TrimSpace(entry) == "" {
+				continue
+			}
+			return remap, fmt.Errorf("class %q has no name", entry)
+		}
+		if slices.Contains(remap.names, name) {
+			return remap, fmt.Errorf("class %q is listed twice", name)
+		}
+		if !merge {
+			sources = name
+		}
+		index := len(remap.names)
+		remap.names = append(remap.names, name)
+		for _, source := range strings.Split(sources, ",") {
+			source = strings.TrimSpace(source)
+			if source == "" {
+				continue
+			}
+			class := slices.Index(old, source)
+			if n, err := strconv.Atoi(source); class < 0 && err == nil && n >= 0 && n < len(old) {
+				class = n
+			}
+			if class < 0 {
+				return remap, fmt.Errorf("unknown class %q", source)
+			}
+			if remap.from[class] >= 0 {
+				return remap, fmt.Errorf("class %q is used twice", old[class])
+			}
+			remap.from[class] = index
+		}
+	}
+	if len(remap.names) == 0 {
+		return remap, fmt.Errorf("no classes given")
+	}
+	return remap, nil
+}
+
+// region returns r with its new class, and false if it is deleted.
+func (c classRemap) region(r Region, filename string) (Region, bool, error) {
+	if r.index < 0 || r.index >= len(c.from) {
+		return r, false, fmt.Errorf("%s uses class %d, which has no name; add it to the class list first", filename, r.index)
+	}
+	if c.from[r.index] < 0 {
+		return r, false, nil
+	}
+	r.index = c.from[r.index]
+	return r, true, nil
+}
+
+// class returns the new number of old class index, or -1 if it has gone.
+func (c classRemap) class(index int) int {
+	if index < 0 || index >= len(c.from) {
+		return -1
+	}
+	return c.from[index]
+}
+
+// remapSummary counts what a remap changes.
+type remapSummary struct {
+	files    int // label files changed
+	renumber int // regions given a new class number
+	deleted  int // regions deleted
+	states   int // status files changed
+	// regions counts the regions of each old class, and changed lists the
+	// files that are rewritten.
+	regions []int
+	changed []string
+}
+
+func (s remapSummary) String() string {
+	return fmt.Sprintf("%d label files changed: %d regions renumbered, %d deleted; %d status files changed", s.files, s.renumber, s.deleted, s.states)
+}
+
+// report describes where each old class's regions go, then the summary.
+func (s remapSummary) report(remap classRemap, old []string) string {
+	var b strings.Builder
+	for class, name := range old {
+		fmt.Fprintf(&b, "%d %s: %d regions ", class, name, s.regions[class])
+		switch to := remap.from[class]; {
+		case to < 0:
+			b.WriteString("deleted\n")
+		case to == class && remap.names[to] == name:
+			b.WriteString("unchanged\n")
+		default:
+			fmt.Fprintf(&b, "-> %d %s\n", to, remap.names[to])
+		}
+	}
+	fmt.Fprintf(&b, "%s\n", s)
+	return b.String()
+}
+
+// labelFiles lists the dataset's label files and status sidecars, whether
+// or not their images are listed: everything in labels/ and the directories
+// under it, and in the directories the labels of Darknet list entries go in.
+// Outside a labels directory, only files named after an image alongside
+// them count, so image lists and other text files are left alone.
+func labelFiles(backend storage.Storage) (labels, states []string, err error) {
+	settings, err := LoadDatasetSettings(backend)
+	if err != nil {
+		return nil, nil, err
+	}
+	predictions := filepath.Clean(settings.predictionsDir())
+	var dirs []string
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		matches, err := backend.Glob(dir, "*")
+		if err != nil {
+			return err
+		}
+		dirs = append(dirs, dir)
+		for _, match := range matches {
+			name := filepath.Join(dir, filepath.Base(match))
+			// Only stat what could be a directory.
+			if ext := filepath.Ext(name); ext == ".txt" || ext == ".status" || ext == remapSuffix || name == predictions {
+				continue
+			}
+			if info, err := backend.Stat(name); err == nil && info.IsDir() {
+				if err := walk(name); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if info, err := backend.Stat("labels"); err == nil && info.IsDir() {
+		if err := walk("labels"); err != nil {
+			return nil, nil, err
+		}
+	}
+	files, err := listImages(backend)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, file := range files {
+		if dir := filepath.Dir(labelFileName(file)); !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+
+	for _, dir := range dirs {
+		matches, err := backend.Glob(dir, "*")
+		if err != nil {
+			return nil, nil, err
+		}
+		inLabels := slices.Contains(strings.Split(filepath.ToSlash(dir), "/"), "labels")
+		images := map[string]bool{}
+		for _, match := range matches {
+			if name := filepath.Base(match); isImageFile(name) {
+				images[strings.TrimSuffix(name, filepath.Ext(name))] = true
+			}
+		}
+		for _, match := range matches {
+			name := filepath.Base(match)
+			base, ext := strings.TrimSuffix(name, filepath.Ext(name)), filepath.Ext(name)
+			if !inLabels && !images[base] {
+				continue
+			}
+			switch ext {
+			case ".txt":
+				labels = append(labels, filepath.Join(dir, name))
+			case ".status":
+				states = append(states, filepath.Join(dir, name))
+			}
+		}
+	}
+	return labels, states, nil
+}
+
+// remapClasses rewrites the class list, every label and status file, and the
+// class colours and hotkeys in the dataset settings to match remap, or with
+// dryRun only counts what would change. The files are
+// replaced with replaceFiles, so a failure while writing leaves the dataset
+// as it was. Predictions files are left alone, as their classes are the
+// detector's.
+func remapClasses(backend storage.Storage, remap classRemap, dryRun bool) (remapSummary, error) {
+	summary := remapSummary{regions: make([]int, len(remap.from))}
+	// Edits still being saved would replace the remapped files.
+	labelWrites.flush()
+	labels, states, err := labelFiles(backend)
+	if err != nil {
+		return summary, err
+	}
+	changed := map[string]string{}
+	for _, filename := range labels {
+		text, err := readFileText(backend, filename)
+		if err != nil {
+			return summary, err
+		}
+		var regions []Region
+		fileChanged := false
+		for _, r := range parseRegions(strings.NewReader(text), filename) {
+			n, keep, err := remap.region(r, filename)
+			if err != nil {
+				return summary, err
+			}
+			summary.regions[r.index]++
+			if !keep {
+				summary.deleted++
+				fileChanged = true
+				continue
+			}
+			if n.index != r.index {
+				summary.renumber++
+				fileChanged = true
+			}
+			regions = append(regions, n)
+		}
+		if fileChanged {
+			summary.files++
+			changed[filename] = regionsText(regions)
+		}
+	}
+
+	for _, filename := range states {
+		state, err := LoadImageState(backend, filename)
+		if err != nil {
+			return summary, err
+		}
+		before := state.text()
+		var tracks []TrackBox
+		for _, t := range state.Tracks {
+			if n, keep, err := remap.region(t.Region, filename); err != nil {
+				return summary, err
+			} else if keep {
+				t.Region = n
+				tracks = append(tracks, t)
+			}
+		}
+		var rejected []Region
+		for _, r := range state.Rejected {
+			if n, keep, err := remap.region(r, filename); err != nil {
+				return summary, err
+			} else if keep {
+				rejected = append(rejected, n)
+			}
+		}
+		state.Tracks, state.Rejected = tracks, rejected
+		if after := state.text(); after != before {
+			summary.states++
+			changed[filename] = after
+		}
+	}
+	names, err := classNamesFiles(backend, remap.names)
+	if err != nil {
+		return summary, err
+	}
+	for filename, text := range names {
+		changed[filename] = text
+	}
+	// The colours and hotkeys are kept by name, so follow renames and
+	// merges.
+	old, err := loadClassNames(backend)
+	if err != nil {
+		return summary, err
+	}
+	settings, err := LoadDatasetSettings(backend)
+	if err != nil {
+		return summary, err
+	}
+	before := settings.text()
+	settings.ClassColors = remapByClass(settings.ClassColors, old, remap)
+	settings.ClassKeys = remapByClass(settings.ClassKeys, old, remap)
+	if after := settings.text(); after != before {
+		changed[datasetSettingsFile] = after
+	}
+	summary.changed = slices.Sorted(maps.Keys(changed))
+	if dryRun {
+		return summary, nil
+	}
+
+	if err := replaceFiles(backend, changed, remapSuffix); err != nil {
+		return summary, err
+	}
+	log.Printf("Remapped classes to %s: %s", strings.Join(remap.names, ", "), summary)
+	return summary, nil
+}
+
+// remapByClass returns values, which are kept by old class name, moved to
+// the names of the classes remap puts those classes in. A class keeps its
+// own value if it has one, and otherwise takes that of the lowest numbered
+// class merged into it which has one. The values of deleted classes are dropped.
+func remapByClass[V any](values map[string]V, old []string, remap classRemap) map[string]V {
+	if values == nil {
+		return nil
+	}
+	remapped := map[string]V{}
+	for i, name := range old {
+		if i >= len(remap.from) || remap.from[i] < 0 {
+			continue
+		}
+		v, ok := values[name]
+		if !ok {
+			continue
+		}
+		to := remap.names[remap.from[i]]
+		if _, taken := remapped[to]; !taken || name == to {
+			remapped[to] = v
+		}
+	}
+	return remapped
+}
+
+// remapResult is the outcome of remapping the classes in the background.
+type remapResult struct {
+	remap   classRemap
+	summary remapSummary
+	dryRun  bool
+	err     error
+}
+
+// startRemap remaps the dataset's classes to spec in the background, or with
+// dryRun only counts what would change. The result arrives on remapResults.
+func (m *appModel) startRemap(spec string, dryRun bool) error {
+	if m.remapping || m.labelsLocked() {
+		return nil
+	}
+	remap, err := parseClassRemap(spec, m.labels)
+	if err != nil {
+		return err
+	}
+	m.runRemap(remap, dryRun)
+	return nil
+}
+
+// runRemap applies remap in the background, or with dryRun only counts what
+// would change.
+func (m *appModel) runRemap(remap classRemap, dryRun bool) {
+	m.remapping = true
+	m.remapDryRun = dryRun
+	m.remapStatus = "Remapping…"
+	if dryRun {
+		m.remapStatus = "Previewing…"
+	}
+	backend, labels := m.backend, slices.Clone(m.labels)
+	go func() {
+		summary, err := remapClasses(backend, remap, dryRun)
+		if err == nil && dryRun {
+			log.Printf("Remapping classes to %s would change:\n%s", strings.Join(remap.names, ", "), summary.report(remap, labels))
+		}
+		m.remapResults <- remapResult{remap: remap, summary: summary, dryRun: dryRun, err: err}
+	}()
+}
+
+// labelsLocked reports whether a remap, orientation conversion or track
+// edit is rewriting the label files, when they mustn't be edited: the edit
+// would be overwritten, or saved afterwards with the old class numbers or
+// coordinates.
+func (m *appModel) labelsLocked() bool {
+	return (m.remapping && !m.remapDryRun) || m.reorienting || m.tracking
+}
+
+// remapDone shows the result of a remap.
+func (m *appModel) remapDone(res remapResult) {
+	m.remapping = false
+	switch {
+	case res.err != nil:
+		log.Printf("Error remapping classes: %s", res.err)
+		m.remapStatus = "Remap failed: " + res.err.Error()
+	case res.dryRun:
+		m.remapStatus = "Would change " + res.summary.String()
+	default:
+		m.remapStatus = "Changed " + res.summary.String()
+		// Keep drawing with the same class, wherever it has moved to.
+		m.drawingIndex = max(res.remap.class(m.drawingIndex), 0)
+		m.galleryClass = max(res.remap.class(m.galleryClass), 0)
+	}
+}
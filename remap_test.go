@@ -0,0 +1,135 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"testing"
+)
+
+func TestParseClassRemap(t *testing.T) {
+	old := []string{"car", "truck", "person", "bike"}
+	tests := []struct {
+		name    string
+		spec    string
+		names   []string
+		from    []int
+		wantErr bool
+	}{
+		{
+			name:  "keep and reorder",
+			spec:  "person; car; truck; bike",
+			names: []string{"person", "car", "truck", "bike"},
+			from:  []int{1, 2, 0, 3},
+		},
+		{
+			name:  "merge and delete",
+			spec:  "vehicle=car,truck; person",
+			names: []string{"vehicle", "person"},
+			from:  []int{0, 0, 1, -1},
+		},
+		{
+			name:  "rename by number",
+			spec:  "pedestrian=2; car",
+			names: []string{"pedestrian", "car"},
+			from:  []int{1, -1, 0, -1},
+		},
+		{
+			name:  "new empty class",
+			spec:  "car; dog=",
+			names: []string{"car", "dog"},
+			from:  []int{0, -1, -1, -1},
+		},
+		{
+			name:  "spaces and trailing separator",
+			spec:  " car = car , truck ;; ",
+			names: []string{"car"},
+			from:  []int{0, 0, -1, -1},
+		},
+		{name: "unknown class", spec: "dog", wantErr: true},
+		{name: "unknown number", spec: "x=7", wantErr: true},
+		{name: "class used twice", spec: "a=car; b=car", wantErr: true},
+		{name: "name listed twice", spec: "car; car=truck", wantErr: true},
+		{name: "no name", spec: "=car", wantErr: true},
+		{name: "empty", spec: " ; ", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			remap, err := parseClassRemap(tt.spec, old)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseClassRemap(%q) = %v, want an error", tt.spec, remap)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseClassRemap(%q): %s", tt.spec, err)
+			}
+			if !slices.Equal(remap.names, tt.names) || !slices.Equal(remap.from, tt.from) {
+				t.Errorf("parseClassRemap(%q) = %v %v, want %v %v", tt.spec, remap.names, remap.from, tt.names, tt.from)
+			}
+		})
+	}
+}
+
+func TestClassRemapRegion(t *testing.T) {
+	remap, err := parseClassRemap("vehicle=car,truck; person", []string{"car", "truck", "person", "bike"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		class   int
+		want    int
+		keep    bool
+		wantErr bool
+	}{
+		{class: 0, want: 0, keep: true},
+		{class: 1, want: 0, keep: true},
+		{class: 2, want: 1, keep: true},
+		{class: 3, keep: false},
+		{class: 4, wantErr: true},
+		{class: -1, wantErr: true},
+	}
+	for _, tt := range tests {
+		r, keep, err := remap.region(Region{index: tt.class}, "labels/x.txt")
+		if (err != nil) != tt.wantErr {
+			t.Errorf("class %d: error %v, want error %v", tt.class, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if keep != tt.keep || keep && r.index != tt.want {
+			t.Errorf("class %d: got %d %v, want %d %v", tt.class, r.index, keep, tt.want, tt.keep)
+		}
+	}
+}
+
+func TestRemapByClass(t *testing.T) {
+	old := []string{"car", "truck", "person", "bike", "bus"}
+	keys := map[string]string{"truck": "T", "person": "P", "bike": "B", "bus": "U", "gone": "G"}
+	tests := []struct {
+		name string
+		spec string
+		want map[string]string
+	}{
+		{"unchanged", "car; truck; person; bike; bus", map[string]string{"truck": "T", "person": "P", "bike": "B", "bus": "U"}},
+		{"rename", "car; lorry=truck; person; bike; bus", map[string]string{"lorry": "T", "person": "P", "bike": "B", "bus": "U"}},
+		{"merge keeps its own", "vehicle=car,truck; person=person,bike; bus", map[string]string{"vehicle": "T", "person": "P", "bus": "U"}},
+		{"merge takes the lowest", "vehicle=bus,truck; person", map[string]string{"vehicle": "T", "person": "P"}},
+		{"delete", "car; person", map[string]string{"person": "P"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			remap, err := parseClassRemap(tt.spec, old)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := remapByClass(keys, old, remap); !maps.Equal(got, tt.want) {
+				t.Errorf("remapByClass = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if got := remapByClass[string](nil, old, classRemap{}); got != nil {
+		t.Errorf("remapByClass(nil) = %v, want nil", got)
+	}
+}
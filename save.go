@@ -0,0 +1,93 @@
+package main
+
+import (
+	"log"
+	"sync"
+
+	"github.com/AndreRenaud/fastmark/storage"
+)
+
+// labelWrites saves label files, state sidecars and the dataset settings in
+// the background.
+var labelWrites fileWriter
+
+// fileWriter writes files in the background, one write at a time per file,
+// so edits made in quick succession land in the order they were made. A
+// write queued while an earlier one to the same file is in progress replaces
+// any other still waiting, as only the newest contents matter.
+type fileWriter struct {
+	mu      sync.Mutex
+	idle    sync.Cond
+	pending map[fileWriteKey]*fileWrite
+}
+
+type fileWriteKey struct {
+	backend  storage.Storage
+	filename string
+}
+
+// fileWrite is a file's contents waiting to be written, and the callers
+// waiting to hear they have been.
+type fileWrite struct {
+	text    string
+	queued  bool
+	waiting []chan error
+}
+
+// write queues text to be written to filename. The returned channel receives
+// the outcome of the write, or of a newer one that replaced it; errors are
+// logged either way.
+func (w *fileWriter) write(backend storage.Storage, filename, text string) <-chan error {
+	done := make(chan error, 1)
+	key := fileWriteKey{backend, filename}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.pending == nil {
+		w.pending = map[fileWriteKey]*fileWrite{}
+		w.idle.L = &w.mu
+	}
+	if p := w.pending[key]; p != nil {
+		p.text, p.queued = text, true
+		p.waiting = append(p.waiting, done)
+		return done
+	}
+	w.pending[key] = &fileWrite{text: text, queued: true, waiting: []chan error{done}}
+	go w.run(key)
+	return done
+}
+
+// run writes key's file until nothing more is queued for it.
+func (w *fileWriter) run(key fileWriteKey) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for {
+		p := w.pending[key]
+		if !p.queued {
+			delete(w.pending, key)
+			if len(w.pending) == 0 {
+				w.idle.Broadcast()
+			}
+			return
+		}
+		text, waiting := p.text, p.waiting
+		p.queued, p.waiting = false, nil
+		w.mu.Unlock()
+		err := writeFileText(key.backend, key.filename, text)
+		if err != nil {
+			log.Printf("Error saving %s: %s", key.filename, err)
+		}
+		for _, done := range waiting {
+			done <- err
+		}
+		w.mu.Lock()
+	}
+}
+
+// flush waits until every queued write has finished.
+func (w *fileWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for len(w.pending) > 0 {
+		w.idle.Wait()
+	}
+}
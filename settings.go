@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image/color"
+	"log"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/AndreRenaud/fastmark/storage"
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+// datasetSettingsFile holds per-dataset options as "key value" lines, in the
+// dataset root next to labels.txt.
+const datasetSettingsFile = "fastmark.conf"
+
+// DatasetSettings are options that belong to a dataset rather than to
+// whoever happens to be labelling it.
+type DatasetSettings struct {
+	// OrientedLabels records that label coordinates are relative to images
+	// after their EXIF orientation is applied, as most training frameworks
+	// expect, rather than to the stored pixels.
+	OrientedLabels bool
+	// PredictionsDir is where a detector's predictions for the images are
+	// read from, relative to the dataset.
+	PredictionsDir string
+	// ClassColors and ClassKeys are the colours and hotkeys chosen for
+	// classes, by name, in place of the defaults.
+	ClassColors map[string]color.RGBA
+	ClassKeys   map[string]ebiten.Key
+
+	backend storage.Storage
+}
+
+// LoadDatasetSettings reads the dataset's settings. A missing file gives the
+// defaults, which can still be saved.
+func LoadDatasetSettings(backend storage.Storage) (DatasetSettings, error) {
+	settings := DatasetSettings{backend: backend}
+	file, err := backend.Open(datasetSettingsFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return settings, nil
+	} else if err != nil {
+		return settings, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), " ")
+		switch key {
+		case "labels-orientation":
+			settings.OrientedLabels = value == "exif"
+		case "predictions-dir":
+			settings.PredictionsDir = value
+		case "class-color":
+			value, name, _ := strings.Cut(value, " ")
+			c, err := parseHexColor(value)
+			if err != nil {
+				log.Printf("%s: %s", datasetSettingsFile, err)
+				continue
+			}
+			if settings.ClassColors == nil {
+				settings.ClassColors = map[string]color.RGBA{}
+			}
+			settings.ClassColors[name] = c
+		case "class-key":
+			value, name, _ := strings.Cut(value, " ")
+			var key ebiten.Key
+			if err := key.UnmarshalText([]byte(value)); err != nil {
+				log.Printf("%s: %s", datasetSettingsFile, err)
+				continue
+			}
+			if settings.ClassKeys == nil {
+				settings.ClassKeys = map[string]ebiten.Key{}
+			}
+			settings.ClassKeys[name] = key
+		}
+	}
+	return settings, scanner.Err()
+}
+
+// Save writes the settings, after any earlier saves.
+func (s DatasetSettings) Save() error {
+	return <-s.queueSave()
+}
+
+// SaveAsync saves the settings in the background, so the UI doesn't wait on
+// storage. Failures are logged.
+func (s DatasetSettings) SaveAsync() {
+	s.queueSave()
+}
+
+// queueSave hands the settings, as lines, to labelWrites, so saves made in
+// quick succession land in order.
+func (s DatasetSettings) queueSave() <-chan error {
+	if s.backend == nil {
+		done := make(chan error, 1)
+		done <- fmt.Errorf("No backend specified for saving settings")
+		return done
+	}
+	return labelWrites.write(s.backend, datasetSettingsFile, s.text())
+}
+
+// text formats the settings as "key value" lines.
+func (s DatasetSettings) text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "labels-orientation %s\n", s.orientationName())
+	if s.PredictionsDir != "" {
+		fmt.Fprintf(&b, "predictions-dir %s\n", s.PredictionsDir)
+	}
+	for _, name := range slices.Sorted(maps.Keys(s.ClassColors)) {
+		fmt.Fprintf(&b, "class-color %s %s\n", hexColor(s.ClassColors[name]), name)
+	}
+	for _, name := range slices.Sorted(maps.Keys(s.ClassKeys)) {
+		fmt.Fprintf(&b, "class-key %s %s\n", s.ClassKeys[name], name)
+	}
+	return b.String()
+}
+
+// predictionsDir is PredictionsDir, or the default if it isn't set.
+func (s DatasetSettings) predictionsDir() string {
+	if s.PredictionsDir == "" {
+		return defaultPredictionsDir
+	}
+	return s.PredictionsDir
+}
+
+// orientationName is how the label coordinate convention is written.
+func (s DatasetSettings) orientationName() string {
+	if s.OrientedLabels {
+		return "exif"
+	}
+	return "raw"
+}
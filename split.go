@@ -0,0 +1,516 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"math"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/AndreRenaud/fastmark/storage"
+)
+
+// splitNames are the sets a dataset is split into, in the order their
+// ratios are given.
+var splitNames = [3]string{"train", "val", "test"}
+
+// splitSpec is how to divide a dataset into training, validation and test
+// sets. It is parsed from space separated words:
+//
+//	80/10/10        the ratios of train, val and (optionally) test
+//	stratify        balance each class's regions across the sets
+//	group=sequence  keep numbered frames of one sequence in the same set
+//	group=_         ... or files named alike up to the first _
+//	seed=7          shuffle differently, but reproducibly
+//	dirs            move the files into images/train etc. rather than
+//	                writing the dataset's Darknet image lists
+type splitSpec struct {
+	ratios   [3]float64
+	stratify bool
+	group    string
+	seed     uint64
+	dirs     bool
+}
+
+func parseSplitSpec(spec string) (splitSpec, error) {
+	s := splitSpec{seed: 1}
+	haveRatios := false
+	for _, word := range strings.Fields(spec) {
+		key, value, _ := strings.Cut(word, "=")
+		switch {
+		case strings.Contains(word, "/"):
+			parts := strings.Split(word, "/")
+			if len(parts) < 2 || len(parts) > 3 {
+				return s, fmt.Errorf("ratios are train/val or train/val/test, e.g. 80/10/10")
+			}
+			var total float64
+			for i, part := range parts {
+				r, err := strconv.ParseFloat(part, 64)
+				if err != nil || r < 0 {
+					return s, fmt.Errorf("invalid ratio %q", part)
+				}
+				s.ratios[i] = r
+				total += r
+			}
+			if total == 0 || s.ratios[0] == 0 {
+				return s, fmt.Errorf("the training set can't be empty")
+			}
+			for i := range s.ratios {
+				s.ratios[i] /= total
+			}
+			haveRatios = true
+		case word == "stratify":
+			s.stratify = true
+		case word == "dirs":
+			s.dirs = true
+		case word == "lists":
+			s.dirs = false
+		case key == "group" && value != "":
+			s.group = value
+		case key == "seed":
+			seed, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return s, fmt.Errorf("invalid seed %q", value)
+			}
+			s.seed = seed
+		default:
+			return s, fmt.Errorf("unknown split option %q", word)
+		}
+	}
+	if !haveRatios {
+		return s, fmt.Errorf("no ratios given, e.g. 80/10/10")
+	}
+	return s, nil
+}
+
+// splitItem is a file to put in a set, with its regions, if it has any.
+type splitItem struct {
+	file    string
+	regions []Region
+}
+
+// splitGroupKey returns the group a file is kept together with. A video's
+// frames are always kept together, as the video can only be in one set.
+func splitGroupKey(file, group string) string {
+	if video, _, ok := parseVideoFrame(file); ok {
+		return splitGroupKey(video, group)
+	}
+	switch group {
+	case "":
+		return file
+	case "sequence":
+		if sequence, _, ok := sequenceFrame(file); ok {
+			return sequence
+		}
+		return file
+	}
+	dir, name := filepath.Split(labelBase(file))
+	prefix, _, _ := strings.Cut(name, group)
+	return dir + prefix
+}
+
+// splitGroup is files that go in the same set, and how many regions of each
+// class they have.
+type splitGroup struct {
+	key     string
+	items   []int
+	classes map[int]int
+}
+
+// assignSplits returns the set, as an index into splitNames, each item is
+// put in. Groups are shuffled with the seed and each goes in the set
+// furthest below its share: of the images, or, when stratifying, of the
+// regions of the classes the group has, rarest classes first.
+func assignSplits(items []splitItem, spec splitSpec) []int {
+	var groups []*splitGroup
+	byKey := map[string]*splitGroup{}
+	classTotals := map[int]int{}
+	for i, item := range items {
+		key := splitGroupKey(item.file, spec.group)
+		g := byKey[key]
+		if g == nil {
+			g = &splitGroup{key: key, classes: map[int]int{}}
+			byKey[key] = g
+			groups = append(groups, g)
+		}
+		g.items = append(g.items, i)
+		for _, r := range item.regions {
+			g.classes[r.index]++
+			classTotals[r.index]++
+		}
+	}
+	slices.SortFunc(groups, func(a, b *splitGroup) int { return cmp.Compare(a.key, b.key) })
+	rng := rand.New(rand.NewPCG(spec.seed, spec.seed))
+	rng.Shuffle(len(groups), func(i, j int) { groups[i], groups[j] = groups[j], groups[i] })
+
+	// rarity is the region count of a group's rarest class; groups of rare
+	// classes are placed first, while every set still has room for them.
+	rarity := func(g *splitGroup) int {
+		rarest := math.MaxInt
+		for class := range g.classes {
+			rarest = min(rarest, classTotals[class])
+		}
+		return rarest
+	}
+	if spec.stratify {
+		slices.SortStableFunc(groups, func(a, b *splitGroup) int { return cmp.Compare(rarity(a), rarity(b)) })
+	}
+
+	var images [3]int
+	classes := [3]map[int]int{{}, {}, {}}
+	assignment := make([]int, len(items))
+	for _, g := range groups {
+		best, bestNeed := -1, 0.0
+		for s, ratio := range spec.ratios {
+			if ratio == 0 {
+				continue
+			}
+			// How far the set is below its share, as a fraction of the total.
+			need := ratio - float64(images[s])/float64(len(items))
+			if spec.stratify && len(g.classes) > 0 {
+				need = 0
+				for class := range g.classes {
+					total := float64(classTotals[class])
+					need += ratio - float64(classes[s][class])/total
+				}
+				need /= float64(len(g.classes))
+			}
+			if best < 0 || need > bestNeed {
+				best, bestNeed = s, need
+			}
+		}
+		for _, i := range g.items {
+			assignment[i] = best
+		}
+		images[best] += len(g.items)
+		for class, n := range g.classes {
+			classes[best][class] += n
+		}
+	}
+	return assignment
+}
+
+// splitReport describes how many images, and regions of each class, went in
+// each set.
+func splitReport(items []splitItem, assignment []int, labels []string) string {
+	var b strings.Builder
+	for s, name := range splitNames {
+		images := 0
+		classes := map[int]int{}
+		for i, item := range items {
+			if assignment[i] != s {
+				continue
+			}
+			images++
+			for _, r := range item.regions {
+				classes[r.index]++
+			}
+		}
+		if images == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "%s: %d images", name, images)
+		for _, class := range slices.Sorted(maps.Keys(classes)) {
+			name := fmt.Sprint(class)
+			if class < len(labels) {
+				name = labels[class]
+			}
+			fmt.Fprintf(&b, ", %d %s", classes[class], name)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// splitDataset puts the items into sets as spec says, writing image lists
+// or moving the files into split directories, and returns the set each item
+// went in.
+func splitDataset(backend storage.Storage, items []splitItem, spec splitSpec, labels []string) ([]int, error) {
+	if len(items) == 0 {
+		return nil, fmt.Errorf("No images to split")
+	}
+	assignment := assignSplits(items, spec)
+	if spec.dirs {
+		return assignment, moveToSplitDirs(backend, items, assignment, spec, labels)
+	}
+	return assignment, writeSplitLists(backend, items, assignment, spec)
+}
+
+// splitResult is the outcome of splitting the dataset in the background.
+type splitResult struct {
+	report string
+	moved  bool
+	err    error
+}
+
+// startSplit splits the images as spec says in the background, using the
+// regions found by the metadata scan. Unlabelled images are split too, so
+// they stay part of the dataset. A split into directories only shows where
+// the images would go, and moves them if it is asked for again.
+func (m *appModel) startSplit(spec splitSpec) {
+	if m.splitting || m.labelsLocked() {
+		return
+	}
+	if meta := m.metadataSnapshot(); meta.Scanned < meta.Total {
+		m.splitStatus = "Wait for the metadata scan to finish"
+		return
+	}
+	var items []splitItem
+	m.metadataMu.Lock()
+	for i, s := range m.summaries {
+		items = append(items, splitItem{file: m.files[i], regions: s.regions})
+	}
+	m.metadataMu.Unlock()
+
+	if spec.dirs && (m.splitPreviewed == nil || *m.splitPreviewed != spec) {
+		if len(items) == 0 {
+			m.splitStatus = "No images to split"
+			return
+		}
+		report := splitReport(items, assignSplits(items, spec), m.labels)
+		log.Printf("Splitting the dataset would move:\n%s", report)
+		m.splitPreviewed = &spec
+		m.splitStatus = "Would move " + splitSummary(report) + "; split again to move the files"
+		return
+	}
+	m.splitPreviewed = nil
+	m.splitting = true
+	m.splitStatus = "Splitting…"
+	backend, labels := m.backend, slices.Clone(m.labels)
+	go func() {
+		assignment, err := splitDataset(backend, items, spec, labels)
+		var report string
+		if assignment != nil {
+			report = splitReport(items, assignment, labels)
+		}
+		m.splitResults <- splitResult{report: report, moved: spec.dirs, err: err}
+	}()
+}
+
+// splitDone applies the result of a split.
+func (m *appModel) splitDone(res splitResult) {
+	m.splitting = false
+	if res.err != nil {
+		log.Printf("Error splitting dataset: %s", res.err)
+		m.splitStatus = "Split failed"
+		return
+	}
+	log.Printf("Split the dataset:\n%s", res.report)
+	m.splitStatus = splitSummary(res.report)
+}
+
+// splitSummary shortens a splitReport to the number of images in each set.
+func splitSummary(report string) string {
+	var sets []string
+	for _, line := range strings.Split(strings.TrimSpace(report), "\n") {
+		set, _, _ := strings.Cut(line, ",")
+		sets = append(sets, set)
+	}
+	return strings.Join(sets, ", ")
+}
+
+// writeSplitLists writes each set's images to the dataset's Darknet image
+// list for it, or, for a set it has no list for, to train.txt, val.txt or
+// test.txt in the dataset root, which is added to its .data file. Entries
+// are relative to the dataset root. Other datasets have nothing that would
+// read the lists, so must be split into directories.
+func writeSplitLists(backend storage.Storage, items []splitItem, assignment []int, spec splitSpec) error {
+	layout, err := loadDatasetLayout(backend)
+	if err != nil {
+		return fmt.Errorf("loading %s: %w", layout.dataFile, err)
+	}
+	if layout.dataFile == "" || layout.dataFile == dataYAMLFile {
+		return fmt.Errorf("Only Darknet datasets have image lists; split with dirs to move the images into split directories")
+	}
+	var newLists []string
+	for s, name := range splitNames {
+		// Darknet calls the validation set "valid".
+		key := darknetSplits[s]
+		list, absolute, listed := name+".txt", false, false
+		for _, split := range layout.splits {
+			if split.list != "" && split.name == key {
+				list, listed = split.list, true
+				absolute = len(split.entries) > 0 && filepath.IsAbs(split.entries[0])
+			}
+		}
+		if spec.ratios[s] == 0 {
+			continue
+		}
+		var entries []string
+		for i, item := range items {
+			if assignment[i] == s {
+				entries = append(entries, listEntry(backend, item.file, absolute))
+			}
+		}
+		if err := writeLines(backend, list, entries); err != nil {
+			return err
+		}
+		log.Printf("Wrote %d images to %s", len(entries), list)
+		if !listed {
+			newLists = append(newLists, fmt.Sprintf("%s = %s", key, list))
+		}
+	}
+	if len(newLists) == 0 {
+		return nil
+	}
+	data, err := readFileText(backend, layout.dataFile)
+	if err != nil {
+		return err
+	}
+	if data != "" && !strings.HasSuffix(data, "\n") {
+		data += "\n"
+	}
+	log.Printf("Added %s to %s", strings.Join(newLists, ", "), layout.dataFile)
+	return writeFileText(backend, layout.dataFile, data+linesText(newLists))
+}
+
+// splitMove is a file to rename when moving images into split directories.
+type splitMove struct {
+	from, to string
+}
+
+// splitMoves returns the renames that put each item, with its label, sidecar
+// and predictions files, in its set's directory. Files keep their path below
+// the split directory of layout they are in, or below images/, so
+// subdirectories aren't flattened. A video moves as a whole, taking its
+// frames' files with it.
+func splitMoves(backend storage.Storage, layout datasetLayout, items []splitItem, assignment []int, predictionsDir string) []splitMove {
+	sidecars := []func(string) string{labelFileName, stateFileName, func(file string) string {
+		return predictionFileName(predictionsDir, file)
+	}}
+	// relative is a file's path below the split directory it is in.
+	relative := func(file string) string {
+		for _, split := range layout.splits {
+			if dir, ok := strings.CutPrefix(split.dir, "images/"); ok {
+				if rest, ok := strings.CutPrefix(file, dir+"/"); ok {
+					return rest
+				}
+			}
+		}
+		return file
+	}
+	var moves []splitMove
+	videos := map[string]bool{}
+	for i, item := range items {
+		set := splitNames[assignment[i]]
+		var to string
+		if video, frame, ok := parseVideoFrame(item.file); ok {
+			moved := filepath.ToSlash(filepath.Join(set, relative(video)))
+			to = videoFrameName(moved, frame)
+			if !videos[video] && moved != video {
+				moves = append(moves, splitMove{imagePath(video), imagePath(moved)})
+			}
+			videos[video] = true
+		} else {
+			to = filepath.ToSlash(filepath.Join(set, relative(item.file)))
+			if to != item.file {
+				moves = append(moves, splitMove{imagePath(item.file), imagePath(to)})
+			}
+		}
+		if to == item.file {
+			continue
+		}
+		for _, sidecar := range sidecars {
+			if _, err := backend.Stat(sidecar(item.file)); err == nil {
+				moves = append(moves, splitMove{sidecar(item.file), sidecar(to)})
+			}
+		}
+	}
+	return moves
+}
+
+// moveToSplitDirs moves each image, with its label, sidecar and predictions
+// files, into its set's directory under images/ (and labels/), and writes a
+// data.yaml describing them if the dataset doesn't have one. Every
+// destination is checked before anything is moved, and the moves made are
+// undone if one fails, so the dataset is either split or left as it was.
+func moveToSplitDirs(backend storage.Storage, items []splitItem, assignment []int, spec splitSpec, labels []string) error {
+	settings, err := LoadDatasetSettings(backend)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		if strings.HasPrefix(item.file, "/") {
+			return fmt.Errorf("Only images in images/ can be moved into split directories; split %s into lists instead", item.file)
+		}
+	}
+	// Edits still being saved would land where the files used to be.
+	labelWrites.flush()
+
+	layout, err := loadDatasetLayout(backend)
+	if err != nil {
+		return fmt.Errorf("loading %s: %w", layout.dataFile, err)
+	}
+	moves := splitMoves(backend, layout, items, assignment, settings.predictionsDir())
+	destinations := map[string]bool{}
+	for _, move := range moves {
+		if destinations[move.to] {
+			return fmt.Errorf("More than one file would be moved to %s; rename one of them first", move.to)
+		}
+		destinations[move.to] = true
+		if _, err := backend.Stat(move.to); err == nil {
+			return fmt.Errorf("%s already exists", move.to)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	for i, move := range moves {
+		if err := backend.Rename(move.from, move.to); err != nil {
+			for _, done := range slices.Backward(moves[:i]) {
+				if err := backend.Rename(done.to, done.from); err != nil {
+					log.Printf("Error moving %s back to %s: %s", done.to, done.from, err)
+				}
+			}
+			return fmt.Errorf("moving %s: %w", move.from, err)
+		}
+	}
+	// The cache holds label files under their old names.
+	if cache != nil {
+		cache.Purge()
+	}
+	log.Printf("Moved %d files into split directories", len(moves))
+
+	if _, err := backend.Stat(dataYAMLFile); err == nil {
+		log.Printf("Not changing the existing %s; check its splits are images/train, images/val and images/test", dataYAMLFile)
+		return nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	var b strings.Builder
+	for s, name := range splitNames {
+		if spec.ratios[s] > 0 {
+			fmt.Fprintf(&b, "%s: images/%s\n", name, name)
+		}
+	}
+	b.WriteString(yamlNames(labels))
+	return writeFileText(backend, dataYAMLFile, b.String())
+}
+
+// datasetSplitItems lists every image with the regions of those that have a
+// label file, for splitting from the command line.
+func datasetSplitItems(backend storage.Storage) ([]splitItem, error) {
+	files, err := listImages(backend)
+	if err != nil {
+		return nil, err
+	}
+	var items []splitItem
+	for _, file := range files {
+		item := splitItem{file: file}
+		if _, err := backend.Stat(labelFileName(file)); err == nil {
+			regions, err := LoadRegionList(backend, labelFileName(file))
+			if err != nil {
+				return nil, err
+			}
+			item.regions = regions.Regions
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
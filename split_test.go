@@ -0,0 +1,286 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/AndreRenaud/fastmark/storage"
+)
+
+func TestParseSplitSpec(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    splitSpec
+		wantErr bool
+	}{
+		{spec: "80/10/10", want: splitSpec{ratios: [3]float64{0.8, 0.1, 0.1}, seed: 1}},
+		{spec: "3/1", want: splitSpec{ratios: [3]float64{0.75, 0.25, 0}, seed: 1}},
+		{
+			spec: "70/20/10 stratify group=sequence seed=7 dirs",
+			want: splitSpec{ratios: [3]float64{0.7, 0.2, 0.1}, stratify: true, group: "sequence", seed: 7, dirs: true},
+		},
+		{spec: "group=_ 90/10 dirs lists", want: splitSpec{ratios: [3]float64{0.9, 0.1, 0}, group: "_", seed: 1}},
+		{spec: "", wantErr: true},
+		{spec: "stratify", wantErr: true},
+		{spec: "80", wantErr: true},
+		{spec: "70/10/10/10", wantErr: true},
+		{spec: "0/50/50", wantErr: true},
+		{spec: "80/x", wantErr: true},
+		{spec: "80/-20", wantErr: true},
+		{spec: "80/20 seed=x", wantErr: true},
+		{spec: "80/20 group=", wantErr: true},
+		{spec: "80/20 shuffle", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := parseSplitSpec(tt.spec)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseSplitSpec(%q) = %+v, want an error", tt.spec, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseSplitSpec(%q): %s", tt.spec, err)
+			}
+			ratios := got.ratios
+			got.ratios = tt.want.ratios
+			if got != tt.want {
+				t.Errorf("parseSplitSpec(%q) = %+v, want %+v", tt.spec, got, tt.want)
+			}
+			for i := range ratios {
+				if math.Abs(ratios[i]-tt.want.ratios[i]) > 1e-9 {
+					t.Errorf("ratios = %v, want %v", ratios, tt.want.ratios)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestSplitGroupKey(t *testing.T) {
+	tests := []struct {
+		file, group, want string
+	}{
+		{"a.jpg", "", "a.jpg"},
+		{"clip-0001.png", "", "clip-0001.png"},
+		{"clip-0001.png", "sequence", "clip-"},
+		{"photo.jpg", "sequence", "photo.jpg"},
+		{"clip.mp4@000003", "", "clip.mp4"},
+		{"clip.mp4@000003", "sequence", "clip.mp4"},
+		{"cam1_0001.jpg", "_", "cam1"},
+		{"day/cam1_0001.jpg", "_", "day/cam1"},
+		{"nounderscore.jpg", "_", "nounderscore"},
+	}
+	for _, tt := range tests {
+		if got := splitGroupKey(tt.file, tt.group); got != tt.want {
+			t.Errorf("splitGroupKey(%q, %q) = %q, want %q", tt.file, tt.group, got, tt.want)
+		}
+	}
+}
+
+// setCounts counts the items assigned to each set.
+func setCounts(assignment []int) [3]int {
+	var counts [3]int
+	for _, s := range assignment {
+		counts[s]++
+	}
+	return counts
+}
+
+func TestAssignSplits(t *testing.T) {
+	images := func(n int) []splitItem {
+		items := make([]splitItem, n)
+		for i := range items {
+			items[i].file = fmt.Sprintf("img%03d.jpg", i)
+		}
+		return items
+	}
+	// frames gives 20 sequences of 5 numbered frames.
+	frames := func() []splitItem {
+		var items []splitItem
+		for s := range 20 {
+			for f := range 5 {
+				items = append(items, splitItem{file: fmt.Sprintf("seq%02d-%04d.png", s, f)})
+			}
+		}
+		return items
+	}
+	// rare gives 100 images, every tenth with a region of a rare class and
+	// the rest with a common one.
+	rare := func() []splitItem {
+		items := images(100)
+		for i := range items {
+			class := 0
+			if i%10 == 0 {
+				class = 1
+			}
+			items[i].regions = []Region{{index: class}}
+		}
+		return items
+	}
+
+	tests := []struct {
+		name  string
+		items []splitItem
+		spec  string
+		want  [3]int
+		check func(t *testing.T, items []splitItem, assignment []int)
+	}{
+		{name: "ratios", items: images(100), spec: "80/10/10", want: [3]int{80, 10, 10}},
+		{name: "no test set", items: images(10), spec: "70/30", want: [3]int{7, 3, 0}},
+		{
+			name:  "sequences kept together",
+			items: frames(),
+			spec:  "80/10/10 group=sequence",
+			want:  [3]int{80, 10, 10},
+			check: func(t *testing.T, items []splitItem, assignment []int) {
+				for i := range items {
+					if first := i - i%5; assignment[i] != assignment[first] {
+						t.Errorf("%s is in set %d but %s in %d", items[i].file, assignment[i], items[first].file, assignment[first])
+					}
+				}
+			},
+		},
+		{
+			name: "video frames kept together",
+			items: []splitItem{
+				{file: "a.mp4@000000"}, {file: "a.mp4@000001"}, {file: "a.mp4@000002"},
+				{file: "b.jpg"}, {file: "c.jpg"},
+			},
+			spec: "60/40",
+			check: func(t *testing.T, items []splitItem, assignment []int) {
+				if assignment[0] != assignment[1] || assignment[0] != assignment[2] {
+					t.Errorf("frames of a.mp4 split across sets: %v", assignment[:3])
+				}
+			},
+		},
+		{
+			name:  "stratified",
+			items: rare(),
+			spec:  "80/10/10 stratify",
+			want:  [3]int{80, 10, 10},
+			check: func(t *testing.T, items []splitItem, assignment []int) {
+				var rare [3]int
+				for i, item := range items {
+					if item.regions[0].index == 1 {
+						rare[assignment[i]]++
+					}
+				}
+				if rare != [3]int{8, 1, 1} {
+					t.Errorf("rare class regions per set = %v, want [8 1 1]", rare)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec, err := parseSplitSpec(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assignment := assignSplits(tt.items, spec)
+			if len(assignment) != len(tt.items) {
+				t.Fatalf("%d assignments for %d items", len(assignment), len(tt.items))
+			}
+			if tt.want != ([3]int{}) {
+				if got := setCounts(assignment); got != tt.want {
+					t.Errorf("set sizes = %v, want %v", got, tt.want)
+				}
+			}
+			if tt.check != nil {
+				tt.check(t, tt.items, assignment)
+			}
+			if again := assignSplits(tt.items, spec); !slices.Equal(again, assignment) {
+				t.Errorf("the same seed gave a different split")
+			}
+		})
+	}
+}
+
+func TestSplitMoves(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"images/a.jpg", "labels/a.txt", "labels/a.status", "preds/a.txt",
+		"images/b.jpg",
+		"images/val/c.jpg", "labels/val/c.txt",
+		"images/sub/d.jpg", "labels/sub/d.txt",
+		"images/train/deep/e.jpg", "labels/train/deep/e.txt",
+		"images/clip.mp4", "labels/clip-000001.txt",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	items := []splitItem{
+		{file: "a.jpg"}, {file: "b.jpg"}, {file: "val/c.jpg"},
+		{file: "sub/d.jpg"}, {file: "train/deep/e.jpg"},
+		{file: "clip.mp4@000000"}, {file: "clip.mp4@000001"},
+	}
+	assignment := []int{0, 2, 1, 0, 1, 1, 1}
+	layout := datasetLayout{splits: []datasetSplit{
+		{name: "train", dir: "images/train"},
+		{name: "val", dir: "images/val"},
+	}}
+	got := splitMoves(storage.NewStorage(dir), layout, items, assignment, "preds")
+	want := []splitMove{
+		{"images/a.jpg", "images/train/a.jpg"},
+		{"labels/a.txt", "labels/train/a.txt"},
+		{"labels/a.status", "labels/train/a.status"},
+		{"preds/a.txt", "preds/train/a.txt"},
+		{"images/b.jpg", "images/test/b.jpg"},
+		// val/c.jpg is already in place.
+		{"images/sub/d.jpg", "images/train/sub/d.jpg"},
+		{"labels/sub/d.txt", "labels/train/sub/d.txt"},
+		{"images/train/deep/e.jpg", "images/val/deep/e.jpg"},
+		{"labels/train/deep/e.txt", "labels/val/deep/e.txt"},
+		{"images/clip.mp4", "images/val/clip.mp4"},
+		{"labels/clip-000001.txt", "labels/val/clip-000001.txt"},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("splitMoves =\n%v\nwant\n%v", got, want)
+	}
+}
+
+func TestWriteSplitLists(t *testing.T) {
+	dir := t.TempDir()
+	backend := storage.NewStorage(dir)
+	items := []splitItem{{file: "/data/a.jpg"}, {file: "/data/b.jpg"}, {file: "/data/c.jpg"}}
+	assignment := []int{0, 1, 0}
+	spec := splitSpec{ratios: [3]float64{0.7, 0.3, 0}}
+	if err := writeSplitLists(backend, items, assignment, spec); err == nil {
+		t.Errorf("writeSplitLists succeeded without a .data file")
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "obj.data"), []byte("classes = 1\ntrain = lists/train.txt"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeSplitLists(backend, items, assignment, spec); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"obj.data":        "classes = 1\ntrain = lists/train.txt\nvalid = val.txt\n",
+		"lists/train.txt": "data/a.jpg\ndata/c.jpg\n",
+		"val.txt":         "data/b.jpg\n",
+	}
+	for name, content := range want {
+		got, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != content {
+			t.Errorf("%s = %q, want %q", name, got, content)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "test.txt")); err == nil {
+		t.Errorf("wrote test.txt for an empty test set")
+	}
+}
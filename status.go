@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image/color"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/AndreRenaud/fastmark/storage"
+)
+
+// ImageStatus is where an image sits in the labelling/review workflow.
+// Unlabelled and Labelled are derived from the image's regions; the review
+// statuses are set explicitly and stored in a sidecar next to the labels.
+type ImageStatus int
+
+const (
+	StatusUnlabelled ImageStatus = iota
+	StatusLabelled
+	StatusNeedsReview
+	StatusApproved
+	StatusRejected
+
+	statusCount
+)
+
+var statusNames = [statusCount]string{"unlabelled", "labelled", "needs-review", "approved", "rejected"}
+
+func (s ImageStatus) String() string {
+	if s >= 0 && s < statusCount {
+		return statusNames[s]
+	}
+	return "unknown"
+}
+
+func parseImageStatus(name string) (ImageStatus, bool) {
+	for i, n := range statusNames {
+		if n == name {
+			return ImageStatus(i), true
+		}
+	}
+	return StatusUnlabelled, false
+}
+
+// Color is used to tint the file list. Labelled images keep the default text
+// colour so the review statuses stand out.
+func (s ImageStatus) Color() color.Color {
+	switch s {
+	case StatusUnlabelled:
+		return color.RGBA{0x90, 0x90, 0x90, 0xff}
+	case StatusNeedsReview:
+		return color.RGBA{0xe0, 0x90, 0x00, 0xff}
+	case StatusApproved:
+		return color.RGBA{0x20, 0xa0, 0x40, 0xff}
+	case StatusRejected:
+		return color.RGBA{0xe0, 0x30, 0x30, 0xff}
+	default:
+		return nil
+	}
+}
+
+// ImageState is the per-image review information stored in
+// labels/<image>.status as "key value" lines.
+type ImageState struct {
+	Status  ImageStatus
+	Comment string
+	// Empty records that the image was checked and has no objects, so an
+	// empty label file is a verified negative rather than unlabelled.
+	Empty bool
+	// Tracks are the regions belonging to tracks through a frame sequence.
+	Tracks []TrackBox
+	// Rejected are the detector's suggestions marked as wrong.
+	Rejected []Region
+
+	filename string
+	backend  storage.Storage
+}
+
+// stateFileName returns the sidecar that holds the review state for an image.
+func stateFileName(image string) string {
+	if base, ok := strings.CutPrefix(labelBase(image), "/"); ok {
+		return darknetLabelPath(base) + ".status"
+	}
+	return filepath.Join("labels", labelBase(image)+".status")
+}
+
+// LoadImageState reads a state sidecar. A missing sidecar is not logged, as
+// most images won't have one; the returned state can still be saved.
+func LoadImageState(backend storage.Storage, filename string) (ImageState, error) {
+	state := ImageState{filename: filename, backend: backend}
+	file, err := backend.Open(filename)
+	if err != nil {
+		return state, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), " ")
+		switch key {
+		case "status":
+			if status, ok := parseImageStatus(value); ok {
+				state.Status = status
+			}
+		case "comment":
+			state.Comment = value
+		case "empty":
+			state.Empty = value == "true"
+		case "rejected":
+			if regions := parseRegions(strings.NewReader(value), filename); len(regions) == 1 {
+				state.Rejected = append(state.Rejected, regions[0])
+			}
+		case "track":
+			if t, ok := parseTrackBox(value); ok {
+				state.Tracks = append(state.Tracks, t)
+			}
+		}
+	}
+	return state, scanner.Err()
+}
+
+// Save writes the state, after any earlier saves of the same file.
+func (s ImageState) Save() error {
+	return <-s.queueSave()
+}
+
+// SaveAsync saves the state in the background, so the UI doesn't wait on
+// storage. Failures are logged.
+func (s ImageState) SaveAsync() {
+	s.queueSave()
+}
+
+// queueSave hands the state, as sidecar lines, to labelWrites.
+func (s ImageState) queueSave() <-chan error {
+	if s.filename == "" {
+		log.Printf("No filename specified for saving state")
+		done := make(chan error, 1)
+		done <- fmt.Errorf("No filename specified for saving state")
+		return done
+	}
+	return labelWrites.write(s.backend, s.filename, s.text())
+}
+
+// text formats the state as sidecar lines.
+func (s ImageState) text() string {
+	var b strings.Builder
+	if s.Status >= StatusNeedsReview {
+		fmt.Fprintf(&b, "status %s\n", s.Status)
+	}
+	if s.Empty {
+		fmt.Fprintf(&b, "empty true\n")
+	}
+	if s.Comment != "" {
+		// Keep the comment on a single line so the file stays line-based.
+		fmt.Fprintf(&b, "comment %s\n", strings.Join(strings.Fields(s.Comment), " "))
+	}
+	for _, t := range s.Tracks {
+		fmt.Fprintf(&b, "track %s\n", t)
+	}
+	for _, r := range s.Rejected {
+		fmt.Fprintf(&b, "rejected %s", regionsText([]Region{r}))
+	}
+	return b.String()
+}
+
+// effectiveStatus combines an explicit review status with whether the image
+// has any regions. A verified-empty image counts as labelled.
+func effectiveStatus(state ImageState, regions int) ImageStatus {
+	if state.Status >= StatusNeedsReview {
+		return state.Status
+	}
+	if regions > 0 || state.Empty {
+		return StatusLabelled
+	}
+	return StatusUnlabelled
+}
@@ -13,6 +13,9 @@ type Storage interface {
 	Open(filename string) (io.ReadCloser, error)
 	OpenWrite(filename string, append bool) (io.WriteCloser, error)
 	Glob(directory string, pattern string) ([]string, error)
+	Stat(filename string) (os.FileInfo, error)
+	Rename(from string, to string) error
+	Remove(filename string) error
 	Describe() string
 	Disconnect()
 }
@@ -77,6 +80,29 @@ func (s LocalStorage) Glob(directory string, pattern string) ([]string, error) {
 	return filepath.Glob(glob)
 }
 
+func (s LocalStorage) Stat(filename string) (os.FileInfo, error) {
+	return os.Stat(s.fullPath(filename))
+}
+
+// Rename moves a file, creating the directory it is moved into and
+// replacing any file already there.
+func (s LocalStorage) Rename(from string, to string) error {
+	if err := os.MkdirAll(filepath.Dir(s.fullPath(to)), 0755); err != nil {
+		return err
+	}
+	return os.Rename(s.fullPath(from), s.fullPath(to))
+}
+
+func (s LocalStorage) Remove(filename string) error {
+	return os.Remove(s.fullPath(filename))
+}
+
+// LocalPath returns the path of filename on the local filesystem, for tools
+// such as ffmpeg that need to open files themselves.
+func (s LocalStorage) LocalPath(filename string) string {
+	return s.fullPath(filename)
+}
+
 func (s LocalStorage) Describe() string {
 	return filepath.Clean(s.prefix)
 }
@@ -94,6 +120,15 @@ func (d DummyStorage) OpenWrite(filename string, append bool) (io.WriteCloser, e
 func (d DummyStorage) Glob(directory string, pattern string) ([]string, error) {
 	return nil, fmt.Errorf("dummy storage")
 }
+func (d DummyStorage) Stat(filename string) (os.FileInfo, error) {
+	return nil, fmt.Errorf("dummy storage")
+}
+func (d DummyStorage) Rename(from string, to string) error {
+	return fmt.Errorf("dummy storage")
+}
+func (d DummyStorage) Remove(filename string) error {
+	return fmt.Errorf("dummy storage")
+}
 func (d DummyStorage) Describe() string {
 	return "dummy storage"
 }
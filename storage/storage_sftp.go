@@ -93,3 +93,20 @@ func (s *SFTPStorage) Glob(directory string, pattern string) ([]string, error) {
 	fullname := s.fullPath(directory)
 	return s.client.Glob(filepath.Join(fullname, pattern))
 }
+
+func (s *SFTPStorage) Stat(filename string) (os.FileInfo, error) {
+	return s.client.Stat(s.fullPath(filename))
+}
+
+// Rename moves a file, creating the directory it is moved into and
+// replacing any file already there, which plain SFTP renames refuse to do.
+func (s *SFTPStorage) Rename(from string, to string) error {
+	if err := s.client.MkdirAll(filepath.Dir(s.fullPath(to))); err != nil {
+		return err
+	}
+	return s.client.PosixRename(s.fullPath(from), s.fullPath(to))
+}
+
+func (s *SFTPStorage) Remove(filename string) error {
+	return s.client.Remove(s.fullPath(filename))
+}
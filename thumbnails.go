@@ -0,0 +1,178 @@
+package main
+
+import (
+	"image"
+	"log"
+	"slices"
+	"sync"
+
+	"github.com/AndreRenaud/fastmark/storage"
+	"github.com/hajimehoshi/ebiten/v2"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"golang.org/x/image/draw"
+)
+
+// thumbnailSize is the longest side, in pixels, of a decoded thumbnail.
+const thumbnailSize = 192
+
+// thumbnailCacheSize bounds how many thumbnail textures are kept on the GPU.
+const thumbnailCacheSize = 1000
+
+// thumbnailLoad produces a small image for a cache key in the background.
+type thumbnailLoad func(backend storage.Storage) (image.Image, error)
+
+// thumbnailLoader returns the loader for a whole-image thumbnail of file.
+func (m *appModel) thumbnailLoader(file string) thumbnailLoad {
+	return func(backend storage.Storage) (image.Image, error) {
+		img, orientation, err := loadImage(backend, imagePath(file))
+		if err != nil {
+			return nil, err
+		}
+		m.orientations.set(file, orientation)
+		return scaleToFit(adjustImage(img, defaultAdjust), thumbnailSize), nil
+	}
+}
+
+type thumbnailRequest struct {
+	key  string
+	load thumbnailLoad
+}
+
+type thumbnailResult struct {
+	gen int
+	key string
+	img image.Image
+}
+
+// thumbnailCache decodes thumbnails on a pool of background workers and
+// keeps the most recently used ones as textures. Requests are served newest
+// first, so whatever is on screen now is decoded before anything that has
+// since scrolled away; retain drops queued requests that are no longer
+// wanted. Apart from the queue, it is only used on the main goroutine.
+type thumbnailCache struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queue   []thumbnailRequest
+	backend storage.Storage
+	gen     int
+
+	images  *lru.Cache[string, *ebiten.Image]
+	pending map[string]bool
+	results chan thumbnailResult
+}
+
+func (c *thumbnailCache) start(backend storage.Storage) {
+	c.cond = sync.NewCond(&c.mu)
+	c.backend = backend
+	c.pending = map[string]bool{}
+	c.results = make(chan thumbnailResult, 64)
+	c.images, _ = lru.NewWithEvict(thumbnailCacheSize, func(key string, img *ebiten.Image) {
+		if img != nil {
+			img.Deallocate()
+		}
+	})
+	// Decoding is CPU bound once the file has been read, so a handful of
+	// workers is enough even over SFTP.
+	const workerCount = 8
+	for range workerCount {
+		go c.worker()
+	}
+}
+
+func (c *thumbnailCache) worker() {
+	for {
+		c.mu.Lock()
+		for len(c.queue) == 0 {
+			c.cond.Wait()
+		}
+		req := c.queue[len(c.queue)-1]
+		c.queue = c.queue[:len(c.queue)-1]
+		gen := c.gen
+		backend := c.backend
+		c.mu.Unlock()
+
+		img, err := req.load(backend)
+		if err != nil {
+			log.Printf("Error loading thumbnail %s: %s", req.key, err)
+		}
+		// A failed decode is still delivered so it isn't retried forever.
+		c.results <- thumbnailResult{gen: gen, key: req.key, img: img}
+	}
+}
+
+// reset discards every thumbnail, e.g. because the directory changed.
+func (c *thumbnailCache) reset(backend storage.Storage) {
+	c.mu.Lock()
+	c.gen++
+	c.queue = nil
+	c.backend = backend
+	c.mu.Unlock()
+	c.images.Purge()
+	clear(c.pending)
+}
+
+// get returns the thumbnail for key, queueing load if it isn't cached.
+func (c *thumbnailCache) get(key string, load thumbnailLoad) (*ebiten.Image, bool) {
+	if img, ok := c.images.Get(key); ok {
+		return img, img != nil
+	}
+	if c.pending[key] {
+		return nil, false
+	}
+	c.pending[key] = true
+	c.mu.Lock()
+	c.queue = append(c.queue, thumbnailRequest{key: key, load: load})
+	c.mu.Unlock()
+	c.cond.Signal()
+	return nil, false
+}
+
+// retain drops queued decodes for keys that aren't in keep.
+func (c *thumbnailCache) retain(keep func(key string) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.queue = slices.DeleteFunc(c.queue, func(req thumbnailRequest) bool {
+		if keep(req.key) {
+			return false
+		}
+		delete(c.pending, req.key)
+		return true
+	})
+}
+
+// update turns finished decodes into textures. It must be called on the
+// main goroutine, and reports whether anything new arrived.
+func (c *thumbnailCache) update() bool {
+	updated := false
+	for {
+		select {
+		case res := <-c.results:
+			if res.gen != c.gen {
+				continue
+			}
+			delete(c.pending, res.key)
+			var img *ebiten.Image
+			if res.img != nil {
+				img = ebiten.NewImageFromImage(res.img)
+			}
+			c.images.Add(res.key, img)
+			updated = true
+		default:
+			return updated
+		}
+	}
+}
+
+// scaleToFit downscales img so its longest side is at most size.
+func scaleToFit(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	if b.Dx() <= size && b.Dy() <= size {
+		return img
+	}
+	scale := min(float64(size)/float64(b.Dx()), float64(size)/float64(b.Dy()))
+	w := max(1, int(float64(b.Dx())*scale))
+	h := max(1, int(float64(b.Dy())*scale))
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.ApproxBiLinear.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
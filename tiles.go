@@ -0,0 +1,85 @@
+package main
+
+import (
+	"image"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"golang.org/x/image/draw"
+)
+
+// maxTileSize is the side of the textures a large image is split into. It is
+// well within the texture limit of any GPU Ebitengine runs on.
+const maxTileSize = 4096
+
+// tiledImage is an image drawn from several textures so images larger than
+// the GPU texture limit can be shown at their native resolution.
+type tiledImage struct {
+	size  image.Point
+	tiles []imageTile
+}
+
+type imageTile struct {
+	rect image.Rectangle // within the image, zero-origin
+	img  *ebiten.Image
+}
+
+// newTiledImage uploads img as textures. It must run on the main goroutine.
+func newTiledImage(img image.Image) *tiledImage {
+	b := img.Bounds()
+	t := &tiledImage{size: b.Size()}
+	for y := 0; y < b.Dy(); y += maxTileSize {
+		for x := 0; x < b.Dx(); x += maxTileSize {
+			r := image.Rect(x, y, min(x+maxTileSize, b.Dx()), min(y+maxTileSize, b.Dy()))
+			t.tiles = append(t.tiles, imageTile{rect: r, img: ebiten.NewImageFromImage(subImage(img, r.Add(b.Min)))})
+		}
+	}
+	return t
+}
+
+// subImage returns the part of img within r, copying only if img can't
+// share its pixels.
+func subImage(img image.Image, r image.Rectangle) image.Image {
+	if r == img.Bounds() {
+		return img
+	}
+	if s, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		return s.SubImage(r)
+	}
+	dst := image.NewRGBA(image.Rectangle{Max: r.Size()})
+	draw.Draw(dst, dst.Bounds(), img, r.Min, draw.Src)
+	return dst
+}
+
+// deallocate frees the textures. It must run on the main goroutine.
+func (t *tiledImage) deallocate() {
+	for _, tile := range t.tiles {
+		tile.img.Deallocate()
+	}
+}
+
+func (t *tiledImage) Bounds() image.Rectangle {
+	return image.Rectangle{Max: t.size}
+}
+
+// draw draws the whole image scaled and placed into ir on dst, skipping
+// tiles that fall outside dst.
+func (t *tiledImage) draw(dst *ebiten.Image, ir image.Rectangle) {
+	sx := float64(ir.Dx()) / float64(t.size.X)
+	sy := float64(ir.Dy()) / float64(t.size.Y)
+	for _, tile := range t.tiles {
+		// The extra pixel keeps tiles whose edge rounds down to dst's edge.
+		onScreen := image.Rect(
+			ir.Min.X+int(float64(tile.rect.Min.X)*sx), ir.Min.Y+int(float64(tile.rect.Min.Y)*sy),
+			ir.Min.X+int(float64(tile.rect.Max.X)*sx)+1, ir.Min.Y+int(float64(tile.rect.Max.Y)*sy)+1)
+		if !onScreen.Overlaps(dst.Bounds()) {
+			continue
+		}
+		op := &ebiten.DrawImageOptions{}
+		op.GeoM.Scale(sx, sy)
+		op.GeoM.Translate(float64(ir.Min.X)+float64(tile.rect.Min.X)*sx, float64(ir.Min.Y)+float64(tile.rect.Min.Y)*sy)
+		op.Filter = ebiten.FilterLinear
+		dst.DrawImage(tile.img, op)
+	}
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/AndreRenaud/fastmark/storage"
+)
+
+// TrackBox records that one of an image's regions belongs to an object
+// track running through a numbered frame sequence. Keyframes are drawn by
+// hand; the boxes between them are interpolated and are replaced whenever
+// the track is re-interpolated. They are stored in the image's state
+// sidecar as "track <id> key|interp <class> <x> <y> <w> <h>" lines, so the
+// label files stay plain Darknet.
+type TrackBox struct {
+	ID     int
+	Key    bool
+	Region Region
+}
+
+func (t TrackBox) String() string {
+	kind := "interp"
+	if t.Key {
+		kind = "key"
+	}
+	r := t.Region
+	return fmt.Sprintf("%d %s %d %f %f %f %f", t.ID, kind, r.index, r.xMid, r.yMid, r.width, r.height)
+}
+
+func parseTrackBox(value string) (TrackBox, bool) {
+	fields := strings.Fields(value)
+	if len(fields) != 7 || (fields[1] != "key" && fields[1] != "interp") {
+		return TrackBox{}, false
+	}
+	t := TrackBox{Key: fields[1] == "key"}
+	var err error
+	if t.ID, err = strconv.Atoi(fields[0]); err != nil || t.ID <= 0 {
+		return TrackBox{}, false
+	}
+	if t.Region.index, err = strconv.Atoi(fields[2]); err != nil {
+		return TrackBox{}, false
+	}
+	for i, f := range []*float64{&t.Region.xMid, &t.Region.yMid, &t.Region.width, &t.Region.height} {
+		if *f, err = strconv.ParseFloat(fields[3+i], 64); err != nil {
+			return TrackBox{}, false
+		}
+	}
+	return t, true
+}
+
+// sameBox reports whether two regions are the same box, whatever their
+// class, allowing for the rounding of the label files.
+func sameBox(a, b Region) bool {
+	const tolerance = 1e-5
+	return math.Abs(a.xMid-b.xMid) < tolerance && math.Abs(a.yMid-b.yMid) < tolerance &&
+		math.Abs(a.width-b.width) < tolerance && math.Abs(a.height-b.height) < tolerance
+}
+
+// track returns the track entry for region, if it belongs to one.
+func (s ImageState) track(region Region) (TrackBox, bool) {
+	for _, t := range s.Tracks {
+		if sameBox(t.Region, region) {
+			return t, true
+		}
+	}
+	return TrackBox{}, false
+}
+
+// pruneTracks drops track entries whose region has since been deleted and
+// picks up re-tagged classes, reporting whether anything changed.
+func (s *ImageState) pruneTracks(regions []Region) bool {
+	changed := false
+	s.Tracks = slices.DeleteFunc(s.Tracks, func(t TrackBox) bool {
+		j := slices.IndexFunc(regions, func(r Region) bool { return sameBox(t.Region, r) })
+		changed = changed || j < 0
+		return j < 0
+	})
+	for i, t := range s.Tracks {
+		j := slices.IndexFunc(regions, func(r Region) bool { return sameBox(t.Region, r) })
+		if regions[j].index != t.Region.index {
+			s.Tracks[i].Region.index = regions[j].index
+			changed = true
+		}
+	}
+	return changed
+}
+
+// sequenceFrame splits a file's label base name into a sequence name and a
+// frame number, so "clip-0042.png" and the video frame "clip.mp4@000042"
+// both give ("clip-", 42). ok is false for files not ending in a number.
+func sequenceFrame(file string) (sequence string, frame int, ok bool) {
+	base := labelBase(file)
+	digits := strings.TrimRightFunc(base, func(r rune) bool { return r >= '0' && r <= '9' })
+	frame, err := strconv.Atoi(base[len(digits):])
+	if err != nil {
+		return "", 0, false
+	}
+	return digits, frame, true
+}
+
+// sequenceFile is one frame of a sequence: the index into files and its
+// frame number.
+type sequenceFile struct {
+	index int
+	frame int
+}
+
+// sequenceFiles returns the files of a sequence in frame order.
+func (m *appModel) sequenceFiles(sequence string) []sequenceFile {
+	var frames []sequenceFile
+	for i, file := range m.files {
+		if s, frame, ok := sequenceFrame(file); ok && s == sequence {
+			frames = append(frames, sequenceFile{index: i, frame: frame})
+		}
+	}
+	slices.SortFunc(frames, func(a, b sequenceFile) int { return a.frame - b.frame })
+	return frames
+}
+
+// trackFrame is one frame of a sequence with its labels, for a track edit.
+type trackFrame struct {
+	sequenceFile
+	regions RegionList
+	state   ImageState
+	changed bool
+}
+
+// trackJob is a track edit to make in the background, as it reads and may
+// rewrite every frame of the sequence. The selected frame's labels are the
+// editor's copies, as they may not be saved yet; the others are loaded by
+// the job.
+type trackJob struct {
+	backend  storage.Storage
+	filesGen int
+	frames   []trackFrame
+	selected int // index into frames
+	// id is the track to edit, or 0 for a new one. keyframe, if set, is the
+	// box in the selected frame to make one of its keyframes first.
+	id       int
+	keyframe *Region
+}
+
+// trackResult is the outcome of a trackJob: the frames it changed.
+type trackResult struct {
+	filesGen int
+	id       int
+	frames   []trackFrame
+	keys     int
+	started  bool
+}
+
+// startTrackJob edits track id (or a new track if it is 0) in the selected
+// file's sequence in the background, first making keyframe, if given, one
+// of its keyframes, then re-interpolating it. The result arrives on
+// trackResults.
+func (m *appModel) startTrackJob(id int, keyframe *Region) {
+	if m.tracking {
+		log.Printf("Still updating track %d", m.activeTrack)
+		return
+	}
+	sequence, _, ok := sequenceFrame(m.currentFile())
+	if !ok {
+		return
+	}
+	job := trackJob{backend: m.backend, filesGen: m.filesGen, id: id, keyframe: keyframe}
+	for _, f := range m.sequenceFiles(sequence) {
+		frame := trackFrame{sequenceFile: f}
+		frame.regions.filename = labelFileName(m.files[f.index])
+		frame.state.filename = stateFileName(m.files[f.index])
+		if f.index == m.selectedIndex {
+			job.selected = len(job.frames)
+			frame.regions = m.currentRegions
+			frame.regions.Regions = slices.Clone(frame.regions.Regions)
+			frame.state = m.currentState
+			frame.state.Tracks = slices.Clone(frame.state.Tracks)
+		}
+		job.frames = append(job.frames, frame)
+	}
+	m.tracking = true
+	go func() {
+		m.trackResults <- job.run()
+	}()
+}
+
+// run loads the sequence's labels and makes the edit.
+func (job trackJob) run() trackResult {
+	// Edits still being saved would be read as they were.
+	labelWrites.flush()
+	frames := job.frames
+	for i := range frames {
+		if i == job.selected {
+			continue
+		}
+		f := &frames[i]
+		f.regions, _ = LoadRegionList(job.backend, f.regions.filename)
+		// Copy so the cached list isn't modified before it's saved.
+		f.regions.Regions = slices.Clone(f.regions.Regions)
+		f.state, _ = LoadImageState(job.backend, f.state.filename)
+	}
+
+	res := trackResult{filesGen: job.filesGen, id: job.id}
+	if res.id == 0 {
+		res.id = newTrackID(frames)
+		res.started = true
+	}
+	if job.keyframe != nil {
+		markKeyframe(&frames[job.selected], res.id, *job.keyframe)
+	}
+	res.keys = interpolateTrack(frames, res.id)
+	for _, f := range frames {
+		if f.changed {
+			res.frames = append(res.frames, f)
+		}
+	}
+	return res
+}
+
+// trackDone saves the frames a track edit changed.
+func (m *appModel) trackDone(res trackResult) {
+	m.tracking = false
+	// The files were listed afresh while it ran, so the indices are stale.
+	if res.filesGen != m.filesGen {
+		return
+	}
+	if res.started {
+		log.Printf("Started track %d", res.id)
+	}
+	m.activeTrack = res.id
+	for _, f := range res.frames {
+		f.regions.SaveAsync()
+		f.state.SaveAsync()
+		if f.index == m.selectedIndex {
+			m.currentRegions = f.regions
+			m.currentState = f.state
+		}
+		m.updateSummary(f.index, f.regions.Regions, f.state)
+	}
+	log.Printf("Interpolated track %d across %d keyframes, updating %d frames", res.id, res.keys, len(res.frames))
+}
+
+// markKeyframe makes region index of the selected file a keyframe of the
+// active track, starting a new track if there isn't one, and re-interpolates
+// the track. A box already in the track replaces the track's box in this
+// frame; pressing it on an existing keyframe makes its track the active one.
+func (m *appModel) markKeyframe(index int) {
+	file := m.currentFile()
+	if _, _, ok := sequenceFrame(file); !ok {
+		log.Printf("%s isn't a numbered frame, so can't be tracked", file)
+		return
+	}
+	if index < 0 || index >= len(m.currentRegions.Regions) {
+		return
+	}
+	region := m.currentRegions.Regions[index]
+	if t, ok := m.currentState.track(region); ok && t.Key {
+		m.activeTrack = t.ID
+		log.Printf("Selected track %d", t.ID)
+		return
+	} else if ok {
+		// Accepting an interpolated box keeps its track.
+		m.activeTrack = t.ID
+	}
+	log.Printf("Marking %s as a keyframe", file)
+	m.startTrackJob(m.activeTrack, &region)
+}
+
+// markKeyframe makes region a keyframe of track id in frame f.
+func markKeyframe(f *trackFrame, id int, region Region) {
+	// The track can only have one box per frame.
+	for _, t := range f.state.Tracks {
+		if t.ID == id && !sameBox(t.Region, region) {
+			if j := slices.IndexFunc(f.regions.Regions, func(r Region) bool { return sameBox(r, t.Region) }); j >= 0 {
+				f.regions.Regions = slices.Delete(f.regions.Regions, j, j+1)
+			}
+		}
+	}
+	f.state.Tracks = slices.DeleteFunc(f.state.Tracks, func(t TrackBox) bool {
+		return t.ID == id || sameBox(t.Region, region)
+	})
+	f.state.Tracks = append(f.state.Tracks, TrackBox{ID: id, Key: true, Region: region})
+	f.changed = true
+}
+
+// newTrackID returns an ID not used by any track in the sequence.
+func newTrackID(frames []trackFrame) int {
+	id := 1
+	for _, f := range frames {
+		for _, t := range f.state.Tracks {
+			id = max(id, t.ID+1)
+		}
+	}
+	return id
+}
+
+// interpolateActiveTrack re-interpolates the active track in the selected
+// file's sequence.
+func (m *appModel) interpolateActiveTrack() {
+	if m.activeTrack == 0 {
+		log.Printf("No active track to interpolate")
+		return
+	}
+	m.startTrackJob(m.activeTrack, nil)
+}
+
+// interpolateTrack writes linearly interpolated boxes for track id into every
+// frame of a sequence between two of its keyframes, replacing the boxes of
+// any earlier interpolation and removing those no longer between keyframes.
+// Boxes take the class of the keyframe before them. It returns how many
+// keyframes the track has.
+func interpolateTrack(frames []trackFrame, id int) int {
+	var keys []int // indices into frames
+	for i := range frames {
+		f := &frames[i]
+		// Keyframes whose box was deleted no longer count.
+		if f.state.pruneTracks(f.regions.Regions) {
+			f.changed = true
+		}
+		if slices.ContainsFunc(f.state.Tracks, func(t TrackBox) bool { return t.ID == id && t.Key }) {
+			keys = append(keys, i)
+		}
+	}
+
+	for i := range frames {
+		f := &frames[i]
+		if slices.Contains(keys, i) {
+			continue
+		}
+		var box Region
+		want := false
+		if k := slices.IndexFunc(keys, func(k int) bool { return k > i }); k > 0 {
+			a, _ := frames[keys[k-1]].state.keyframe(id)
+			b, _ := frames[keys[k]].state.keyframe(id)
+			t := float64(f.frame-frames[keys[k-1]].frame) / float64(frames[keys[k]].frame-frames[keys[k-1]].frame)
+			box = lerpRegion(a, b, t)
+			want = box.Normalize()
+		}
+
+		old := slices.IndexFunc(f.state.Tracks, func(t TrackBox) bool { return t.ID == id })
+		if old < 0 && !want {
+			continue
+		}
+		if old >= 0 {
+			if want && sameBox(f.state.Tracks[old].Region, box) && f.state.Tracks[old].Region.index == box.index {
+				continue
+			}
+			if j := slices.IndexFunc(f.regions.Regions, func(r Region) bool { return sameBox(r, f.state.Tracks[old].Region) }); j >= 0 {
+				f.regions.Regions = slices.Delete(f.regions.Regions, j, j+1)
+			}
+			f.state.Tracks = slices.Delete(f.state.Tracks, old, old+1)
+		}
+		if want {
+			f.regions.Regions = append(f.regions.Regions, box)
+			f.state.Tracks = append(f.state.Tracks, TrackBox{ID: id, Region: box})
+			// Interpolated frames count as labelled, not verified negatives.
+			f.state.Empty = false
+		}
+		f.changed = true
+	}
+	return len(keys)
+}
+
+// keyframe returns track id's keyframe box in the state.
+func (s ImageState) keyframe(id int) (Region, bool) {
+	for _, t := range s.Tracks {
+		if t.ID == id && t.Key {
+			return t.Region, true
+		}
+	}
+	return Region{}, false
+}
+
+// lerpRegion interpolates between regions a and b by t, keeping a's class.
+func lerpRegion(a, b Region, t float64) Region {
+	lerp := func(x, y float64) float64 { return x + (y-x)*t }
+	return Region{
+		xMid:   lerp(a.xMid, b.xMid),
+		yMid:   lerp(a.yMid, b.yMid),
+		width:  lerp(a.width, b.width),
+		height: lerp(a.height, b.height),
+		index:  a.index,
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestInterpolateTrack(t *testing.T) {
+	box := func(class int, x float64) Region {
+		return Region{xMid: x, yMid: 0.5, width: 0.2, height: 0.2, index: class}
+	}
+	key := func(r Region) TrackBox { return TrackBox{ID: 1, Key: true, Region: r} }
+	interp := func(r Region) TrackBox { return TrackBox{ID: 1, Region: r} }
+	// frame is a sequence frame holding regions, with tracks in its state.
+	type frame struct {
+		number  int
+		regions []Region
+		tracks  []TrackBox
+	}
+
+	tests := []struct {
+		name   string
+		frames []frame
+		keys   int
+		want   []frame // the regions and tracks afterwards
+	}{
+		{
+			name: "between two keyframes",
+			frames: []frame{
+				{number: 10, regions: []Region{box(0, 0.2)}, tracks: []TrackBox{key(box(0, 0.2))}},
+				{number: 11},
+				{number: 12, regions: []Region{box(2, 0.9)}},
+				{number: 14, regions: []Region{box(1, 0.6)}, tracks: []TrackBox{key(box(1, 0.6))}},
+			},
+			keys: 2,
+			want: []frame{
+				{regions: []Region{box(0, 0.2)}, tracks: []TrackBox{key(box(0, 0.2))}},
+				{regions: []Region{box(0, 0.3)}, tracks: []TrackBox{interp(box(0, 0.3))}},
+				{regions: []Region{box(2, 0.9), box(0, 0.4)}, tracks: []TrackBox{interp(box(0, 0.4))}},
+				{regions: []Region{box(1, 0.6)}, tracks: []TrackBox{key(box(1, 0.6))}},
+			},
+		},
+		{
+			name: "stale boxes replaced and removed",
+			frames: []frame{
+				{number: 1, regions: []Region{box(0, 0.2)}, tracks: []TrackBox{key(box(0, 0.2))}},
+				{number: 2, regions: []Region{box(0, 0.5)}, tracks: []TrackBox{interp(box(0, 0.5))}},
+				{number: 3, regions: []Region{box(0, 0.4)}, tracks: []TrackBox{key(box(0, 0.4))}},
+				{number: 4, regions: []Region{box(0, 0.7)}, tracks: []TrackBox{interp(box(0, 0.7))}},
+			},
+			keys: 2,
+			want: []frame{
+				{regions: []Region{box(0, 0.2)}, tracks: []TrackBox{key(box(0, 0.2))}},
+				{regions: []Region{box(0, 0.3)}, tracks: []TrackBox{interp(box(0, 0.3))}},
+				{regions: []Region{box(0, 0.4)}, tracks: []TrackBox{key(box(0, 0.4))}},
+				{},
+			},
+		},
+		{
+			name: "deleted keyframe no longer counts",
+			frames: []frame{
+				{number: 1, regions: []Region{box(0, 0.2)}, tracks: []TrackBox{key(box(0, 0.2))}},
+				{number: 2, regions: []Region{box(0, 0.3)}, tracks: []TrackBox{interp(box(0, 0.3))}},
+				{number: 3, tracks: []TrackBox{key(box(0, 0.4))}},
+			},
+			keys: 1,
+			want: []frame{
+				{regions: []Region{box(0, 0.2)}, tracks: []TrackBox{key(box(0, 0.2))}},
+				{},
+				{},
+			},
+		},
+		{
+			name: "other tracks left alone",
+			frames: []frame{
+				{number: 1, regions: []Region{box(0, 0.2), box(3, 0.8)}, tracks: []TrackBox{key(box(0, 0.2)), {ID: 2, Key: true, Region: box(3, 0.8)}}},
+				{number: 2, regions: []Region{box(3, 0.8)}, tracks: []TrackBox{{ID: 2, Region: box(3, 0.8)}}},
+				{number: 3, regions: []Region{box(0, 0.4)}, tracks: []TrackBox{key(box(0, 0.4))}},
+			},
+			keys: 2,
+			want: []frame{
+				{regions: []Region{box(0, 0.2), box(3, 0.8)}, tracks: []TrackBox{key(box(0, 0.2)), {ID: 2, Key: true, Region: box(3, 0.8)}}},
+				{regions: []Region{box(3, 0.8), box(0, 0.3)}, tracks: []TrackBox{{ID: 2, Region: box(3, 0.8)}, interp(box(0, 0.3))}},
+				{regions: []Region{box(0, 0.4)}, tracks: []TrackBox{key(box(0, 0.4))}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frames := make([]trackFrame, len(tt.frames))
+			for i, f := range tt.frames {
+				frames[i].frame = f.number
+				frames[i].regions.Regions = f.regions
+				frames[i].state.Tracks = f.tracks
+			}
+			if keys := interpolateTrack(frames, 1); keys != tt.keys {
+				t.Errorf("interpolateTrack = %d keyframes, want %d", keys, tt.keys)
+			}
+			for i, want := range tt.want {
+				got := frames[i]
+				if !sameRegions(got.regions.Regions, want.regions) {
+					t.Errorf("frame %d regions = %v, want %v", tt.frames[i].number, got.regions.Regions, want.regions)
+				}
+				if len(got.state.Tracks) != len(want.tracks) {
+					t.Errorf("frame %d tracks = %v, want %v", tt.frames[i].number, got.state.Tracks, want.tracks)
+					continue
+				}
+				for j, track := range got.state.Tracks {
+					w := want.tracks[j]
+					if track.ID != w.ID || track.Key != w.Key || !sameRegions([]Region{track.Region}, []Region{w.Region}) {
+						t.Errorf("frame %d tracks = %v, want %v", tt.frames[i].number, got.state.Tracks, want.tracks)
+						break
+					}
+				}
+			}
+		})
+	}
+}
+
+// sameRegions reports whether two lists hold the same boxes and classes in
+// the same order.
+func sameRegions(a, b []Region) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !sameBox(a[i], b[i]) || a[i].index != b[i].index {
+			return false
+		}
+	}
+	return true
+}
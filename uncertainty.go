@@ -0,0 +1,83 @@
+package main
+
+import (
+	"log"
+)
+
+// nearThresholdBand is how close to the suggestion threshold a prediction's
+// confidence must be to count as borderline.
+const nearThresholdBand = 0.15
+
+// predictionUncertainty scores how much labelling an image would teach the
+// detector, from its predictions: 0 when it is sure of everything, up to 1.
+// It is the mean of three signals: how low its most confident prediction is;
+// how closely boxes of different classes compete for the same object; and
+// how many predictions are borderline around threshold. An image without
+// predictions scores 0, as the detector is sure there's nothing there.
+func predictionUncertainty(predictions []Suggestion, threshold float64) float64 {
+	if len(predictions) == 0 {
+		return 0
+	}
+	var best, disagreement float64
+	borderline := 0
+	for i, p := range predictions {
+		best = max(best, p.Confidence)
+		if p.Confidence > threshold-nearThresholdBand && p.Confidence < threshold+nearThresholdBand {
+			borderline++
+		}
+		for _, q := range predictions[i+1:] {
+			if p.Region.index != q.Region.index && regionOverlap(p.Region, q.Region) > 0.5 {
+				// Two classes equally sure of one box is the worst case.
+				disagreement = max(disagreement, min(p.Confidence, q.Confidence)/max(p.Confidence, q.Confidence))
+			}
+		}
+	}
+	lowConfidence := 1 - min(best, 1)
+	borderlineScore := float64(borderline) / float64(borderline+1)
+	return (lowConfidence + disagreement + borderlineScore) / 3
+}
+
+// updateUncertainty re-reads file index i's predictions after they have
+// changed and updates its summary.
+func (m *appModel) updateUncertainty(i int) {
+	if i < 0 || i >= len(m.files) {
+		return
+	}
+	predictions, err := LoadPredictions(m.backend, predictionFileName(m.settings.predictionsDir(), m.files[i]))
+	iou, threshold := m.comparison()
+	m.metadataMu.Lock()
+	defer m.metadataMu.Unlock()
+	if i >= len(m.summaries) || !m.summaries[i].scanned {
+		return
+	}
+	m.summaries[i].predicted = err == nil
+	m.summaries[i].predictions = predictions
+	m.summaries[i].scorePredictions(iou, threshold)
+	if m.sortOrder == sortUncertainty || len(m.fileFilter.terms) > 0 {
+		m.viewGen++
+	}
+}
+
+// mostUncertain returns the listed file still needing labelling whose
+// predictions are the most uncertain, other than the selected one, or -1.
+func (m *appModel) mostUncertain() int {
+	m.metadataMu.Lock()
+	defer m.metadataMu.Unlock()
+	best, bestScore := -1, -1.0
+	for _, i := range m.visible {
+		if i == m.selectedIndex || i >= len(m.summaries) {
+			continue
+		}
+		s := m.summaries[i]
+		if !s.predicted || s.status != StatusUnlabelled {
+			continue
+		}
+		if s.uncertainty > bestScore {
+			best, bestScore = i, s.uncertainty
+		}
+	}
+	if best < 0 {
+		log.Printf("No unlabelled images with predictions; run the metadata scan after adding predictions")
+	}
+	return best
+}
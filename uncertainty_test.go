@@ -0,0 +1,33 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPredictionUncertainty(t *testing.T) {
+	box := func(class int, x, confidence float64) Suggestion {
+		return Suggestion{Region: Region{xMid: x, yMid: 0.5, width: 0.2, height: 0.2, index: class}, Confidence: confidence}
+	}
+	tests := []struct {
+		name        string
+		predictions []Suggestion
+		want        float64
+	}{
+		{"no predictions", nil, 0},
+		{"sure", []Suggestion{box(0, 0.5, 1)}, 0},
+		{"over-confident", []Suggestion{box(0, 0.5, 1.2)}, 0},
+		{"fairly sure", []Suggestion{box(0, 0.5, 0.94)}, 0.06 / 3},
+		{"borderline", []Suggestion{box(0, 0.5, 0.5)}, (0.5 + 0.5) / 3},
+		{"two borderline", []Suggestion{box(0, 0.2, 0.45), box(0, 0.8, 0.55)}, (0.45 + 2.0/3) / 3},
+		{"classes compete", []Suggestion{box(0, 0.5, 0.9), box(1, 0.51, 0.9)}, (0.1 + 1) / 3},
+		{"one class much surer", []Suggestion{box(0, 0.5, 0.9), box(1, 0.5, 0.3)}, (0.1 + 1.0/3) / 3},
+		{"same class overlapping", []Suggestion{box(0, 0.5, 0.9), box(0, 0.51, 0.9)}, 0.1 / 3},
+		{"classes apart", []Suggestion{box(0, 0.2, 0.9), box(1, 0.8, 0.9)}, 0.1 / 3},
+	}
+	for _, tt := range tests {
+		if got := predictionUncertainty(tt.predictions, 0.5); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: predictionUncertainty = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AndreRenaud/fastmark/storage"
+)
+
+// videoExtensions are the files in images/ that are decoded with ffmpeg and
+// listed as one virtual file per frame.
+var videoExtensions = []string{".mp4", ".m4v", ".mov", ".avi", ".mkv", ".webm"}
+
+// videoFrameSeparator joins a video's file name and a frame number into the
+// name of a virtual file, such as "clip.mp4@000042".
+const videoFrameSeparator = "@"
+
+func isVideoFile(name string) bool {
+	return slices.Contains(videoExtensions, strings.ToLower(filepath.Ext(name)))
+}
+
+func videoFrameName(video string, frame int) string {
+	return fmt.Sprintf("%s%s%06d", video, videoFrameSeparator, frame)
+}
+
+// parseVideoFrame splits a virtual frame file name into the video and frame.
+func parseVideoFrame(name string) (video string, frame int, ok bool) {
+	video, number, ok := strings.Cut(name, videoFrameSeparator)
+	if !ok || !isVideoFile(video) {
+		return "", 0, false
+	}
+	frame, err := strconv.Atoi(number)
+	if err != nil || frame < 0 {
+		return "", 0, false
+	}
+	return video, frame, true
+}
+
+// frameBaseName is the name shared by a frame's label file and its exported
+// image, such as "clip-000042".
+func frameBaseName(video string, frame int) string {
+	return fmt.Sprintf("%s-%06d", strings.TrimSuffix(video, filepath.Ext(video)), frame)
+}
+
+// videoInfo is what is needed to find a video's frames.
+type videoInfo struct {
+	frames int
+	rate   string // frames per second as ffprobe gives it, e.g. "30000/1001"
+}
+
+// frameTime returns a quarter of a frame before frame starts, in seconds, so
+// rounding can't make a seek skip it.
+func (v videoInfo) frameTime(frame int) (float64, error) {
+	num, den, ok := strings.Cut(v.rate, "/")
+	if !ok {
+		den = "1"
+	}
+	n, err1 := strconv.ParseFloat(num, 64)
+	d, err2 := strconv.ParseFloat(den, 64)
+	if err1 != nil || err2 != nil || n <= 0 || d <= 0 {
+		return 0, fmt.Errorf("invalid frame rate %q", v.rate)
+	}
+	return max(0, (float64(frame)-0.25)*d/n), nil
+}
+
+// videoInfos caches probed videos, as every frame decode needs the rate, and
+// listing the frames shouldn't probe again. Failures are cached too, so a
+// broken video isn't probed on every refresh.
+var videoInfos struct {
+	mu    sync.Mutex
+	infos map[string]probedVideo
+}
+
+type probedVideo struct {
+	info videoInfo
+	err  error
+}
+
+func videoKey(backend storage.Storage, filename string) string {
+	return backend.Describe() + "/" + filename
+}
+
+// cachedVideoInfo returns what probing filename found, and false if it
+// hasn't been probed.
+func cachedVideoInfo(backend storage.Storage, filename string) (videoInfo, error, bool) {
+	videoInfos.mu.Lock()
+	defer videoInfos.mu.Unlock()
+	p, ok := videoInfos.infos[videoKey(backend, filename)]
+	return p.info, p.err, ok
+}
+
+// videoCopies holds local copies of the videos of remote backends, so
+// ffmpeg can seek in them rather than each frame fetching the whole file.
+// They are removed by removeVideoCopies.
+var videoCopies struct {
+	mu     sync.Mutex
+	dir    string
+	copies map[string]*videoCopy
+}
+
+// videoCopy is a local copy of a remote video, and the size and time of the
+// remote file it was copied from.
+type videoCopy struct {
+	mu      sync.Mutex
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// videoPath returns the path of filename that ffmpeg is given. Local files
+// are used directly; others are copied to a temporary file the first time,
+// and again if they change.
+func videoPath(backend storage.Storage, filename string) (string, error) {
+	if local, ok := backend.(interface{ LocalPath(string) string }); ok {
+		return local.LocalPath(filename), nil
+	}
+	info, err := backend.Stat(filename)
+	if err != nil {
+		return "", err
+	}
+
+	key := videoKey(backend, filename)
+	videoCopies.mu.Lock()
+	if videoCopies.dir == "" {
+		if videoCopies.dir, err = os.MkdirTemp("", "fastmark-videos-"); err != nil {
+			videoCopies.mu.Unlock()
+			return "", err
+		}
+		videoCopies.copies = map[string]*videoCopy{}
+	}
+	c := videoCopies.copies[key]
+	if c == nil {
+		c = &videoCopy{path: filepath.Join(videoCopies.dir, fmt.Sprintf("%d%s", len(videoCopies.copies), filepath.Ext(filename)))}
+		videoCopies.copies[key] = c
+	}
+	videoCopies.mu.Unlock()
+
+	// Frames decoded at once wait for one copy.
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.size == info.Size() && c.modTime.Equal(info.ModTime()) {
+		return c.path, nil
+	}
+	if err := copyToFile(backend, filename, c.path); err != nil {
+		os.Remove(c.path)
+		c.size, c.modTime = 0, time.Time{}
+		return "", err
+	}
+	c.size, c.modTime = info.Size(), info.ModTime()
+	return c.path, nil
+}
+
+// copyToFile copies filename from backend to the local file path.
+func copyToFile(backend storage.Storage, filename, path string) error {
+	r, err := backend.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// removeVideoCopies deletes the local copies of remote videos.
+func removeVideoCopies() {
+	videoCopies.mu.Lock()
+	defer videoCopies.mu.Unlock()
+	if videoCopies.dir != "" {
+		os.RemoveAll(videoCopies.dir)
+		videoCopies.dir, videoCopies.copies = "", nil
+	}
+}
+
+// runFFmpeg runs tool (ffmpeg or ffprobe) on filename, with the arguments
+// args returns for the input, and returns its output.
+func runFFmpeg(ctx context.Context, backend storage.Storage, filename string, tool string, args func(input string) []string) ([]byte, error) {
+	input, err := videoPath(backend, filename)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.CommandContext(ctx, tool, args(input)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w: %s", tool, filename, err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// probeVideo finds a video's frame count and rate with ffprobe, or returns
+// what an earlier probe found.
+func probeVideo(ctx context.Context, backend storage.Storage, filename string) (videoInfo, error) {
+	if info, err, ok := cachedVideoInfo(backend, filename); ok {
+		return info, err
+	}
+	info, err := runProbe(ctx, backend, filename)
+	// A cancelled probe says nothing about the video.
+	if ctx.Err() != nil {
+		return info, err
+	}
+	videoInfos.mu.Lock()
+	if videoInfos.infos == nil {
+		videoInfos.infos = map[string]probedVideo{}
+	}
+	videoInfos.infos[videoKey(backend, filename)] = probedVideo{info, err}
+	videoInfos.mu.Unlock()
+	return info, err
+}
+
+// runProbe runs ffprobe on a video. The frame count in the container is used
+// if there is one; otherwise the packets are counted.
+func runProbe(ctx context.Context, backend storage.Storage, filename string) (videoInfo, error) {
+	var info videoInfo
+	probe := func(entries string, extra ...string) ([]string, error) {
+		out, err := runFFmpeg(ctx, backend, filename, "ffprobe", func(input string) []string {
+			args := append([]string{"-v", "error", "-select_streams", "v:0"}, extra...)
+			return append(args, "-show_entries", "stream="+entries, "-of", "csv=p=0", input)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return strings.Split(strings.TrimSpace(string(out)), ","), nil
+	}
+	fields, err := probe("r_frame_rate,nb_frames")
+	if err != nil {
+		return info, err
+	}
+	if len(fields) != 2 {
+		return info, fmt.Errorf("unexpected ffprobe output %q for %s", fields, filename)
+	}
+	info.rate = fields[0]
+	if info.frames, err = strconv.Atoi(fields[1]); err != nil {
+		counted, err := probe("nb_read_packets", "-count_packets")
+		if err != nil {
+			return info, err
+		}
+		if info.frames, err = strconv.Atoi(counted[0]); err != nil {
+			return info, fmt.Errorf("no frame count for %s", filename)
+		}
+	}
+	return info, nil
+}
+
+// videoFramePNG extracts one frame of a video as a PNG.
+func videoFramePNG(ctx context.Context, backend storage.Storage, video string, frame int) ([]byte, error) {
+	filename := imagePath(video)
+	info, err := probeVideo(ctx, backend, filename)
+	if err != nil {
+		return nil, err
+	}
+	if frame >= info.frames {
+		return nil, fmt.Errorf("%s has no frame %d", video, frame)
+	}
+	t, err := info.frameTime(frame)
+	if err != nil {
+		return nil, err
+	}
+	// Seeking before the input is fast, and exact since ffmpeg decodes from
+	// the preceding keyframe and discards frames before the requested time.
+	return runFFmpeg(ctx, backend, filename, "ffmpeg", func(input string) []string {
+		return []string{"-v", "error", "-ss", strconv.FormatFloat(t, 'f', 6, 64), "-i", input,
+			"-frames:v", "1", "-f", "image2pipe", "-c:v", "png", "pipe:1"}
+	})
+}
+
+// loadVideoFrame decodes one frame of a video in images/.
+func loadVideoFrame(ctx context.Context, backend storage.Storage, video string, frame int) (image.Image, error) {
+	data, err := videoFramePNG(ctx, backend, video, frame)
+	if err != nil {
+		return nil, err
+	}
+	return png.Decode(bytes.NewReader(data))
+}
+
+// videoFrames lists the virtual frame files of a video, leaving out frames
+// that have been exported as images of their own.
+func videoFrames(info videoInfo, video string, images map[string]bool) []string {
+	frames := make([]string, 0, info.frames)
+	for frame := range info.frames {
+		if !images[frameBaseName(video, frame)] {
+			frames = append(frames, videoFrameName(video, frame))
+		}
+	}
+	return frames
+}
+
+// listImages returns the images in the dataset, with each video expanded
+// into its frames, sorted by name. A dataset split by data.yaml lists the
+// images of every split, named relative to images/, and one with Darknet
+// image lists the images in them.
+func listImages(backend storage.Storage) ([]string, error) {
+	layout, err := loadDatasetLayout(backend)
+	if err != nil {
+		return nil, fmt.Errorf("loading %s: %w", layout.dataFile, err)
+	}
+	files, _, err := listLayoutImages(backend, layout, true)
+	return files, err
+}
+
+// listLayoutImages lists the images of a dataset with the given layout, as
+// listImages does. Videos that haven't been probed are probed first if probe
+// is set; otherwise their frames are left out, and the videos returned as
+// unprobed so they can be probed in the background.
+func listLayoutImages(backend storage.Storage, layout datasetLayout, probe bool) (files, unprobed []string, err error) {
+	if len(layout.splits) == 0 {
+		return listImagesIn(backend, "images", probe)
+	}
+	for _, split := range layout.splits {
+		if split.list != "" {
+			files = append(files, split.files...)
+			continue
+		}
+		f, u, err := listImagesIn(backend, split.dir, probe)
+		if err != nil {
+			return nil, nil, err
+		}
+		files = append(files, f...)
+		unprobed = append(unprobed, u...)
+	}
+	slices.Sort(files)
+	// Splits may share a directory.
+	return slices.Compact(files), unprobed, nil
+}
+
+// listImagesIn lists the images in a directory of the dataset, named as
+// imageName names them, probing videos or returning them as unprobed as
+// listLayoutImages does.
+func listImagesIn(backend storage.Storage, dir string, probe bool) (files, unprobed []string, err error) {
+	match, err := backend.Glob(dir, "*")
+	if err != nil {
+		return nil, nil, err
+	}
+	var videos []string
+	bases := map[string]bool{}
+	for _, f := range match {
+		name := imageName(filepath.Join(dir, filepath.Base(f)))
+		switch {
+		case isImageFile(name):
+			files = append(files, name)
+			bases[labelBase(name)] = true
+		case isVideoFile(name):
+			videos = append(videos, name)
+		}
+	}
+	for _, video := range videos {
+		info, err, ok := cachedVideoInfo(backend, imagePath(video))
+		if !ok && !probe {
+			unprobed = append(unprobed, video)
+			continue
+		} else if !ok {
+			info, err = probeVideo(context.Background(), backend, imagePath(video))
+		}
+		if err != nil {
+			log.Printf("Error reading video %s: %s", video, err)
+			continue
+		}
+		files = append(files, videoFrames(info, video, bases)...)
+	}
+	slices.Sort(files)
+	return files, unprobed, nil
+}
+
+// probeVideos probes videos in the background, then sends backend on
+// videosProbed so the file list can be refreshed with their frames.
+func (m *appModel) probeVideos(videos []string) {
+	if m.probing {
+		// The refresh after the running probe picks these up.
+		return
+	}
+	m.probing = true
+	log.Printf("Reading %d videos", len(videos))
+	backend := m.backend
+	go func() {
+		for _, video := range videos {
+			probeVideo(context.Background(), backend, imagePath(video))
+		}
+		m.videosProbed <- backend
+	}()
+}
+
+// exportLabelledFrames writes every video frame that has a label file as a
+// PNG in images/, named to match its label file, so training tools that
+// can't read video see just the labelled frames. Frames verified to be
+// empty have an empty label file, so are exported as background images.
+func exportLabelledFrames(backend storage.Storage) error {
+	files, err := listImages(backend)
+	if err != nil {
+		return err
+	}
+	exported := 0
+	for _, file := range files {
+		video, frame, ok := parseVideoFrame(file)
+		if !ok {
+			continue
+		}
+		if _, err := backend.Stat(labelFileName(file)); err != nil {
+			continue
+		}
+		data, err := videoFramePNG(context.Background(), backend, video, frame)
+		if err != nil {
+			return err
+		}
+		w, err := backend.OpenWrite(imagePath(frameBaseName(video, frame)+".png"), false)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			w.Close()
+			return err
+		}
+		if err := w.Close(); err != nil {
+			return err
+		}
+		exported++
+	}
+	log.Printf("Exported %d labelled frames", exported)
+	return nil
+}